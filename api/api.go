@@ -3,23 +3,97 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrNotFound is returned by a DB when the requested resource does not
+	// exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by a DB when a resource with the same id
+	// already exists.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is returned by a DB when it cannot be reached.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrForbidden is returned by a DB when the user may not change the
+	// resource.
+	ErrForbidden = errors.New("forbidden")
+	// ErrVersionMismatch is returned by a DB when a conditional update finds
+	// a different version of the resource.
+	ErrVersionMismatch = errors.New("version mismatch")
 )
 
-// A DB provides a storage layer that persists messages.
+// A DB provides a storage layer that persists messages. The data of every
+// app is isolated: a channel, message or reaction of one app is not found
+// through another app.
 type DB interface {
-	ListMessages(ctx context.Context, excludeMsgIDs ...string) ([]Message, error)
+	InsertChannel(ctx context.Context, channel Channel) (Channel, error)
+	GetChannel(ctx context.Context, appID, channelID string) (Channel, error)
+	ListMessages(ctx context.Context, opts ListOptions, excludeMsgIDs ...string) ([]Message, error)
+	// GetMessage returns a message, including deleted ones.
+	GetMessage(ctx context.Context, appID, msgID string) (Message, error)
+	// InsertMessage adds a message to its channel. A reply is added to the
+	// reply count of its parent. It returns ErrNotFound if the channel or
+	// the parent does not exist, or if the parent was deleted.
 	InsertMessage(ctx context.Context, msg Message) (Message, error)
+	// UpdateMessage changes the text of a message, increments its version
+	// and keeps the replaced text in its history.
+	UpdateMessage(ctx context.Context, upd MessageUpdate) (Message, error)
+	// MessageHistory returns the prior versions of a message, newest first.
+	MessageHistory(ctx context.Context, appID, msgID string) ([]MessageVersion, error)
+	// DeleteMessage marks a message as deleted and returns it. Deleted
+	// messages keep their reactions and history until they are purged. A
+	// deleted reply no longer counts towards the reply count of its parent.
+	DeleteMessage(ctx context.Context, del MessageDeletion) (Message, error)
+	// PurgeMessages permanently removes the messages of all apps deleted
+	// before the given time and returns how many were removed. A message
+	// with replies is kept as a stub without text, reactions or history, so
+	// that its replies stay in their thread. Stubs are removed, and counted,
+	// by the first purge after their last reply is removed.
+	PurgeMessages(ctx context.Context, deletedBefore time.Time) (int, error)
+	// InsertReaction adds a reaction to a message. A user holds at most one
+	// reaction of each type to a message, so if the user already reacted
+	// with the type, the score is added to the existing reaction instead.
+	// created reports whether the reaction was added.
+	InsertReaction(ctx context.Context, reaction Reaction, opts ReactionOptions) (_ Reaction, created bool, err error)
+	// SetReaction sets the score of the reaction of reaction.UserID of type
+	// reaction.Type to the message, adding the reaction if the user has
+	// none. created reports whether it was added.
+	SetReaction(ctx context.Context, reaction Reaction, opts ReactionOptions) (_ Reaction, created bool, err error)
+	// DeleteReaction removes the reactions of a user of the given type from
+	// a message and returns how many were removed.
+	DeleteReaction(ctx context.Context, appID, msgID, userID, reactionType string) (int, error)
+	ReactionSummaries(ctx context.Context, appID string, msgIDs []string, latest int) (map[string]ReactionSummary, error)
 }
 
-// A Cache provides a storage layer that caches messages.
+// latestReactions is the number of reactions included with each message when
+// listing messages.
+const latestReactions = 5
+
+// defaultMaxReactionScore is the default cap of the score a user can give a
+// message with one reaction type.
+const defaultMaxReactionScore = 50
+
+// A Cache provides a storage layer that caches the latest messages of each
+// channel of each app.
 type Cache interface {
-	ListMessages(ctx context.Context) ([]Message, error)
+	ListMessages(ctx context.Context, appID, channelID string) ([]Message, error)
 	InsertMessage(ctx context.Context, msg Message) error
+	// UpdateMessage replaces a cached message. Messages that are not cached
+	// are ignored. Deleted messages stay cached as tombstones.
+	UpdateMessage(ctx context.Context, msg Message) error
+	Clear(ctx context.Context) error
 }
 
 // API provides the REST endpoints for the application.
@@ -27,25 +101,129 @@ type API struct {
 	Logger *slog.Logger
 	DB     DB
 	Cache  Cache
+	// Queue, if set, holds new messages while the DB is unavailable.
+	Queue Queue
+	// Bus, if set, delivers the realtime events to the clients of all
+	// instances. RelayEvents has to run for this instance to receive them.
+	Bus EventBus
+	// Apps, if set, requires an API key on every request but the health
+	// checks, and scopes the request to the app of the key. Without it, all
+	// requests belong to the default app.
+	Apps AppStore
+	// Metrics, if set, records request and cache metrics.
+	Metrics *Metrics
+	// TracerProvider creates the request spans. It defaults to the global
+	// provider.
+	TracerProvider trace.TracerProvider
+	// PingTimeout is the time the DB and Cache have to answer a readiness
+	// check. It defaults to 2s.
+	PingTimeout time.Duration
+	// MaxReactionScore caps the score a user can give a message with one
+	// reaction type, such as the number of claps. Scores are always capped:
+	// zero or less means the default of 50.
+	MaxReactionScore int
+	// ExclusiveReactions limits every user to one reaction type per
+	// message. Reacting with another type replaces the previous reaction.
+	ExclusiveReactions bool
+	// Auth, if set, requires every request but the health checks to carry a
+	// JWT, and the requests act as the user named by the token.
+	Auth *Auth
+	// Moderators are the ids of the users that may delete any message and
+	// list deleted messages.
+	Moderators []string
+	// EventBuffer is the number of recent events kept for clients that
+	// resume a stream with Last-Event-ID. It defaults to 1000.
+	EventBuffer int
+	// HeartbeatInterval is the time between heartbeats on an idle event
+	// stream. It defaults to 15s.
+	HeartbeatInterval time.Duration
+	// MaxConnectionsPerUser limits the WebSocket connections a user may hold
+	// at once. It defaults to 5.
+	MaxConnectionsPerUser int
+	// WebSocketPingInterval is the time between pings that check that a
+	// WebSocket client is alive. It defaults to 30s.
+	WebSocketPingInterval time.Duration
+	// RateLimiter, if set, counts the requests to the routes in RateLimits.
+	RateLimiter RateLimiter
+	// RateLimits are the limits of the routes by pattern, such as
+	// "POST /messages". They apply to every IP address and, with Auth, to
+	// every user. Without Auth, users are not known, since the user_id of a
+	// request can be anything. The limit of "POST /messages" applies to
+	// messages sent over WebSocket connections as well.
+	RateLimits map[string]RateLimit
+	// TrustForwardedFor identifies clients by the X-Forwarded-For header
+	// rather than the remote address. It must only be set behind a proxy
+	// that sets the header.
+	TrustForwardedFor bool
+	// Idempotency, if set, stores the responses to POST requests with an
+	// Idempotency-Key header, so that retries get the same response.
+	Idempotency IdempotencyStore
+	// IdempotencyTTL is how long responses are kept for retries. It
+	// defaults to 24h.
+	IdempotencyTTL time.Duration
 
-	once sync.Once
-	mux  *http.ServeMux
+	once     sync.Once
+	mux      *http.ServeMux
+	events   *hub
+	draining atomic.Bool
+	connsMu  sync.Mutex
+	conns    map[string]int // WebSocket connections by user id
 }
 
 func (a *API) setupRoutes() {
 	mux := http.NewServeMux()
+	handle := func(method, route string, h http.HandlerFunc) {
+		mux.HandleFunc(method+" "+route, a.Metrics.instrument(method, route, traceRoute(method, route, h)))
+	}
 
-	mux.HandleFunc("GET /messages", a.listMessages)
-	mux.HandleFunc("POST /messages", a.createMessage)
-	mux.HandleFunc("POST /messages/{messageID}/reactions", a.createReaction)
+	handle("GET", "/healthz", a.healthz)
+	handle("GET", "/readyz", a.readyz)
+	// Every other route belongs to an app, requires authentication and may
+	// be rate limited. POST requests may be retried with an idempotency key.
+	// Retries are answered before the rate limit is checked, since they do
+	// not run the request again.
+	authed := func(method, route string, h http.HandlerFunc) {
+		h = a.rateLimit(method+" "+route, h)
+		if method == "POST" {
+			h = a.idempotent(h)
+		}
+		handle(method, route, a.identifyApp(false, a.authenticate(false, h)))
+	}
+	// The routes of EventSource and WebSocket clients take the token from
+	// the query, since the clients cannot set headers.
+	streamed := func(method, route string, h http.HandlerFunc) {
+		handle(method, route, a.identifyApp(true, a.authenticate(true, a.rateLimit(method+" "+route, h))))
+	}
+	authed("POST", "/channels", a.createChannel)
+	authed("GET", "/channels/{channelID}", a.getChannel)
+	streamed("GET", "/ws", a.serveWebSocket)
+
+	// The message routes without a channel serve the default channel.
+	for _, prefix := range []string{"", "/channels/{channelID}"} {
+		authed("GET", prefix+"/messages", a.listMessages)
+		streamed("GET", prefix+"/messages/stream", a.streamMessages)
+		authed("POST", prefix+"/messages", a.createMessage)
+		authed("PATCH", prefix+"/messages/{messageID}", a.inChannel(a.updateMessage))
+		authed("DELETE", prefix+"/messages/{messageID}", a.inChannel(a.deleteMessage))
+		authed("GET", prefix+"/messages/{messageID}/history", a.inChannel(a.messageHistory))
+		authed("GET", prefix+"/messages/{messageID}/replies", a.inChannel(a.listMessages))
+		authed("POST", prefix+"/messages/{messageID}/replies", a.inChannel(a.createReply))
+		authed("POST", prefix+"/messages/{messageID}/reactions", a.inChannel(a.createReaction))
+		authed("PUT", prefix+"/messages/{messageID}/reactions/{type}", a.inChannel(a.setReaction))
+		authed("DELETE", prefix+"/messages/{messageID}/reactions/{type}", a.inChannel(a.deleteReaction))
+	}
 
 	a.mux = mux
+	a.events = newHub(a.EventBuffer)
 }
 
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.once.Do(a.setupRoutes)
 	a.Logger.Info("Request received", "method", r.Method, "path", r.URL.Path)
-	a.mux.ServeHTTP(w, r)
+	r, span := a.startRequestSpan(r)
+	sw := &statusWriter{ResponseWriter: w}
+	a.mux.ServeHTTP(sw, r)
+	endRequestSpan(span, sw.status())
 }
 
 func (a *API) respond(w http.ResponseWriter, status int, body any) {
@@ -57,62 +235,390 @@ func (a *API) respond(w http.ResponseWriter, status int, body any) {
 }
 
 func (a *API) respondError(w http.ResponseWriter, status int, err error, msg string) {
+	a.respondFieldErrors(w, status, err, msg)
+}
+
+// respondFieldErrors responds with an error that lists the invalid fields of
+// the request.
+func (a *API) respondFieldErrors(w http.ResponseWriter, status int, err error, msg string, fields ...FieldError) {
 	type response struct {
-		Error string `json:"error"`
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields,omitempty"`
 	}
 	a.Logger.Error("Error", "error", err.Error())
-	a.respond(w, status, response{Error: msg})
+	a.respond(w, status, response{Error: msg, Fields: fields})
+}
+
+// respondInvalid responds with the field errors collected by v.
+func (a *API) respondInvalid(w http.ResponseWriter, v *validator) {
+	err := fmt.Errorf("invalid request: %d field errors", len(v.errs))
+	a.respondFieldErrors(w, http.StatusUnprocessableEntity, err, "Invalid request", v.errs...)
 }
 
+// listMessages lists the messages of a channel, or the replies to a message
+// on the replies route.
 func (a *API) listMessages(w http.ResponseWriter, r *http.Request) {
-	type message struct {
+	type reaction struct {
 		ID        string `json:"id"`
-		Text      string `json:"text"`
+		Type      string `json:"type"`
+		Score     int    `json:"score"`
 		UserID    string `json:"user_id"`
 		CreatedAt string `json:"created_at"`
 	}
+	type message struct {
+		ID              string         `json:"id"`
+		Text            string         `json:"text"`
+		UserID          string         `json:"user_id"`
+		CreatedAt       string         `json:"created_at"`
+		UpdatedAt       string         `json:"updated_at,omitempty"` // set if the message was edited
+		Version         int            `json:"version,omitempty"`
+		DeletedAt       string         `json:"deleted_at,omitempty"`      // set if the message was deleted
+		ParentID        string         `json:"parent_id,omitempty"`       // set if the message is a reply
+		ShowInChannel   bool           `json:"show_in_channel,omitempty"` // set if the reply is listed in the channel
+		ReplyCount      int            `json:"reply_count,omitempty"`
+		LatestReplyAt   string         `json:"latest_reply_at,omitempty"`
+		ReactionCount   int            `json:"reaction_count"`
+		TotalScore      int            `json:"total_score"`
+		ReactionCounts  map[string]int `json:"reaction_counts"`
+		ReactionScores  map[string]int `json:"reaction_scores"`
+		LatestReactions []reaction     `json:"latest_reactions"`
+	}
 	type response struct {
 		Messages []message `json:"messages"`
+		Next     string    `json:"next,omitempty"`
+		Prev     string    `json:"prev,omitempty"`
+		Degraded bool      `json:"degraded,omitempty"`
+	}
+
+	channelID, ok := a.channelID(w, r)
+	if !ok {
+		return
+	}
+	var v validator
+	opts := parseListOptions(&v, r.URL.Query())
+	if !v.valid() {
+		err := fmt.Errorf("invalid query: %d field errors", len(v.errs))
+		a.respondFieldErrors(w, http.StatusBadRequest, err, "Invalid query parameters", v.errs...)
+		return
+	}
+	opts.AppID, opts.ChannelID = appID(r), channelID
+	if r.PathValue("messageID") != "" {
+		// The replies route lists the thread of a message instead.
+		parent, ok := a.threadParent(w, r)
+		if !ok {
+			return
+		}
+		opts.ChannelID, opts.ParentID = parent.ChannelID, parent.ID
+	}
+	if opts.IncludeDeleted {
+		userID, ok := a.actingUser(w, r, "user_id", r.URL.Query().Get("user_id"))
+		if !ok {
+			return
+		}
+		if !a.isModerator(userID) {
+			err := errors.New("include_deleted requested by a user who is not a moderator")
+			a.respondError(w, http.StatusForbidden, err, "Only moderators can list deleted messages")
+			return
+		}
 	}
 
-	// Get messages from cache
-	msgs, err := a.Cache.ListMessages(r.Context())
+	// Fetch one message more than requested to find out whether there is
+	// another page in the requested direction.
+	page := opts
+	page.Limit++
+	msgs, degraded, err := a.fetchMessages(r.Context(), page)
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not list messages")
+		a.respondDBError(w, err, "Could not list messages")
 		return
 	}
+	if len(msgs) == 0 && opts.ParentID == "" && channelID != DefaultChannelID {
+		// Tell an empty channel apart from one that does not exist.
+		_, err := a.DB.GetChannel(r.Context(), opts.AppID, channelID)
+		if errors.Is(err, ErrNotFound) {
+			a.respondError(w, http.StatusNotFound, err, "Channel not found")
+			return
+		}
+		if err != nil {
+			a.respondDBError(w, err, "Could not list messages")
+			return
+		}
+	}
+
+	res := response{Degraded: degraded}
+	more := len(msgs) > opts.Limit
+	if more && opts.After != nil {
+		// Paging backwards, the extra message is the newest one.
+		msgs = msgs[1:]
+	} else if more {
+		msgs = msgs[:opts.Limit]
+	}
+	if len(msgs) > 0 {
+		first, last := msgs[0].Cursor(), msgs[len(msgs)-1].Cursor()
+		if more || opts.After != nil {
+			res.Next = encodePageToken(last, false)
+		}
+		if opts.Before != nil || (opts.After != nil && more) {
+			res.Prev = encodePageToken(first, true)
+		}
+	}
+
+	// Get the reactions of the whole page at once.
+	var summaries map[string]ReactionSummary
+	if len(msgs) > 0 {
+		msgIDs := make([]string, len(msgs))
+		for i, msg := range msgs {
+			msgIDs[i] = msg.ID
+		}
+		summaries, err = a.DB.ReactionSummaries(r.Context(), opts.AppID, msgIDs, latestReactions)
+		if errors.Is(err, ErrUnavailable) {
+			// The messages are still worth serving without their reactions.
+			a.Logger.Warn("Could not get reactions, DB is unavailable", "error", err.Error())
+			res.Degraded = true
+		} else if err != nil {
+			a.respondError(w, http.StatusInternalServerError, err, "Could not list messages")
+			return
+		}
+	}
+
+	res.Messages = make([]message, len(msgs))
+	for i, msg := range msgs {
+		sum := summaries[msg.ID]
+		out := message{
+			ID:              msg.ID,
+			Text:            msg.Text,
+			UserID:          msg.UserID,
+			CreatedAt:       msg.CreatedAt.Format(time.RFC1123),
+			ReactionCount:   sum.Count,
+			TotalScore:      sum.TotalScore,
+			ReactionCounts:  sum.Counts,
+			ReactionScores:  sum.Scores,
+			Version:         msg.Version,
+			ParentID:        msg.ParentID,
+			ShowInChannel:   msg.ShowInChannel,
+			LatestReactions: make([]reaction, len(sum.Latest)),
+		}
+		if !msg.UpdatedAt.IsZero() {
+			out.UpdatedAt = msg.UpdatedAt.Format(time.RFC1123)
+		}
+		if msg.ReplyCount > 0 {
+			out.ReplyCount = msg.ReplyCount
+			out.LatestReplyAt = msg.LatestReplyAt.Format(time.RFC1123)
+		}
+		if msg.Deleted() {
+			out.Text = deletedPlaceholder
+			out.DeletedAt = msg.DeletedAt.Format(time.RFC1123)
+		}
+		if out.ReactionCounts == nil {
+			out.ReactionCounts = map[string]int{}
+		}
+		if out.ReactionScores == nil {
+			out.ReactionScores = map[string]int{}
+		}
+		for j, r := range sum.Latest {
+			out.LatestReactions[j] = reaction{
+				ID:        r.ID,
+				Type:      r.Type,
+				Score:     r.Score,
+				UserID:    r.UserID,
+				CreatedAt: r.CreatedAt.Format(time.RFC1123),
+			}
+		}
+		res.Messages[i] = out
+	}
+	if res.Degraded {
+		markDegraded(w)
+	}
+	a.respond(w, http.StatusOK, res)
+}
+
+// fetchMessages returns the messages selected by opts, newest first. The
+// cache holds the latest messages, so pages close to the top of the list are
+// served from the cache and continue into the DB where the cache runs out.
+//
+// If the DB is unavailable but the cache could serve part of the page, the
+// partial page is returned and degraded is true.
+//
+// Deleted messages are only listed from the DB, since the cache may still
+// hold tombstones of messages that were purged. So are replies, since the
+// cache holds the messages of channels.
+func (a *API) fetchMessages(ctx context.Context, opts ListOptions) (msgs []Message, degraded bool, err error) {
+	if opts.IncludeDeleted || opts.ParentID != "" {
+		msgs, err := a.DB.ListMessages(ctx, opts)
+		if err != nil {
+			return nil, false, fmt.Errorf("list messages: %w", err)
+		}
+		return msgs, false, nil
+	}
+
+	cached, err := a.Cache.ListMessages(ctx, opts.AppID, opts.ChannelID)
+	if err != nil {
+		// The DB holds all messages, so the cache is not needed to serve
+		// the request.
+		a.Logger.Warn("Could not get messages from cache", "error", err.Error())
+		a.Metrics.cacheLookup(cacheError)
+		msgs, err := a.DB.ListMessages(ctx, opts)
+		if err != nil {
+			return nil, false, fmt.Errorf("list messages: %w", err)
+		}
+		return msgs, false, nil
+	}
+	a.Logger.Info("Got messages from cache", "count", len(cached))
+	cached = slices.DeleteFunc(cached, Message.Deleted)
 
-	a.Logger.Info("Got messages from cache", "count", len(msgs))
+	if opts.After != nil {
+		// Paging backwards only hits the cache if the cursor lies within
+		// it, in which case all newer messages are cached.
+		if len(cached) == 0 || opts.After.Before(cached[len(cached)-1].Cursor()) {
+			a.Metrics.cacheLookup(cacheMiss)
+			msgs, err := a.DB.ListMessages(ctx, opts)
+			if err != nil {
+				return nil, false, fmt.Errorf("list messages: %w", err)
+			}
+			return msgs, false, nil
+		}
+		for _, msg := range cached {
+			if opts.After.Before(msg.Cursor()) {
+				msgs = append(msgs, msg)
+			}
+		}
+		a.Metrics.cacheLookup(cacheHit)
+		return msgs[max(0, len(msgs)-opts.Limit):], false, nil
+	}
 
-	// Get any remaining messages from DB
+	for _, msg := range cached {
+		if opts.Before == nil || msg.Cursor().Before(*opts.Before) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) >= opts.Limit {
+		a.Metrics.cacheLookup(cacheHit)
+		return msgs[:opts.Limit], false, nil
+	}
+	a.Metrics.cacheLookup(cacheMiss)
+
+	// Get the remaining messages from the DB, continuing where the cache
+	// ends. The cached messages are excluded in case the cache and the DB
+	// disagree on their position, for example while queued messages have
+	// not been written to the DB yet.
+	rest := opts
+	rest.Limit -= len(msgs)
 	msgIDs := make([]string, len(msgs))
 	for i, msg := range msgs {
 		msgIDs[i] = msg.ID
 	}
-	dbMsgs, err := a.DB.ListMessages(r.Context(), msgIDs...)
+	if len(msgs) > 0 {
+		c := msgs[len(msgs)-1].Cursor()
+		rest.Before = &c
+	}
+	dbMsgs, err := a.DB.ListMessages(ctx, rest, msgIDs...)
+	if errors.Is(err, ErrUnavailable) && len(msgs) > 0 {
+		a.Logger.Warn("Serving cached messages only, DB is unavailable", "error", err.Error())
+		return msgs, true, nil
+	}
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not list messages")
-		return
+		return nil, false, fmt.Errorf("list messages: %w", err)
 	}
 	a.Logger.Info("Got remaining messages from DB", "count", len(dbMsgs))
-	msgs = append(msgs, dbMsgs...)
+	return append(msgs, dbMsgs...), false, nil
+}
 
-	out := make([]message, len(msgs))
-	for i, msg := range msgs {
-		out[i] = message{
-			ID:        msg.ID,
-			Text:      msg.Text,
-			UserID:    msg.UserID,
-			CreatedAt: msg.CreatedAt.Format(time.RFC1123),
+func (a *API) createMessage(w http.ResponseWriter, r *http.Request) {
+	type (
+		request struct {
+			Text   string `json:"text"`
+			UserID string `json:"user_id"`
+		}
+		response struct {
+			ID        string `json:"id"`
+			Text      string `json:"text"`
+			UserID    string `json:"user_id"`
+			CreatedAt string `json:"created_at"`
+			Version   int    `json:"version"`
 		}
+	)
+
+	channelID, ok := a.channelID(w, r)
+	if !ok {
+		return
+	}
+	var body request
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	userID, ok := a.actingUser(w, r, "user_id", body.UserID)
+	if !ok {
+		return
+	}
+	body.UserID = userID
+	var v validator
+	v.text("text", body.Text)
+	v.userID("user_id", body.UserID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+
+	// The id and timestamp are assigned here rather than by the DB, so that
+	// they are preserved if the message has to be queued.
+	msg := Message{
+		ID:        newID(),
+		AppID:     appID(r),
+		ChannelID: channelID,
+		Text:      body.Text,
+		UserID:    body.UserID,
+		CreatedAt: now(),
+		Version:   1,
+	}
+	msg, queued, err := a.postMessage(r.Context(), msg)
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Channel not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not insert message")
+		return
 	}
+	status := http.StatusCreated
+	if queued {
+		status = http.StatusAccepted
+		markDegraded(w)
+	}
+
 	res := response{
-		Messages: out,
+		ID:        msg.ID,
+		Text:      msg.Text,
+		UserID:    msg.UserID,
+		CreatedAt: msg.CreatedAt.Format(time.RFC1123),
+		Version:   msg.Version,
 	}
-	a.respond(w, http.StatusOK, res)
+	w.Header().Set("ETag", etag(msg.Version))
+	a.respond(w, status, res)
 }
 
-func (a *API) createMessage(w http.ResponseWriter, r *http.Request) {
+// postMessage stores a new message, caches it and publishes it. If the DB is
+// unavailable, the message is queued instead and queued is true.
+func (a *API) postMessage(ctx context.Context, msg Message) (_ Message, queued bool, err error) {
+	stored, err := a.DB.InsertMessage(ctx, msg)
+	if err == nil {
+		msg = stored
+	} else if errors.Is(err, ErrUnavailable) && a.Queue != nil {
+		a.Logger.Warn("Queueing message, DB is unavailable", "error", err.Error())
+		if qerr := a.Queue.Enqueue(ctx, msg); qerr != nil {
+			return Message{}, false, errors.Join(err, qerr)
+		}
+		queued = true
+	} else {
+		return Message{}, false, err
+	}
+
+	if err := a.Cache.InsertMessage(ctx, msg); err != nil {
+		a.Logger.Error("Could not cache message", "error", err.Error())
+	}
+	a.publishMessage(ctx, EventMessageCreated, msg)
+	return msg, queued, nil
+}
+
+func (a *API) updateMessage(w http.ResponseWriter, r *http.Request) {
 	type (
 		request struct {
 			Text   string `json:"text"`
@@ -123,45 +629,147 @@ func (a *API) createMessage(w http.ResponseWriter, r *http.Request) {
 			Text      string `json:"text"`
 			UserID    string `json:"user_id"`
 			CreatedAt string `json:"created_at"`
+			UpdatedAt string `json:"updated_at"`
+			Version   int    `json:"version"`
 		}
 	)
 
+	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", messageID), "Message not found")
+		return
+	}
 	var body request
-	err := json.NewDecoder(r.Body).Decode(&body)
-	if err != nil {
-		a.respondError(w, http.StatusBadRequest, err, "Could not decode request body")
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	userID, ok := a.actingUser(w, r, "user_id", body.UserID)
+	if !ok {
+		return
+	}
+	body.UserID = userID
+	var v validator
+	v.text("text", body.Text)
+	v.userID("user_id", body.UserID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+	ifVersion, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		err := fmt.Errorf("unsupported If-Match header %q", r.Header.Get("If-Match"))
+		a.respondError(w, http.StatusPreconditionFailed, err, "Message was modified")
 		return
 	}
-	r.Body.Close()
 
-	msg, err := a.DB.InsertMessage(r.Context(), Message{
+	msg, err := a.DB.UpdateMessage(r.Context(), MessageUpdate{
+		ID:        messageID,
+		AppID:     appID(r),
 		Text:      body.Text,
 		UserID:    body.UserID,
-		CreatedAt: time.Now(),
+		UpdatedAt: now(),
+		IfVersion: ifVersion,
 	})
-	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, err, "Could not insert message")
+	switch {
+	case errors.Is(err, ErrNotFound):
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	case errors.Is(err, ErrForbidden):
+		a.respondError(w, http.StatusForbidden, err, "Only the author can edit a message")
+		return
+	case errors.Is(err, ErrVersionMismatch):
+		a.respondError(w, http.StatusPreconditionFailed, err, "Message was modified")
+		return
+	case err != nil:
+		a.respondDBError(w, err, "Could not update message")
 		return
 	}
 
-	if err := a.Cache.InsertMessage(r.Context(), msg); err != nil {
-		a.Logger.Error("Could not cache message", "error", err.Error())
+	if err := a.Cache.UpdateMessage(r.Context(), msg); err != nil {
+		a.Logger.Error("Could not update cached message", "error", err.Error())
 	}
+	a.publishMessage(r.Context(), EventMessageUpdated, msg)
 
 	res := response{
 		ID:        msg.ID,
 		Text:      msg.Text,
 		UserID:    msg.UserID,
 		CreatedAt: msg.CreatedAt.Format(time.RFC1123),
+		UpdatedAt: msg.UpdatedAt.Format(time.RFC1123),
+		Version:   msg.Version,
+	}
+	w.Header().Set("ETag", etag(msg.Version))
+	a.respond(w, http.StatusOK, res)
+}
+
+func (a *API) messageHistory(w http.ResponseWriter, r *http.Request) {
+	type (
+		version struct {
+			Version    int    `json:"version"`
+			Text       string `json:"text"`
+			CreatedAt  string `json:"created_at"`
+			ReplacedAt string `json:"replaced_at"`
+		}
+		response struct {
+			History []version `json:"history"`
+		}
+	)
+
+	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", messageID), "Message not found")
+		return
+	}
+	versions, err := a.DB.MessageHistory(r.Context(), appID(r), messageID)
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not get message history")
+		return
+	}
+
+	res := response{History: make([]version, len(versions))}
+	for i, v := range versions {
+		res.History[i] = version{
+			Version:    v.Version,
+			Text:       v.Text,
+			CreatedAt:  v.CreatedAt.Format(time.RFC1123),
+			ReplacedAt: v.ReplacedAt.Format(time.RFC1123),
+		}
+	}
+	a.respond(w, http.StatusOK, res)
+}
+
+// etag returns the entity tag of a message version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch returns the message version required by an If-Match header,
+// or 0 if any version matches. Only a single strong entity tag or "*" is
+// supported; ok is false for any other header.
+func parseIfMatch(h string) (version int, ok bool) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, true
+	}
+	if len(h) < 3 || h[0] != '"' || h[len(h)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(h[1 : len(h)-1])
+	if err != nil || version < 1 {
+		return 0, false
 	}
-	a.respond(w, http.StatusCreated, res)
+	return version, true
 }
 
 func (a *API) createReaction(w http.ResponseWriter, r *http.Request) {
 	type (
 		request struct {
 			Type   string `json:"type"`
-			Score  int    `json:"score"`
+			Score  *int   `json:"score"`
 			UserID string `json:"user_id"`
 		}
 		response struct {
@@ -175,14 +783,196 @@ func (a *API) createReaction(w http.ResponseWriter, r *http.Request) {
 	)
 
 	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		err := fmt.Errorf("invalid message id %q", messageID)
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+
 	var body request
-	err := json.NewDecoder(r.Body).Decode(&body)
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	score := 1
+	if body.Score != nil {
+		score = *body.Score
+	}
+	userID, ok := a.actingUser(w, r, "user_id", body.UserID)
+	if !ok {
+		return
+	}
+	body.UserID = userID
+	var v validator
+	v.reactionType("type", body.Type)
+	v.score("score", score)
+	v.userID("user_id", body.UserID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+
+	reaction, created, err := a.DB.InsertReaction(r.Context(), Reaction{
+		AppID:     appID(r),
+		MessageID: messageID,
+		Type:      body.Type,
+		Score:     score,
+		UserID:    body.UserID,
+		CreatedAt: now(),
+	}, a.reactionOptions())
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
 	if err != nil {
-		a.respondError(w, http.StatusBadRequest, err, "Could not decode request body")
+		a.respondDBError(w, err, "Could not insert reaction")
 		return
 	}
-	r.Body.Close()
+	a.publishReaction(r.Context(), EventReactionNew, reaction)
 
-	err = fmt.Errorf("could not create reaction for message with id %s", messageID)
-	a.respondError(w, http.StatusNotImplemented, err, err.Error())
+	res := response{
+		ID:        reaction.ID,
+		MessageID: reaction.MessageID,
+		Type:      reaction.Type,
+		Score:     reaction.Score,
+		UserID:    reaction.UserID,
+		CreatedAt: reaction.CreatedAt.Format(time.RFC1123),
+	}
+	// Adding to the score of an existing reaction creates nothing.
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	a.respond(w, status, res)
+}
+
+// reactionOptions returns the rules for adding reactions.
+func (a *API) reactionOptions() ReactionOptions {
+	opts := ReactionOptions{
+		MaxScore:  a.MaxReactionScore,
+		Exclusive: a.ExclusiveReactions,
+	}
+	if opts.MaxScore <= 0 {
+		opts.MaxScore = defaultMaxReactionScore
+	}
+	return opts
+}
+
+// setReaction sets the score of the calling user's reaction of the given type,
+// adding the reaction if needed. Repeating the request has no further effect.
+func (a *API) setReaction(w http.ResponseWriter, r *http.Request) {
+	type (
+		request struct {
+			Score  *int   `json:"score"`
+			UserID string `json:"user_id"`
+		}
+		response struct {
+			ID        string `json:"id"`
+			MessageID string `json:"message_id"`
+			Type      string `json:"type"`
+			Score     int    `json:"score"`
+			UserID    string `json:"user_id"`
+			CreatedAt string `json:"created_at"`
+		}
+	)
+
+	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", messageID), "Message not found")
+		return
+	}
+	var body request
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	score := 1
+	if body.Score != nil {
+		score = *body.Score
+	}
+	userID, ok := a.actingUser(w, r, "user_id", body.UserID)
+	if !ok {
+		return
+	}
+	body.UserID = userID
+	var v validator
+	v.reactionType("type", r.PathValue("type"))
+	v.score("score", score)
+	v.userID("user_id", body.UserID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+
+	reaction, created, err := a.DB.SetReaction(r.Context(), Reaction{
+		AppID:     appID(r),
+		MessageID: messageID,
+		Type:      r.PathValue("type"),
+		Score:     score,
+		UserID:    body.UserID,
+		CreatedAt: now(),
+	}, a.reactionOptions())
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not set reaction")
+		return
+	}
+	a.publishReaction(r.Context(), EventReactionNew, reaction)
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	a.respond(w, status, response{
+		ID:        reaction.ID,
+		MessageID: reaction.MessageID,
+		Type:      reaction.Type,
+		Score:     reaction.Score,
+		UserID:    reaction.UserID,
+		CreatedAt: reaction.CreatedAt.Format(time.RFC1123),
+	})
+}
+
+// deleteReaction removes the reactions of the given type that the user in
+// the user_id query parameter added to a message. It succeeds whether or not
+// the user had reacted, so that it can be retried safely.
+func (a *API) deleteReaction(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", messageID), "Message not found")
+		return
+	}
+	userID, ok := a.actingUser(w, r, "user_id", r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	var v validator
+	v.reactionType("type", r.PathValue("type"))
+	v.userID("user_id", userID)
+	if !v.valid() {
+		err := fmt.Errorf("invalid parameters: %d field errors", len(v.errs))
+		a.respondFieldErrors(w, http.StatusBadRequest, err, "Invalid request parameters", v.errs...)
+		return
+	}
+
+	n, err := a.DB.DeleteReaction(r.Context(), appID(r), messageID, userID, r.PathValue("type"))
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not delete reaction")
+		return
+	}
+	a.Logger.Info("Deleted reactions", "message_id", messageID, "count", n)
+	if n > 0 {
+		a.publishReaction(r.Context(), EventReactionDeleted, Reaction{
+			AppID:     appID(r),
+			MessageID: messageID,
+			Type:      r.PathValue("type"),
+			UserID:    userID,
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -5,14 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/neilotoole/slogt"
 )
 
@@ -27,12 +31,12 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "DBError",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
 					return nil, errors.New("something went wrong")
 				},
 			},
@@ -44,29 +48,52 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "CacheError",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					return nil, errors.New("something went wrong")
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
-					return nil, nil
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					// The DB serves the request on its own.
+					if opts.Before != nil || len(excludeMsgIDs) > 0 {
+						t.Errorf("Got cursor %v and excluded %v, want the first page", opts.Before, excludeMsgIDs)
+					}
+					return []Message{
+						{
+							ID:        "1",
+							Text:      "Hello",
+							UserID:    "testuser",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						},
+					}, nil
 				},
 			},
-			wantStatus: 500,
+			wantStatus: 200,
 			wantBody: `{
-				"error": "Could not list messages"
+				"messages": [
+					{
+						"id": "1",
+						"text": "Hello",
+						"user_id": "testuser",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					}
+				]
 			}`,
 		},
 		{
 			name: "Empty",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
 					return nil, nil
 				},
 			},
@@ -78,7 +105,7 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "Cache",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "1",
@@ -90,7 +117,7 @@ func TestAPI_listMessages(t *testing.T) {
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
 					// Nothing in DB.
 					return nil, nil
 				},
@@ -102,7 +129,12 @@ func TestAPI_listMessages(t *testing.T) {
 						"id": "1",
 						"text": "Hello",
 						"user_id": "testuser",
-						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
 					}
 				]
 			}`,
@@ -110,13 +142,13 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "DB",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					// Nothing in cache.
 					return nil, nil
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "1",
@@ -134,7 +166,12 @@ func TestAPI_listMessages(t *testing.T) {
 						"id": "1",
 						"text": "Hello",
 						"user_id": "testuser",
-						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
 					}
 				]
 			}`,
@@ -142,7 +179,7 @@ func TestAPI_listMessages(t *testing.T) {
 		{
 			name: "Mixed",
 			cache: &testcache{
-				listMessages: func(t *testing.T) ([]Message, error) {
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "1",
@@ -154,7 +191,7 @@ func TestAPI_listMessages(t *testing.T) {
 				},
 			},
 			db: &testdb{
-				listMessages: func(t *testing.T, excludeMsgIDs ...string) ([]Message, error) {
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
 					return []Message{
 						{
 							ID:        "2",
@@ -172,157 +209,197 @@ func TestAPI_listMessages(t *testing.T) {
 						"id": "1",
 						"text": "Hello",
 						"user_id": "testuser",
-						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
 					},
 					{
 						"id": "2",
 						"text": "World",
 						"user_id": "testuser",
-						"created_at": "Tue, 02 Jan 2024 00:00:00 UTC"
+						"created_at": "Tue, 02 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
 					}
 				]
 			}`,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.db != nil {
-				tt.db.T = t
-			}
-			if tt.cache != nil {
-				tt.cache.T = t
-			}
-			api := &API{
-				DB:     tt.db,
-				Cache:  tt.cache,
-				Logger: slogt.New(t),
-			}
-
-			srv := httptest.NewServer(api)
-			defer srv.Close()
-
-			req, _ := http.NewRequest("GET", srv.URL+"/messages", nil)
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
-			}
-			checkStatus(t, resp.StatusCode, tt.wantStatus)
-			checkBody(t, resp, tt.wantBody)
-		})
-	}
-}
-
-func TestAPI_createMessage(t *testing.T) {
-	tests := []struct {
-		name        string
-		cache       *testcache
-		db          *testdb
-		req         string
-		wantStatus  int
-		wantBody    string
-		containsLog string
-	}{
 		{
-			name:       "InvalidJSON",
-			req:        `not json`,
-			wantStatus: 400,
+			name: "Reactions",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return []Message{
+						{
+							ID:        "1",
+							Text:      "Hello",
+							UserID:    "testuser",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						},
+					}, nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, nil
+				},
+				reactionSummaries: func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+					if diff := cmp.Diff(msgIDs, []string{"1"}); diff != "" {
+						t.Errorf("Message IDs differ (-got +want)\n%s", diff)
+					}
+					return map[string]ReactionSummary{
+						"1": {
+							Count:      2,
+							TotalScore: 4,
+							Counts:     map[string]int{"like": 1, "clap": 1},
+							Scores:     map[string]int{"like": 1, "clap": 3},
+							Latest: []Reaction{
+								{
+									ID:        "r2",
+									MessageID: "1",
+									Type:      "clap",
+									Score:     3,
+									UserID:    "other",
+									CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+								},
+								{
+									ID:        "r1",
+									MessageID: "1",
+									Type:      "like",
+									Score:     1,
+									UserID:    "testuser",
+									CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+								},
+							},
+						},
+					}, nil
+				},
+			},
+			wantStatus: 200,
 			wantBody: `{
-				"error": "Could not decode request body"
+				"messages": [
+					{
+						"id": "1",
+						"text": "Hello",
+						"user_id": "testuser",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 2,
+						"total_score": 4,
+						"reaction_counts": {"clap": 1, "like": 1},
+						"reaction_scores": {"clap": 3, "like": 1},
+						"latest_reactions": [
+							{
+								"id": "r2",
+								"type": "clap",
+								"score": 3,
+								"user_id": "other",
+								"created_at": "Wed, 03 Jan 2024 00:00:00 UTC"
+							},
+							{
+								"id": "r1",
+								"type": "like",
+								"score": 1,
+								"user_id": "testuser",
+								"created_at": "Tue, 02 Jan 2024 00:00:00 UTC"
+							}
+						]
+					}
+				]
 			}`,
 		},
 		{
-			name: "DBError",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
+			name: "ReactionsError",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return []Message{
+						{
+							ID:        "1",
+							Text:      "Hello",
+							UserID:    "testuser",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						},
+					}, nil
+				},
+			},
 			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					return Message{}, errors.New("something went wrong")
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, nil
+				},
+				reactionSummaries: func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+					return nil, errors.New("something went wrong")
 				},
 			},
 			wantStatus: 500,
 			wantBody: `{
-				"error": "Could not insert message"
+				"error": "Could not list messages"
 			}`,
 		},
 		{
-			name: "CacheError",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
+			name: "DBUnavailable",
 			cache: &testcache{
-				insertMessage: func(t *testing.T, msg Message) error {
-					return errors.New("something went wrong")
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return []Message{
+						{
+							ID:        "1",
+							Text:      "Hello",
+							UserID:    "testuser",
+							CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						},
+					}, nil
 				},
 			},
 			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					return Message{
-						ID:        "1",
-						Text:      msg.Text,
-						UserID:    msg.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, fmt.Errorf("scan: %w", ErrUnavailable)
+				},
+				reactionSummaries: func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+					return nil, fmt.Errorf("scan: %w", ErrUnavailable)
 				},
 			},
-			wantStatus: 201,
+			wantStatus: 200,
 			wantBody: `{
-				"id": "1",
-				"text": "hello",
-				"user_id": "test",
-				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+				"messages": [
+					{
+						"id": "1",
+						"text": "Hello",
+						"user_id": "testuser",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					}
+				],
+				"degraded": true
 			}`,
-			containsLog: "Could not cache message",
 		},
 		{
-			name: "OK",
-			req: `{
-				"text": "hello",
-				"user_id": "test"
-			}`,
-			db: &testdb{
-				insertMessage: func(t *testing.T, msg Message) (Message, error) {
-					if msg.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", msg.UserID)
-					}
-					if msg.Text != "hello" {
-						t.Errorf("Got Text %q, want test", msg.Text)
-					}
-					return Message{
-						ID:        "1",
-						Text:      msg.Text,
-						UserID:    msg.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
+			name: "DBUnavailableEmptyCache",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return nil, nil
 				},
 			},
-			cache: &testcache{
-				insertMessage: func(t *testing.T, msg Message) error {
-					if msg.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", msg.UserID)
-					}
-					if msg.Text != "hello" {
-						t.Errorf("Got Text %q, want test", msg.Text)
-					}
-					return nil
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, fmt.Errorf("scan: %w", ErrUnavailable)
 				},
 			},
-			wantStatus: 201,
+			wantStatus: 503,
 			wantBody: `{
-				"id": "1",
-				"text": "hello",
-				"user_id": "test",
-				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+				"error": "Could not list messages"
 			}`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf := &bytes.Buffer{}
 			if tt.db != nil {
 				tt.db.T = t
 			}
@@ -332,127 +409,1893 @@ func TestAPI_createMessage(t *testing.T) {
 			api := &API{
 				DB:     tt.db,
 				Cache:  tt.cache,
-				Logger: slog.New(slog.NewTextHandler(buf, nil)),
+				Logger: slogt.New(t),
 			}
 
 			srv := httptest.NewServer(api)
 			defer srv.Close()
 
-			req, _ := http.NewRequest("POST", srv.URL+"/messages", strings.NewReader(tt.req))
+			req, _ := http.NewRequest("GET", srv.URL+"/messages", nil)
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				t.Fatal(err)
 			}
 			checkStatus(t, resp.StatusCode, tt.wantStatus)
 			checkBody(t, resp, tt.wantBody)
-			checkLog(t, buf, tt.containsLog)
 		})
 	}
 }
 
-func TestAPI_createReaction(t *testing.T) {
+func TestAPI_listMessages_pagination(t *testing.T) {
+	// testMessages returns n messages, newest first, one minute apart.
+	testMessages := func(n int) []Message {
+		msgs := make([]Message, n)
+		for i := range msgs {
+			msgs[i] = Message{
+				ID:        fmt.Sprintf("%d", n-i),
+				Text:      fmt.Sprintf("Message %d", n-i),
+				UserID:    "testuser",
+				CreatedAt: time.Date(2024, 1, 1, 0, n-i, 0, 0, time.UTC),
+			}
+		}
+		return msgs
+	}
+	all := testMessages(25)
+
 	tests := []struct {
 		name       string
+		query      string
+		cache      *testcache
 		db         *testdb
-		messageID  string
-		req        string
 		wantStatus int
-		wantBody   string
+		wantIDs    []string
+		wantNext   bool
+		wantPrev   bool
 	}{
 		{
-			name: "OK",
-			req: `{
-				"type": "thumbs_up",
-				"user_id": "test"
-			}`,
-			messageID: "12345",
+			name:       "InvalidLimit",
+			query:      "?limit=zero",
+			wantStatus: 400,
+		},
+		{
+			name:       "NegativeLimit",
+			query:      "?limit=-1",
+			wantStatus: 400,
+		},
+		{
+			name:       "InvalidCursor",
+			query:      "?cursor=garbage",
+			wantStatus: 400,
+		},
+		{
+			name: "FirstPageFromCache",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
 			db: &testdb{
-				insertReaction: func(t *testing.T, reaction Reaction) (Reaction, error) {
-					if reaction.UserID != "test" {
-						t.Errorf("Got UserID %q, want test", reaction.UserID)
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.Limit != 1 {
+						t.Errorf("Got limit %d, want 1", opts.Limit)
 					}
-					if reaction.Type != "thumbsup" {
-						t.Errorf("Got Text %q, want test", reaction.Type)
+					if opts.Before == nil || opts.Before.ID != "16" {
+						t.Errorf("Got cursor %v, want to continue after message 16", opts.Before)
 					}
-					return Reaction{
-						ID:        "1",
-						MessageID: "12345",
-						Score:     1,
-						Type:      reaction.Type,
-						UserID:    reaction.UserID,
-						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
-					}, nil
+					return all[10:11], nil
 				},
 			},
-			wantStatus: 201,
-			wantBody: `{
-				"id": "1",
-				"message_id": "12345",
-				"type": "thumbs_up",
-				"user_id": "test",
-				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
-			}`,
+			wantStatus: 200,
+			wantIDs:    []string{"25", "24", "23", "22", "21", "20", "19", "18", "17", "16"},
+			wantNext:   true,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if tt.db == nil {
-				tt.db = &testdb{}
-			}
-			tt.db.T = t
-			api := &API{
-				DB:     tt.db,
-				Logger: slogt.New(t),
-			}
-
-			srv := httptest.NewServer(api)
-			defer srv.Close()
-
-			req, _ := http.NewRequest("POST", srv.URL+"/messages/"+tt.messageID+"/reactions", strings.NewReader(tt.req))
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				t.Fatal(err)
-			}
-			checkStatus(t, resp.StatusCode, tt.wantStatus)
+		{
+			name:  "Limit",
+			query: "?limit=3",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					t.Error("DB should not be queried when the page is cached")
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"25", "24", "23"},
+			wantNext:   true,
+		},
+		{
+			name:  "LastPage",
+			query: "?limit=30",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if len(excludeMsgIDs) != 10 {
+						t.Errorf("Got %d excluded messages, want the 10 cached ones", len(excludeMsgIDs))
+					}
+					return all[10:], nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs: []string{
+				"25", "24", "23", "22", "21", "20", "19", "18", "17", "16",
+				"15", "14", "13", "12", "11", "10", "9", "8", "7", "6",
+				"5", "4", "3", "2", "1",
+			},
+		},
+		{
+			name:  "NextFromCacheIntoDB",
+			query: "?limit=5&cursor=" + encodePageToken(all[7].Cursor(), false),
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.Before == nil || opts.Before.ID != "16" {
+						t.Errorf("Got cursor %v, want to continue after message 16", opts.Before)
+					}
+					if opts.Limit != 4 {
+						t.Errorf("Got limit %d, want 4", opts.Limit)
+					}
+					return all[10:14], nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"17", "16", "15", "14", "13"},
+			wantNext:   true,
+			wantPrev:   true,
+		},
+		{
+			name:  "NextFromDB",
+			query: "?limit=5&cursor=" + encodePageToken(all[19].Cursor(), false),
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.Before == nil || opts.Before.ID != "6" {
+						t.Errorf("Got cursor %v, want to continue after message 6", opts.Before)
+					}
+					return all[20:], nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"5", "4", "3", "2", "1"},
+			wantPrev:   true,
+		},
+		{
+			name:  "PrevFromCache",
+			query: "?limit=3&cursor=" + encodePageToken(all[5].Cursor(), true),
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					t.Error("DB should not be queried when the page is cached")
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"23", "22", "21"},
+			wantNext:   true,
+			wantPrev:   true,
+		},
+		{
+			name:  "PrevFirstPage",
+			query: "?limit=3&cursor=" + encodePageToken(all[3].Cursor(), true),
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					t.Error("DB should not be queried when the page is cached")
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"25", "24", "23"},
+			wantNext:   true,
+		},
+		{
+			name:  "PrevFromDB",
+			query: "?limit=3&cursor=" + encodePageToken(all[20].Cursor(), true),
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return all[:10], nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.After == nil || opts.After.ID != "5" {
+						t.Errorf("Got cursor %v, want to continue before message 5", opts.After)
+					}
+					if opts.Limit != 4 {
+						t.Errorf("Got limit %d, want 4", opts.Limit)
+					}
+					return all[16:20], nil
+				},
+			},
+			wantStatus: 200,
+			wantIDs:    []string{"8", "7", "6"},
+			wantNext:   true,
+			wantPrev:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db != nil {
+				tt.db.T = t
+			}
+			if tt.cache != nil {
+				tt.cache.T = t
+			}
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/messages"+tt.query, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Messages []struct {
+					ID string `json:"id"`
+				} `json:"messages"`
+				Next string `json:"next"`
+				Prev string `json:"prev"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			gotIDs := make([]string, len(body.Messages))
+			for i, msg := range body.Messages {
+				gotIDs[i] = msg.ID
+			}
+			if diff := cmp.Diff(gotIDs, tt.wantIDs); diff != "" {
+				t.Errorf("Message IDs differ (-got +want)\n%s", diff)
+			}
+			if got := body.Next != ""; got != tt.wantNext {
+				t.Errorf("Got next cursor %t, want %t", got, tt.wantNext)
+			}
+			if got := body.Prev != ""; got != tt.wantPrev {
+				t.Errorf("Got prev cursor %t, want %t", got, tt.wantPrev)
+			}
+		})
+	}
+}
+
+func TestAPI_createMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		cache       *testcache
+		db          *testdb
+		req         string
+		wantStatus  int
+		wantBody    string
+		containsLog string
+	}{
+		{
+			name:       "InvalidJSON",
+			req:        `not json`,
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body"
+			}`,
+		},
+		{
+			name:       "MissingFields",
+			req:        `{}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "text", "code": "required", "message": "Text must not be empty"},
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name: "BlankText",
+			req: `{
+				"text": " \n\t",
+				"user_id": "test"
+			}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "text", "code": "required", "message": "Text must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:       "TextTooLong",
+			req:        `{"text": "` + strings.Repeat("a", maxTextLength+1) + `", "user_id": "test"}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "text", "code": "too_long", "message": "Text must be at most 5000 characters"}
+				]
+			}`,
+		},
+		{
+			name: "InvalidUserID",
+			req: `{
+				"text": "hello",
+				"user_id": "not a user"
+			}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "user_id", "code": "invalid_format", "message": "User ID may only contain letters, digits and @ . _ -"}
+				]
+			}`,
+		},
+		{
+			name: "UnknownField",
+			req: `{
+				"text": "hello",
+				"user_id": "test",
+				"admin": true
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body",
+				"fields": [
+					{"field": "admin", "code": "unknown_field", "message": "Unknown field"}
+				]
+			}`,
+		},
+		{
+			name: "InvalidType",
+			req: `{
+				"text": 42,
+				"user_id": "test"
+			}`,
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body",
+				"fields": [
+					{"field": "text", "code": "invalid_type", "message": "Expected a value of type string"}
+				]
+			}`,
+		},
+		{
+			name:       "MultipleValues",
+			req:        `{"text": "hello", "user_id": "test"} {"text": "world", "user_id": "test"}`,
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body"
+			}`,
+		},
+		{
+			name:       "TooLarge",
+			req:        `{"text": "` + strings.Repeat("a", maxBodySize) + `", "user_id": "test"}`,
+			wantStatus: 413,
+			wantBody: `{
+				"error": "Request body too large"
+			}`,
+		},
+		{
+			name: "DBError",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not insert message"
+			}`,
+		},
+		{
+			name: "DBUnavailable",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, fmt.Errorf("insert: %w", ErrUnavailable)
+				},
+			},
+			wantStatus: 503,
+			wantBody: `{
+				"error": "Could not insert message"
+			}`,
+		},
+		{
+			name: "CacheError",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					return errors.New("something went wrong")
+				},
+			},
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{
+						ID:        "1",
+						Text:      msg.Text,
+						UserID:    msg.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Version:   msg.Version,
+					}, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"text": "hello",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+				"version": 1
+			}`,
+			containsLog: "Could not cache message",
+		},
+		{
+			name: "OK",
+			req: `{
+				"text": "hello",
+				"user_id": "test"
+			}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", msg.UserID)
+					}
+					if msg.Text != "hello" {
+						t.Errorf("Got Text %q, want test", msg.Text)
+					}
+					return Message{
+						ID:        "1",
+						Text:      msg.Text,
+						UserID:    msg.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						Version:   msg.Version,
+					}, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					if msg.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", msg.UserID)
+					}
+					if msg.Text != "hello" {
+						t.Errorf("Got Text %q, want test", msg.Text)
+					}
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"text": "hello",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+				"version": 1
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if tt.db != nil {
+				tt.db.T = t
+			}
+			if tt.cache != nil {
+				tt.cache.T = t
+			}
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slog.New(slog.NewTextHandler(buf, nil)),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+"/messages", strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+			checkLog(t, buf, tt.containsLog)
+		})
+	}
+}
+
+func TestAPI_createMessage_queue(t *testing.T) {
+	var queued []Message
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return Message{}, fmt.Errorf("insert: %w", ErrUnavailable)
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Queue: &testqueue{
+			T: t,
+			enqueue: func(t *testing.T, msg Message) error {
+				queued = append(queued, msg)
+				return nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{"text": "hello", "user_id": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, http.StatusAccepted)
+	if got := resp.Header.Get("X-Degraded"); got != "true" {
+		t.Errorf("Got X-Degraded header %q, want true", got)
+	}
+
+	if len(queued) != 1 {
+		t.Fatalf("Got %d queued messages, want 1", len(queued))
+	}
+	msg := queued[0]
+	if msg.ID == "" || msg.CreatedAt.IsZero() {
+		t.Errorf("Queued message has no id or timestamp: %+v", msg)
+	}
+	var body struct {
+		ID        string `json:"id"`
+		CreatedAt string `json:"created_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ID != msg.ID || body.CreatedAt != msg.CreatedAt.Format(time.RFC1123) {
+		t.Errorf("Response does not match the queued message; got %+v, want %+v", body, msg)
+	}
+}
+
+func TestAPI_ReplayQueue(t *testing.T) {
+	queued := []Message{
+		{ID: "1", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", Text: "world", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: "3", ChannelID: "gone", Text: "!", UserID: "test", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	var inserted []Message
+	ctx, cancel := context.WithCancel(context.Background())
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				if msg.ID == "1" {
+					// Already written by another instance.
+					return Message{}, fmt.Errorf("message 1: %w", ErrConflict)
+				}
+				if msg.ChannelID == "gone" {
+					return Message{}, fmt.Errorf("channel gone: %w", ErrNotFound)
+				}
+				inserted = append(inserted, msg)
+				return msg, nil
+			},
+		},
+		Queue: &testqueue{
+			T: t,
+			replay: func(t *testing.T, fn func(Message) error) (int, error) {
+				defer cancel()
+				for i, msg := range queued {
+					if err := fn(msg); err != nil {
+						return i, err
+					}
+				}
+				return len(queued), nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	api.ReplayQueue(ctx, time.Hour)
+	// Messages queued before channels existed go to the default channel,
+	// and messages of channels that do not exist are dropped.
+	want := queued[1]
+	want.ChannelID = DefaultChannelID
+	if diff := cmp.Diff(inserted, []Message{want}); diff != "" {
+		t.Errorf("Inserted messages differ (-got +want)\n%s", diff)
+	}
+}
+
+func TestAPI_updateMessage(t *testing.T) {
+	const msgID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	tests := []struct {
+		name       string
+		messageID  string
+		ifMatch    string
+		req        string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantETag   string
+		wantBody   string
+	}{
+		{
+			name:       "InvalidMessageID",
+			messageID:  "12345",
+			req:        `{"text": "hello", "user_id": "test"}`,
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "MissingFields",
+			messageID:  msgID,
+			req:        `{}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "text", "code": "required", "message": "Text must not be empty"},
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:       "WeakETag",
+			messageID:  msgID,
+			ifMatch:    `W/"1"`,
+			req:        `{"text": "hello", "user_id": "test"}`,
+			wantStatus: 412,
+			wantBody: `{
+				"error": "Message was modified"
+			}`,
+		},
+		{
+			name:      "NotFound",
+			messageID: msgID,
+			req:       `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				updateMessage: func(t *testing.T, upd MessageUpdate) (Message, error) {
+					return Message{}, fmt.Errorf("message %s: %w", upd.ID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:      "NotAuthor",
+			messageID: msgID,
+			req:       `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				updateMessage: func(t *testing.T, upd MessageUpdate) (Message, error) {
+					return Message{}, fmt.Errorf("message %s: %w", upd.ID, ErrForbidden)
+				},
+			},
+			wantStatus: 403,
+			wantBody: `{
+				"error": "Only the author can edit a message"
+			}`,
+		},
+		{
+			name:      "VersionMismatch",
+			messageID: msgID,
+			ifMatch:   `"1"`,
+			req:       `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				updateMessage: func(t *testing.T, upd MessageUpdate) (Message, error) {
+					return Message{}, fmt.Errorf("message %s has version 2: %w", upd.ID, ErrVersionMismatch)
+				},
+			},
+			wantStatus: 412,
+			wantBody: `{
+				"error": "Message was modified"
+			}`,
+		},
+		{
+			name:      "DBError",
+			messageID: msgID,
+			req:       `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				updateMessage: func(t *testing.T, upd MessageUpdate) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not update message"
+			}`,
+		},
+		{
+			name:      "OK",
+			messageID: msgID,
+			ifMatch:   `"2"`,
+			req:       `{"text": "hello, world", "user_id": "test"}`,
+			db: &testdb{
+				updateMessage: func(t *testing.T, upd MessageUpdate) (Message, error) {
+					want := MessageUpdate{ID: msgID, AppID: DefaultAppID, Text: "hello, world", UserID: "test", IfVersion: 2}
+					if diff := cmp.Diff(upd, want, cmpopts.IgnoreFields(MessageUpdate{}, "UpdatedAt")); diff != "" {
+						t.Errorf("Update differs (-got +want)\n%s", diff)
+					}
+					return Message{
+						ID:        upd.ID,
+						Text:      upd.Text,
+						UserID:    upd.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						UpdatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+						Version:   3,
+					}, nil
+				},
+			},
+			cache: &testcache{
+				updateMessage: func(t *testing.T, msg Message) error {
+					if msg.Text != "hello, world" || msg.Version != 3 {
+						t.Errorf("Cached message was not updated: %+v", msg)
+					}
+					return nil
+				},
+			},
+			wantStatus: 200,
+			wantETag:   `"3"`,
+			wantBody: `{
+				"id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"text": "hello, world",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+				"updated_at": "Tue, 02 Jan 2024 00:00:00 UTC",
+				"version": 3
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("PATCH", srv.URL+"/messages/"+tt.messageID, strings.NewReader(tt.req))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if got := resp.Header.Get("ETag"); got != tt.wantETag {
+				t.Errorf("Got ETag %q, want %q", got, tt.wantETag)
+			}
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_messageHistory(t *testing.T) {
+	const msgID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	tests := []struct {
+		name       string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "NotFound",
+			db: &testdb{
+				messageHistory: func(t *testing.T, id string) ([]MessageVersion, error) {
+					return nil, fmt.Errorf("message %s: %w", id, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name: "NotEdited",
+			db: &testdb{
+				messageHistory: func(t *testing.T, id string) ([]MessageVersion, error) {
+					return []MessageVersion{}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"history": []
+			}`,
+		},
+		{
+			name: "OK",
+			db: &testdb{
+				messageHistory: func(t *testing.T, id string) ([]MessageVersion, error) {
+					if id != msgID {
+						t.Errorf("Got message id %q, want %q", id, msgID)
+					}
+					return []MessageVersion{
+						{
+							Version:    2,
+							Text:       "helo, world",
+							CreatedAt:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+							ReplacedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+						},
+						{
+							Version:    1,
+							Text:       "helo",
+							CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+							ReplacedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+						},
+					}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"history": [
+					{
+						"version": 2,
+						"text": "helo, world",
+						"created_at": "Tue, 02 Jan 2024 00:00:00 UTC",
+						"replaced_at": "Wed, 03 Jan 2024 00:00:00 UTC"
+					},
+					{
+						"version": 1,
+						"text": "helo",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"replaced_at": "Tue, 02 Jan 2024 00:00:00 UTC"
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/messages/" + msgID + "/history")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_deleteMessage(t *testing.T) {
+	const msgID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	deleted := Message{
+		ID:        msgID,
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:   1,
+		DeletedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name       string
+		messageID  string
+		userID     string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidMessageID",
+			messageID:  "12345",
+			userID:     "test",
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "MissingUserID",
+			messageID:  msgID,
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Invalid query parameters",
+				"fields": [
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:      "NotFound",
+			messageID: msgID,
+			userID:    "test",
+			db: &testdb{
+				deleteMessage: func(t *testing.T, del MessageDeletion) (Message, error) {
+					return Message{}, fmt.Errorf("message %s: %w", del.ID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:      "NotAuthor",
+			messageID: msgID,
+			userID:    "someone-else",
+			db: &testdb{
+				deleteMessage: func(t *testing.T, del MessageDeletion) (Message, error) {
+					if del.Moderator {
+						t.Error("User was treated as a moderator")
+					}
+					return Message{}, fmt.Errorf("message %s: %w", del.ID, ErrForbidden)
+				},
+			},
+			wantStatus: 403,
+			wantBody: `{
+				"error": "Only the author or a moderator can delete a message"
+			}`,
+		},
+		{
+			name:      "DBUnavailable",
+			messageID: msgID,
+			userID:    "test",
+			db: &testdb{
+				deleteMessage: func(t *testing.T, del MessageDeletion) (Message, error) {
+					return Message{}, fmt.Errorf("select: %w", ErrUnavailable)
+				},
+			},
+			wantStatus: 503,
+			wantBody: `{
+				"error": "Could not delete message"
+			}`,
+		},
+		{
+			name:      "OK",
+			messageID: msgID,
+			userID:    "test",
+			db: &testdb{
+				deleteMessage: func(t *testing.T, del MessageDeletion) (Message, error) {
+					want := MessageDeletion{ID: msgID, AppID: DefaultAppID, UserID: "test"}
+					if diff := cmp.Diff(del, want, cmpopts.IgnoreFields(MessageDeletion{}, "DeletedAt")); diff != "" {
+						t.Errorf("Deletion differs (-got +want)\n%s", diff)
+					}
+					return deleted, nil
+				},
+			},
+			cache: &testcache{
+				updateMessage: func(t *testing.T, msg Message) error {
+					if diff := cmp.Diff(msg, deleted); diff != "" {
+						t.Errorf("Cached message was not marked as deleted (-got +want)\n%s", diff)
+					}
+					return nil
+				},
+			},
+			wantStatus: 204,
+		},
+		{
+			name:      "Moderator",
+			messageID: msgID,
+			userID:    "mod",
+			db: &testdb{
+				deleteMessage: func(t *testing.T, del MessageDeletion) (Message, error) {
+					if !del.Moderator {
+						t.Error("Moderator was treated as a regular user")
+					}
+					return deleted, nil
+				},
+			},
+			cache: &testcache{
+				updateMessage: func(t *testing.T, msg Message) error {
+					return errors.New("something went wrong")
+				},
+			},
+			// The message is deleted even if the cache fails.
+			wantStatus: 204,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:         tt.db,
+				Cache:      tt.cache,
+				Logger:     slogt.New(t),
+				Moderators: []string{"mod"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			u := srv.URL + "/messages/" + tt.messageID
+			if tt.userID != "" {
+				u += "?user_id=" + tt.userID
+			}
+			req, _ := http.NewRequest("DELETE", u, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPI_listMessages_deleted(t *testing.T) {
+	msgs := []Message{
+		{
+			ID:        "2",
+			Text:      "world",
+			UserID:    "testuser",
+			CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			Version:   1,
+			DeletedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID:        "1",
+			Text:      "hello",
+			UserID:    "testuser",
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
+		},
+	}
+	tests := []struct {
+		name       string
+		query      string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "TombstonesHidden",
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return slices.Clone(msgs), nil
+				},
+			},
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.IncludeDeleted {
+						t.Error("Deleted messages were requested from the DB")
+					}
+					if diff := cmp.Diff(excludeMsgIDs, []string{"1"}); diff != "" {
+						t.Errorf("Excluded messages differ (-got +want)\n%s", diff)
+					}
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"messages": [
+					{
+						"id": "1",
+						"text": "hello",
+						"user_id": "testuser",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"version": 1,
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					}
+				]
+			}`,
+		},
+		{
+			name:       "NotModerator",
+			query:      "?include_deleted=true&user_id=testuser",
+			wantStatus: 403,
+			wantBody: `{
+				"error": "Only moderators can list deleted messages"
+			}`,
+		},
+		{
+			name:       "InvalidIncludeDeleted",
+			query:      "?include_deleted=maybe&user_id=mod",
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Invalid query parameters",
+				"fields": [
+					{"field": "include_deleted", "code": "invalid_value", "message": "Include deleted must be true or false"}
+				]
+			}`,
+		},
+		{
+			name:  "Placeholder",
+			query: "?include_deleted=true&user_id=mod",
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					// The cache is skipped, it may hold purged messages.
+					if !opts.IncludeDeleted {
+						t.Error("Deleted messages were not requested from the DB")
+					}
+					return msgs, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"messages": [
+					{
+						"id": "2",
+						"text": "This message was deleted",
+						"user_id": "testuser",
+						"created_at": "Tue, 02 Jan 2024 00:00:00 UTC",
+						"version": 1,
+						"deleted_at": "Wed, 03 Jan 2024 00:00:00 UTC",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					},
+					{
+						"id": "1",
+						"text": "hello",
+						"user_id": "testuser",
+						"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+						"version": 1,
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:         tt.db,
+				Cache:      tt.cache,
+				Logger:     slogt.New(t),
+				Moderators: []string{"mod"},
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/messages"+tt.query, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_createReaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		db         *testdb
+		messageID  string
+		req        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidJSON",
+			req:        `not json`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body"
+			}`,
+		},
+		{
+			name:       "InvalidMessageID",
+			req:        `{"type": "like", "user_id": "test"}`,
+			messageID:  "12345",
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "MissingFields",
+			req:        `{}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "type", "code": "required", "message": "Reaction type must not be empty"},
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:       "UnknownType",
+			req:        `{"type": "meh", "user_id": "test"}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "type", "code": "invalid_value", "message": "Unknown reaction type \"meh\""}
+				]
+			}`,
+		},
+		{
+			name:       "ZeroScore",
+			req:        `{"type": "like", "score": 0, "user_id": "test"}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "score", "code": "out_of_range", "message": "Score must be between 1 and 100"}
+				]
+			}`,
+		},
+		{
+			name:       "NegativeScore",
+			req:        `{"type": "like", "score": -3, "user_id": "test"}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "score", "code": "out_of_range", "message": "Score must be between 1 and 100"}
+				]
+			}`,
+		},
+		{
+			name:       "ScoreTooHigh",
+			req:        `{"type": "clap", "score": 101, "user_id": "test"}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "score", "code": "out_of_range", "message": "Score must be between 1 and 100"}
+				]
+			}`,
+		},
+		{
+			name:       "InvalidScoreType",
+			req:        `{"type": "clap", "score": "ten", "user_id": "test"}`,
+			messageID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Could not decode request body",
+				"fields": [
+					{"field": "score", "code": "invalid_type", "message": "Expected a value of type int"}
+				]
+			}`,
+		},
+		{
+			name: "NotFound",
+			req: `{
+				"type": "thumbs_up",
+				"user_id": "test"
+			}`,
+			messageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					return Reaction{}, false, fmt.Errorf("message %s: %w", reaction.MessageID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name: "DBError",
+			req: `{
+				"type": "thumbs_up",
+				"user_id": "test"
+			}`,
+			messageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					return Reaction{}, false, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not insert reaction"
+			}`,
+		},
+		{
+			name: "OK",
+			req: `{
+				"type": "thumbs_up",
+				"user_id": "test"
+			}`,
+			messageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					if reaction.MessageID != "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a" {
+						t.Errorf("Got MessageID %q, want fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a", reaction.MessageID)
+					}
+					if reaction.UserID != "test" {
+						t.Errorf("Got UserID %q, want test", reaction.UserID)
+					}
+					if want := (ReactionOptions{MaxScore: 50}); opts != want {
+						t.Errorf("Got options %+v, want the defaults %+v", opts, want)
+					}
+					if reaction.Type != "thumbs_up" {
+						t.Errorf("Got Type %q, want thumbs_up", reaction.Type)
+					}
+					if reaction.Score != 1 {
+						t.Errorf("Got Score %d, want default score 1", reaction.Score)
+					}
+					return Reaction{
+						ID:        "1",
+						AppID:     reaction.AppID,
+						MessageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+						Score:     reaction.Score,
+						Type:      reaction.Type,
+						UserID:    reaction.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, true, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"message_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"type": "thumbs_up",
+				"score": 1,
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+		{
+			name: "Score",
+			req: `{
+				"type": "clap",
+				"score": 10,
+				"user_id": "test"
+			}`,
+			messageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					if reaction.Score != 10 {
+						t.Errorf("Got Score %d, want 10", reaction.Score)
+					}
+					return Reaction{
+						ID:        "1",
+						AppID:     reaction.AppID,
+						MessageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+						Score:     reaction.Score,
+						Type:      reaction.Type,
+						UserID:    reaction.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, true, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"message_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"type": "clap",
+				"score": 10,
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+		{
+			name: "Accumulate",
+			req: `{
+				"type": "clap",
+				"score": 10,
+				"user_id": "test"
+			}`,
+			messageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+			db: &testdb{
+				insertReaction: func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					return Reaction{
+						ID:        "1",
+						AppID:     reaction.AppID,
+						MessageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+						Score:     25,
+						Type:      reaction.Type,
+						UserID:    reaction.UserID,
+						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					}, false, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"id": "1",
+				"message_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"type": "clap",
+				"score": 25,
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("POST", srv.URL+"/messages/"+tt.messageID+"/reactions", strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_setReaction(t *testing.T) {
+	const msgID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	stored := func(r Reaction) Reaction {
+		r.ID = "1"
+		r.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		return r
+	}
+	tests := []struct {
+		name       string
+		messageID  string
+		reaction   string
+		req        string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidMessageID",
+			messageID:  "12345",
+			reaction:   "like",
+			req:        `{"user_id": "test"}`,
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "UnknownType",
+			messageID:  msgID,
+			reaction:   "meh",
+			req:        `{"score": 0, "user_id": "test"}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "type", "code": "invalid_value", "message": "Unknown reaction type \"meh\""},
+					{"field": "score", "code": "out_of_range", "message": "Score must be between 1 and 100"}
+				]
+			}`,
+		},
+		{
+			name:      "NotFound",
+			messageID: msgID,
+			reaction:  "like",
+			req:       `{"user_id": "test"}`,
+			db: &testdb{
+				setReaction: func(t *testing.T, r Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					return Reaction{}, false, fmt.Errorf("message %s: %w", r.MessageID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:      "Created",
+			messageID: msgID,
+			reaction:  "like",
+			req:       `{"user_id": "test"}`,
+			db: &testdb{
+				setReaction: func(t *testing.T, r Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					want := Reaction{AppID: DefaultAppID, MessageID: msgID, Type: "like", Score: 1, UserID: "test"}
+					if diff := cmp.Diff(r, want, cmpopts.IgnoreFields(Reaction{}, "CreatedAt")); diff != "" {
+						t.Errorf("Reaction differs (-got +want)\n%s", diff)
+					}
+					return stored(r), true, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"message_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"type": "like",
+				"score": 1,
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+		{
+			name:      "Updated",
+			messageID: msgID,
+			reaction:  "clap",
+			req:       `{"score": 20, "user_id": "test"}`,
+			db: &testdb{
+				setReaction: func(t *testing.T, r Reaction, opts ReactionOptions) (Reaction, bool, error) {
+					return stored(r), false, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"id": "1",
+				"message_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"type": "clap",
+				"score": 20,
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("PUT", srv.URL+"/messages/"+tt.messageID+"/reactions/"+tt.reaction, strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
 			checkBody(t, resp, tt.wantBody)
 		})
 	}
 }
 
+func TestAPI_deleteReaction(t *testing.T) {
+	const msgID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	tests := []struct {
+		name       string
+		path       string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidMessageID",
+			path:       "/messages/12345/reactions/like?user_id=test",
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "InvalidParameters",
+			path:       "/messages/" + msgID + "/reactions/meh",
+			wantStatus: 400,
+			wantBody: `{
+				"error": "Invalid request parameters",
+				"fields": [
+					{"field": "type", "code": "invalid_value", "message": "Unknown reaction type \"meh\""},
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name: "NotFound",
+			path: "/messages/" + msgID + "/reactions/like?user_id=test",
+			db: &testdb{
+				deleteReaction: func(t *testing.T, msgID, userID, reactionType string) (int, error) {
+					return 0, fmt.Errorf("message %s: %w", msgID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name: "DBError",
+			path: "/messages/" + msgID + "/reactions/like?user_id=test",
+			db: &testdb{
+				deleteReaction: func(t *testing.T, msgID, userID, reactionType string) (int, error) {
+					return 0, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not delete reaction"
+			}`,
+		},
+		{
+			name: "OK",
+			path: "/messages/" + msgID + "/reactions/like?user_id=test",
+			db: &testdb{
+				deleteReaction: func(t *testing.T, gotMsgID, userID, reactionType string) (int, error) {
+					if gotMsgID != msgID || userID != "test" || reactionType != "like" {
+						t.Errorf("Got DeleteReaction(%q, %q, %q)", gotMsgID, userID, reactionType)
+					}
+					return 1, nil
+				},
+			},
+			wantStatus: 204,
+		},
+		{
+			// Deleting a reaction that does not exist succeeds, so that
+			// the request can be retried.
+			name: "NothingToDelete",
+			path: "/messages/" + msgID + "/reactions/like?user_id=test",
+			db: &testdb{
+				deleteReaction: func(t *testing.T, msgID, userID, reactionType string) (int, error) {
+					return 0, nil
+				},
+			},
+			wantStatus: 204,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest("DELETE", srv.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+		})
+	}
+}
+
 type testdb struct {
-	T              *testing.T
-	listMessages   func(t *testing.T, excludeMsgIDs ...string) ([]Message, error)
+	T *testing.T
+	// app is the app that calls are expected to be scoped to. It defaults
+	// to the default app.
+	app string
+
+	listMessages   func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error)
 	insertMessage  func(t *testing.T, msg Message) (Message, error)
-	insertReaction func(t *testing.T, reaction Reaction) (Reaction, error)
+	getMessage     func(t *testing.T, msgID string) (Message, error)
+	updateMessage  func(t *testing.T, upd MessageUpdate) (Message, error)
+	messageHistory func(t *testing.T, msgID string) ([]MessageVersion, error)
+	deleteMessage  func(t *testing.T, del MessageDeletion) (Message, error)
+	purgeMessages  func(t *testing.T, deletedBefore time.Time) (int, error)
+	insertReaction func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error)
+	setReaction    func(t *testing.T, reaction Reaction, opts ReactionOptions) (Reaction, bool, error)
+	deleteReaction func(t *testing.T, msgID, userID, reactionType string) (int, error)
+	insertChannel  func(t *testing.T, ch Channel) (Channel, error)
+	getChannel     func(t *testing.T, channelID string) (Channel, error)
+
+	reactionSummaries func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error)
 }
 
-func (db *testdb) ListMessages(_ context.Context, excludeMsgIDs ...string) ([]Message, error) {
-	return db.listMessages(db.T, excludeMsgIDs...)
+func (db *testdb) ListMessages(_ context.Context, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+	return db.listMessages(db.T, opts, excludeMsgIDs...)
 }
 
 func (db *testdb) InsertMessage(_ context.Context, msg Message) (Message, error) {
 	return db.insertMessage(db.T, msg)
 }
 
-func (db *testdb) InsertReaction(_ context.Context, reaction Reaction) (Reaction, error) {
-	return db.insertReaction(db.T, reaction)
+// GetMessage defaults to a message in the default channel, since handlers
+// look up the channel of a message to publish events.
+func (db *testdb) GetMessage(_ context.Context, appID, msgID string) (Message, error) {
+	db.checkApp(appID)
+	if db.getMessage == nil {
+		return Message{ID: msgID, ChannelID: DefaultChannelID}, nil
+	}
+	return db.getMessage(db.T, msgID)
+}
+
+func (db *testdb) UpdateMessage(_ context.Context, upd MessageUpdate) (Message, error) {
+	return db.updateMessage(db.T, upd)
+}
+
+func (db *testdb) MessageHistory(_ context.Context, appID, msgID string) ([]MessageVersion, error) {
+	db.checkApp(appID)
+	return db.messageHistory(db.T, msgID)
+}
+
+func (db *testdb) DeleteMessage(_ context.Context, del MessageDeletion) (Message, error) {
+	return db.deleteMessage(db.T, del)
+}
+
+func (db *testdb) PurgeMessages(_ context.Context, deletedBefore time.Time) (int, error) {
+	return db.purgeMessages(db.T, deletedBefore)
+}
+
+func (db *testdb) InsertReaction(_ context.Context, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+	return db.insertReaction(db.T, reaction, opts)
+}
+
+func (db *testdb) SetReaction(_ context.Context, reaction Reaction, opts ReactionOptions) (Reaction, bool, error) {
+	return db.setReaction(db.T, reaction, opts)
+}
+
+func (db *testdb) DeleteReaction(_ context.Context, appID, msgID, userID, reactionType string) (int, error) {
+	db.checkApp(appID)
+	return db.deleteReaction(db.T, msgID, userID, reactionType)
+}
+
+func (db *testdb) InsertChannel(_ context.Context, ch Channel) (Channel, error) {
+	return db.insertChannel(db.T, ch)
+}
+
+func (db *testdb) GetChannel(_ context.Context, appID, channelID string) (Channel, error) {
+	db.checkApp(appID)
+	return db.getChannel(db.T, channelID)
+}
+
+func (db *testdb) ReactionSummaries(_ context.Context, appID string, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+	db.checkApp(appID)
+	// Most tests do not care about reactions.
+	if db.reactionSummaries == nil {
+		return nil, nil
+	}
+	return db.reactionSummaries(db.T, msgIDs, latest)
+}
+
+// checkApp fails the test if a call is scoped to another app than db.app.
+func (db *testdb) checkApp(appID string) {
+	db.T.Helper()
+	want := db.app
+	if want == "" {
+		want = DefaultAppID
+	}
+	if appID != want {
+		db.T.Errorf("Got app %q, want %q", appID, want)
+	}
 }
 
 type testcache struct {
 	T             *testing.T
-	listMessages  func(t *testing.T) ([]Message, error)
+	listMessages  func(t *testing.T, channelID string) ([]Message, error)
 	insertMessage func(t *testing.T, msg Message) error
+	updateMessage func(t *testing.T, msg Message) error
+	clear         func(t *testing.T) error
 }
 
-func (c *testcache) ListMessages(_ context.Context) ([]Message, error) {
-	return c.listMessages(c.T)
+func (c *testcache) ListMessages(_ context.Context, appID, channelID string) ([]Message, error) {
+	if appID != DefaultAppID {
+		c.T.Errorf("Got app %q, want %q", appID, DefaultAppID)
+	}
+	return c.listMessages(c.T, channelID)
 }
 
 func (c *testcache) InsertMessage(_ context.Context, msg Message) error {
 	return c.insertMessage(c.T, msg)
 }
 
+func (c *testcache) UpdateMessage(_ context.Context, msg Message) error {
+	return c.updateMessage(c.T, msg)
+}
+
+func (c *testcache) Clear(_ context.Context) error {
+	return c.clear(c.T)
+}
+
+type testqueue struct {
+	T       *testing.T
+	enqueue func(t *testing.T, msg Message) error
+	replay  func(t *testing.T, fn func(Message) error) (int, error)
+}
+
+func (q *testqueue) Enqueue(_ context.Context, msg Message) error {
+	return q.enqueue(q.T, msg)
+}
+
+func (q *testqueue) Replay(_ context.Context, fn func(Message) error) (int, error) {
+	return q.replay(q.T, fn)
+}
+
 func checkStatus(t *testing.T, got, want int) {
 	t.Helper()
 	if got != want {
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// An AppStore looks up the apps that API keys belong to.
+type AppStore interface {
+	// AppByKey returns the app of an API key. It returns ErrNotFound if the
+	// key does not exist or was revoked.
+	AppByKey(ctx context.Context, key string) (App, error)
+}
+
+// appKey is the context key of the app of a request.
+type appKey struct{}
+
+// AppFromContext returns the app of a request. Without an AppStore, all
+// requests belong to the default app.
+func AppFromContext(ctx context.Context) App {
+	if app, ok := ctx.Value(appKey{}).(App); ok {
+		return app
+	}
+	return App{ID: DefaultAppID}
+}
+
+// appID returns the id of the app of a request.
+func appID(r *http.Request) string {
+	return AppFromContext(r.Context()).ID
+}
+
+// identifyApp requires a valid API key if a.Apps is set, and scopes the
+// request to the app of the key. The key is taken from the X-API-Key header.
+// EventSource and WebSocket clients cannot set headers, so with queryKey
+// their routes take it from the api_key query parameter as well. Other
+// routes do not, so that keys stay out of URLs and the logs that hold them.
+func (a *API) identifyApp(queryKey bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Apps == nil {
+			h(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if key == "" && queryKey {
+			key = r.URL.Query().Get("api_key")
+		}
+		if key == "" {
+			a.respondError(w, http.StatusUnauthorized, errors.New("no API key"), "API key required")
+			return
+		}
+		app, err := a.Apps.AppByKey(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			a.respondError(w, http.StatusUnauthorized, err, "Invalid API key")
+			return
+		}
+		if err != nil {
+			a.respondDBError(w, err, "Could not check API key")
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), appKey{}, app)))
+	}
+}
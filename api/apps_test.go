@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_identifyApp(t *testing.T) {
+	const otherAppID = "5e0c8a4b-3f2d-4c1e-9a7b-6d8e2f1c0b3a"
+	apps := testapps{
+		"sk_default": {ID: DefaultAppID, Name: "default"},
+		"sk_other":   {ID: otherAppID, Name: "other"},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		apiKey     string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantAppID  string
+	}{
+		{
+			name:       "HealthCheck",
+			method:     "GET",
+			path:       "/healthz",
+			wantStatus: 200,
+			wantBody:   `{"status": "ok"}`,
+		},
+		{
+			name:       "NoKey",
+			method:     "POST",
+			path:       "/messages",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 401,
+			wantBody:   `{"error": "API key required"}`,
+		},
+		{
+			name:       "UnknownKey",
+			method:     "POST",
+			path:       "/messages",
+			apiKey:     "sk_unknown",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 401,
+			wantBody:   `{"error": "Invalid API key"}`,
+		},
+		{
+			name:       "StoreError",
+			method:     "POST",
+			path:       "/messages",
+			apiKey:     "sk_error",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 500,
+			wantBody:   `{"error": "Could not check API key"}`,
+		},
+		{
+			name:       "DefaultApp",
+			method:     "POST",
+			path:       "/messages",
+			apiKey:     "sk_default",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 201,
+			wantAppID:  DefaultAppID,
+		},
+		{
+			name:       "OtherApp",
+			method:     "POST",
+			path:       "/messages",
+			apiKey:     "sk_other",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 201,
+			wantAppID:  otherAppID,
+		},
+		{
+			name:       "QueryParameter",
+			method:     "POST",
+			path:       "/messages?api_key=sk_other",
+			body:       `{"text": "hello", "user_id": "alice"}`,
+			wantStatus: 401,
+			wantBody:   `{"error": "API key required"}`,
+		},
+		{
+			name:       "QueryParameterList",
+			method:     "GET",
+			path:       "/messages?api_key=sk_other",
+			wantStatus: 401,
+			wantBody:   `{"error": "API key required"}`,
+		},
+		{
+			name:       "QueryParameterStream",
+			method:     "GET",
+			path:       "/messages/stream?api_key=sk_unknown",
+			wantStatus: 401,
+			wantBody:   `{"error": "Invalid API key"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAppID string
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						gotAppID = msg.AppID
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Apps:   apps,
+				Logger: slogt.New(t),
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			resp := rec.Result()
+
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if gotAppID != tt.wantAppID {
+				t.Errorf("Got message of app %q, want %q", gotAppID, tt.wantAppID)
+			}
+		})
+	}
+}
+
+func TestAPI_identifyApp_scopesLookups(t *testing.T) {
+	const (
+		otherAppID = "5e0c8a4b-3f2d-4c1e-9a7b-6d8e2f1c0b3a"
+		channelID  = "8d6b37a4-5c56-4d5f-9c1b-2c8f6a1e4b7d"
+	)
+	api := &API{
+		DB: &testdb{
+			T:   t,
+			app: otherAppID,
+			getChannel: func(t *testing.T, id string) (Channel, error) {
+				return Channel{ID: id, AppID: otherAppID, Name: "random"}, nil
+			},
+		},
+		Apps:   testapps{"sk_other": {ID: otherAppID, Name: "other"}},
+		Logger: slogt.New(t),
+	}
+	req := httptest.NewRequest("GET", "/channels/"+channelID, nil)
+	req.Header.Set("X-API-Key", "sk_other")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	checkStatus(t, rec.Result().StatusCode, 200)
+}
+
+// testapps is an AppStore of API keys. The key sk_error fails.
+type testapps map[string]App
+
+func (apps testapps) AppByKey(_ context.Context, key string) (App, error) {
+	if key == "sk_error" {
+		return App{}, errors.New("something went wrong")
+	}
+	app, ok := apps[key]
+	if !ok {
+		return App{}, fmt.Errorf("api key: %w", ErrNotFound)
+	}
+	return app, nil
+}
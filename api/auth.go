@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Auth configures the verification of the JWTs that authenticate requests.
+// Tokens are signed with HS256 or RS256 and name the user in the sub claim.
+// At least one key must be set.
+type Auth struct {
+	// HMACSecret, if set, verifies HS256 tokens.
+	HMACSecret []byte
+	// RSAPublicKey, if set, verifies RS256 tokens.
+	RSAPublicKey *rsa.PublicKey
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+// userKey is the context key of the authenticated user.
+type userKey struct{}
+
+// UserFromContext returns the id of the authenticated user of a request.
+func UserFromContext(ctx context.Context) (userID string, ok bool) {
+	userID, ok = ctx.Value(userKey{}).(string)
+	return userID, ok
+}
+
+// authenticate requires a valid bearer token if a.Auth is set. The token is
+// taken from the Authorization header. EventSource and WebSocket clients
+// cannot set headers, so with queryToken their routes take it from the
+// access_token query parameter as well. Other routes do not, so that tokens
+// stay out of URLs and the logs that hold them.
+func (a *API) authenticate(queryToken bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Auth == nil {
+			h(w, r)
+			return
+		}
+		var token string
+		if queryToken {
+			token = r.URL.Query().Get("access_token")
+		}
+		if header := r.Header.Get("Authorization"); header != "" {
+			scheme, t, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				a.respondUnauthorized(w, fmt.Errorf("authorization scheme %q", scheme), "Invalid authorization header")
+				return
+			}
+			token = t
+		}
+		if token == "" {
+			a.respondUnauthorized(w, errors.New("no bearer token"), "Authentication required")
+			return
+		}
+		userID, err := a.Auth.verify(token)
+		if err != nil {
+			a.respondUnauthorized(w, err, "Invalid token")
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, userID)))
+	}
+}
+
+// verify checks the signature and claims of a token and returns its
+// subject. Without any key, every token is rejected.
+func (auth *Auth) verify(token string) (userID string, err error) {
+	var methods []string
+	if len(auth.HMACSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if auth.RSAPublicKey != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return "", errors.New("no key to verify tokens with")
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if auth.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(auth.Issuer))
+	}
+	if auth.Audience != "" {
+		opts = append(opts, jwt.WithAudience(auth.Audience))
+	}
+
+	t, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		// The method is one of the configured ones at this point.
+		if t.Method == jwt.SigningMethodHS256 {
+			return auth.HMACSecret, nil
+		}
+		return auth.RSAPublicKey, nil
+	}, opts...)
+	if err != nil {
+		return "", fmt.Errorf("parse token: %w", err)
+	}
+	sub, err := t.Claims.GetSubject()
+	if err != nil {
+		return "", fmt.Errorf("get subject: %w", err)
+	}
+	var v validator
+	v.userID("sub", sub)
+	if !v.valid() {
+		return "", fmt.Errorf("invalid subject %q", sub)
+	}
+	return sub, nil
+}
+
+// actingUser returns the user a request acts as. With authentication, it is
+// the authenticated user, and a userID given in the request has to match.
+// Without it, userID is trusted. If userID does not match, a response is
+// written and ok is false.
+func (a *API) actingUser(w http.ResponseWriter, r *http.Request, field, userID string) (_ string, ok bool) {
+	authUser, authenticated := UserFromContext(r.Context())
+	if !authenticated {
+		return userID, true
+	}
+	if userID != "" && userID != authUser {
+		err := fmt.Errorf("user %s acts as %s", authUser, userID)
+		a.respondFieldErrors(w, http.StatusForbidden, err, "Forbidden", FieldError{
+			Field:   field,
+			Code:    codeInvalidValue,
+			Message: "User ID must match the authenticated user",
+		})
+		return "", false
+	}
+	return authUser, true
+}
+
+// respondUnauthorized responds with 401 and asks for a bearer token.
+func (a *API) respondUnauthorized(w http.ResponseWriter, err error, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	a.respondError(w, http.StatusUnauthorized, err, msg)
+}
@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_authenticate(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + s
+	}
+	valid := jwt.MapClaims{
+		"sub": "alice",
+		"iss": "chat",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantUserID    string
+	}{
+		{
+			name:       "HealthCheck",
+			method:     "GET",
+			path:       "/healthz",
+			wantStatus: 200,
+			wantBody:   `{"status": "ok"}`,
+		},
+		{
+			name:       "NoToken",
+			method:     "POST",
+			path:       "/messages",
+			body:       `{"text": "hello"}`,
+			wantStatus: 401,
+			wantBody:   `{"error": "Authentication required"}`,
+		},
+		{
+			name:          "OtherScheme",
+			method:        "POST",
+			path:          "/messages",
+			authorization: "Basic YWxpY2U6c2VjcmV0",
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid authorization header"}`,
+		},
+		{
+			name:          "Malformed",
+			method:        "POST",
+			path:          "/messages",
+			authorization: "Bearer abc",
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "WrongSecret",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, []byte("other"), valid),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "UnsupportedAlgorithm",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS512, secret, valid),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "Expired",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, with("exp", time.Now().Add(-time.Minute).Unix())),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "NoExpiry",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, with("exp", nil)),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "WrongIssuer",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, with("iss", "other")),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "InvalidSubject",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, with("sub", "alice smith")),
+			body:          `{"text": "hello"}`,
+			wantStatus:    401,
+			wantBody:      `{"error": "Invalid token"}`,
+		},
+		{
+			name:          "HS256",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, valid),
+			body:          `{"text": "hello"}`,
+			wantStatus:    201,
+			wantUserID:    "alice",
+		},
+		{
+			name:          "RS256",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodRS256, rsaKey, valid),
+			body:          `{"text": "hello"}`,
+			wantStatus:    201,
+			wantUserID:    "alice",
+		},
+		{
+			name:          "MatchingUserID",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, valid),
+			body:          `{"text": "hello", "user_id": "alice"}`,
+			wantStatus:    201,
+			wantUserID:    "alice",
+		},
+		{
+			name:          "OtherUserID",
+			method:        "POST",
+			path:          "/messages",
+			authorization: sign(jwt.SigningMethodHS256, secret, valid),
+			body:          `{"text": "hello", "user_id": "mallory"}`,
+			wantStatus:    403,
+			wantBody: `{"error": "Forbidden", "fields": [
+				{"field": "user_id", "code": "invalid_value", "message": "User ID must match the authenticated user"}
+			]}`,
+		},
+		{
+			name:       "TokenInQuery",
+			method:     "POST",
+			path:       "/messages?access_token=" + strings.TrimPrefix(sign(jwt.SigningMethodHS256, secret, valid), "Bearer "),
+			body:       `{"text": "hello"}`,
+			wantStatus: 401,
+			wantBody:   `{"error": "Authentication required"}`,
+		},
+		{
+			name:          "OtherUserIDInQuery",
+			method:        "DELETE",
+			path:          "/messages/fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a/reactions/like?user_id=mallory",
+			authorization: sign(jwt.SigningMethodHS256, secret, valid),
+			wantStatus:    403,
+			wantBody: `{"error": "Forbidden", "fields": [
+				{"field": "user_id", "code": "invalid_value", "message": "User ID must match the authenticated user"}
+			]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID string
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						gotUserID = msg.UserID
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Logger: slogt.New(t),
+				Auth: &Auth{
+					HMACSecret:   secret,
+					RSAPublicKey: &rsaKey.PublicKey,
+					Issuer:       "chat",
+				},
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			resp := rec.Result()
+
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantStatus == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("No WWW-Authenticate header")
+			}
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if gotUserID != tt.wantUserID {
+				t.Errorf("Got message of %q, want %q", gotUserID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func TestAPI_authenticate_streamQueryToken(t *testing.T) {
+	secret := []byte("secret")
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &API{
+		DB:     &testdb{T: t},
+		Logger: slogt.New(t),
+		Auth:   &Auth{HMACSecret: secret},
+	}
+	// A draining server ends streams right away, once the request is
+	// authenticated.
+	api.Drain()
+
+	for _, tt := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/messages/stream", 401},
+		{"/messages/stream?access_token=" + token, 503},
+	} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		checkStatus(t, rec.Result().StatusCode, tt.wantStatus)
+	}
+}
+
+func TestAuth_verify_noKey(t *testing.T) {
+	for _, key := range [][]byte{nil, {}} {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": "alice",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth := &Auth{HMACSecret: key}
+		if userID, err := auth.verify(token); err == nil {
+			t.Errorf("Got user %q from a token signed with an empty key, want an error", userID)
+		}
+	}
+}
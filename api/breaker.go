@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCacheUnavailable is returned by a CacheBreaker while its circuit is
+// open.
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls go through
+	breakerOpen                         // calls fail fast
+	breakerHalfOpen                     // a single probe goes through
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("breakerState(%d)", int(s))
+}
+
+// A CacheBreaker wraps a Cache with a circuit breaker, so that an unavailable
+// cache does not slow down or fail requests.
+//
+// After Threshold consecutive failures the circuit opens and all calls fail
+// with ErrCacheUnavailable without reaching the cache. Once Cooldown has
+// passed, a single call is let through to probe the cache. If it succeeds
+// the circuit closes again, otherwise it stays open for another Cooldown.
+//
+// Writes that fail or are skipped leave the cache stale, so the cache is
+// cleared before it is used again.
+type CacheBreaker struct {
+	Cache     Cache
+	Logger    *slog.Logger
+	Threshold int           // defaults to 5
+	Cooldown  time.Duration // defaults to 10s
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	stale    bool
+	now      func() time.Time // for tests
+}
+
+// ListMessages returns the cached messages of a channel, or
+// ErrCacheUnavailable if the circuit is open.
+func (b *CacheBreaker) ListMessages(ctx context.Context, appID, channelID string) ([]Message, error) {
+	var msgs []Message
+	err := b.do(ctx, false, func() error {
+		var err error
+		msgs, err = b.Cache.ListMessages(ctx, appID, channelID)
+		return err
+	})
+	return msgs, err
+}
+
+// InsertMessage adds the message to the cache, or returns
+// ErrCacheUnavailable if the circuit is open.
+func (b *CacheBreaker) InsertMessage(ctx context.Context, msg Message) error {
+	return b.do(ctx, true, func() error {
+		return b.Cache.InsertMessage(ctx, msg)
+	})
+}
+
+// UpdateMessage replaces a cached message, or returns ErrCacheUnavailable if
+// the circuit is open.
+func (b *CacheBreaker) UpdateMessage(ctx context.Context, msg Message) error {
+	return b.do(ctx, true, func() error {
+		return b.Cache.UpdateMessage(ctx, msg)
+	})
+}
+
+// Clear removes all messages from the cache, or returns ErrCacheUnavailable
+// if the circuit is open.
+func (b *CacheBreaker) Clear(ctx context.Context) error {
+	return b.do(ctx, false, func() error {
+		return b.Cache.Clear(ctx)
+	})
+}
+
+// Ping pings the cache, regardless of the state of the circuit. It does not
+// change the state either.
+func (b *CacheBreaker) Ping(ctx context.Context) error {
+	return ping(ctx, b.Cache)
+}
+
+// Available reports whether calls currently go through to the cache.
+func (b *CacheBreaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerClosed
+}
+
+func (b *CacheBreaker) do(ctx context.Context, write bool, fn func() error) error {
+	stale, ok := b.allow(write)
+	if !ok {
+		return ErrCacheUnavailable
+	}
+	var err error
+	if stale {
+		if err = b.Cache.Clear(ctx); err != nil {
+			err = fmt.Errorf("clear stale cache: %w", err)
+		} else {
+			b.mu.Lock()
+			b.stale = false
+			b.mu.Unlock()
+		}
+	}
+	if err == nil {
+		err = fn()
+	}
+	b.record(ctx, write, err)
+	return err
+}
+
+// allow reports whether a call may go through, and whether the cache must
+// be cleared first.
+func (b *CacheBreaker) allow(write bool) (stale, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.clock().Sub(b.openedAt) >= b.cooldown() {
+			b.setState(breakerHalfOpen)
+			return b.stale, true
+		}
+	case breakerClosed:
+		return b.stale, true
+	}
+	// The write is skipped, so the cache misses the message.
+	if write {
+		b.stale = true
+	}
+	return false, false
+}
+
+func (b *CacheBreaker) record(ctx context.Context, write bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	if write {
+		b.stale = true
+	}
+	// Calls canceled by the client say nothing about the cache. A canceled
+	// probe is retried after another cooldown, as if it had failed.
+	if ctx.Err() != nil {
+		if b.state == breakerHalfOpen {
+			b.openedAt = b.clock()
+			b.setState(breakerOpen)
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold() {
+		b.openedAt = b.clock()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *CacheBreaker) setState(s breakerState) {
+	if b.Logger != nil && s != b.state {
+		level := slog.LevelInfo
+		if s == breakerOpen {
+			level = slog.LevelWarn
+		}
+		b.Logger.Log(context.Background(), level, "Cache circuit breaker changed state", "from", b.state.String(), "to", s.String())
+	}
+	b.state = s
+}
+
+func (b *CacheBreaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return defaultBreakerThreshold
+}
+
+func (b *CacheBreaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return defaultBreakerCooldown
+}
+
+func (b *CacheBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestCacheBreaker(t *testing.T) {
+	var (
+		now     = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		down    = true
+		calls   int
+		cleared int
+	)
+	cache := &testcache{
+		T: t,
+		listMessages: func(t *testing.T, _ string) ([]Message, error) {
+			calls++
+			if down {
+				return nil, errors.New("connection refused")
+			}
+			return []Message{}, nil
+		},
+		insertMessage: func(t *testing.T, msg Message) error {
+			calls++
+			if down {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		clear: func(t *testing.T) error {
+			if down {
+				return errors.New("connection refused")
+			}
+			cleared++
+			return nil
+		},
+	}
+	b := &CacheBreaker{
+		Cache:     cache,
+		Logger:    slogt.New(t),
+		Threshold: 3,
+		Cooldown:  time.Minute,
+		now:       func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	// The circuit opens after three failures.
+	for i := 0; i < 3; i++ {
+		if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err == nil || errors.Is(err, ErrCacheUnavailable) {
+			t.Fatalf("Call %d: got error %v, want the cache error", i+1, err)
+		}
+	}
+	if b.Available() {
+		t.Fatal("Circuit is closed after reaching the failure threshold")
+	}
+
+	// While open, calls fail fast and writes are skipped.
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("Got error %v, want %v", err, ErrCacheUnavailable)
+	}
+	if err := b.InsertMessage(ctx, Message{ID: "1"}); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("Got error %v, want %v", err, ErrCacheUnavailable)
+	}
+	if calls != 3 {
+		t.Errorf("Cache was called %d times, want 3", calls)
+	}
+
+	// After the cooldown a failing probe opens the circuit again.
+	now = now.Add(time.Minute)
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err == nil || errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("Got error %v, want the cache error", err)
+	}
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("Got error %v, want %v", err, ErrCacheUnavailable)
+	}
+
+	// Once the cache is back, a successful probe closes the circuit. The
+	// cache missed a write, so it is cleared first.
+	down = false
+	now = now.Add(time.Minute)
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Available() {
+		t.Error("Circuit is open after a successful probe")
+	}
+	if cleared != 1 {
+		t.Errorf("Cache was cleared %d times, want 1", cleared)
+	}
+	if err := b.InsertMessage(ctx, Message{ID: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 1 {
+		t.Errorf("Cache was cleared %d times, want 1", cleared)
+	}
+}
+
+func TestCacheBreaker_FailedWrite(t *testing.T) {
+	var cleared int
+	fail := true
+	cache := &testcache{
+		T: t,
+		listMessages: func(t *testing.T, _ string) ([]Message, error) {
+			return []Message{}, nil
+		},
+		insertMessage: func(t *testing.T, msg Message) error {
+			if fail {
+				return errors.New("timeout")
+			}
+			return nil
+		},
+		clear: func(t *testing.T) error {
+			cleared++
+			return nil
+		},
+	}
+	b := &CacheBreaker{Cache: cache}
+	ctx := context.Background()
+
+	if err := b.InsertMessage(ctx, Message{ID: "1"}); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	// The cache misses a message, so it must be cleared before it is read.
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 1 {
+		t.Errorf("Cache was cleared %d times, want 1", cleared)
+	}
+}
+
+func TestCacheBreaker_Canceled(t *testing.T) {
+	cache := &testcache{
+		T: t,
+		listMessages: func(t *testing.T, _ string) ([]Message, error) {
+			return nil, context.Canceled
+		},
+	}
+	b := &CacheBreaker{Cache: cache, Threshold: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err == nil {
+		t.Fatal("Expected the call to fail")
+	}
+	if !b.Available() {
+		t.Error("Circuit opened because of a canceled request")
+	}
+}
+
+func TestCacheBreaker_CanceledProbe(t *testing.T) {
+	var (
+		now   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		calls int
+	)
+	cache := &testcache{
+		T: t,
+		listMessages: func(t *testing.T, _ string) ([]Message, error) {
+			calls++
+			return nil, errors.New("connection refused")
+		},
+	}
+	b := &CacheBreaker{
+		Cache:     cache,
+		Threshold: 1,
+		Cooldown:  time.Minute,
+		now:       func() time.Time { return now },
+	}
+	if _, err := b.ListMessages(context.Background(), DefaultAppID, DefaultChannelID); err == nil {
+		t.Fatal("Expected the call to fail")
+	}
+
+	// The probe after the cooldown is canceled by its client.
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.ListMessages(ctx, DefaultAppID, DefaultChannelID); err == nil || errors.Is(err, ErrCacheUnavailable) {
+		t.Fatalf("Got error %v, want the cache error", err)
+	}
+
+	// The next probe waits for another cooldown.
+	if _, err := b.ListMessages(context.Background(), DefaultAppID, DefaultChannelID); !errors.Is(err, ErrCacheUnavailable) {
+		t.Errorf("Got error %v right after the canceled probe, want %v", err, ErrCacheUnavailable)
+	}
+	if calls != 2 {
+		t.Errorf("Cache was called %d times, want 2", calls)
+	}
+}
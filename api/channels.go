@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func (a *API) createChannel(w http.ResponseWriter, r *http.Request) {
+	type (
+		request struct {
+			Name string `json:"name"`
+		}
+		response struct {
+			ID        string `json:"id"`
+			Name      string `json:"name"`
+			CreatedAt string `json:"created_at"`
+		}
+	)
+
+	var body request
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	var v validator
+	v.channelName("name", body.Name)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+
+	channel, err := a.DB.InsertChannel(r.Context(), Channel{
+		ID:        newID(),
+		AppID:     appID(r),
+		Name:      body.Name,
+		CreatedAt: now(),
+	})
+	if err != nil {
+		a.respondDBError(w, err, "Could not create channel")
+		return
+	}
+	a.respond(w, http.StatusCreated, response{
+		ID:        channel.ID,
+		Name:      channel.Name,
+		CreatedAt: channel.CreatedAt.Format(time.RFC1123),
+	})
+}
+
+func (a *API) getChannel(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		CreatedAt string `json:"created_at"`
+	}
+
+	channelID, ok := a.channelID(w, r)
+	if !ok {
+		return
+	}
+	channel, err := a.DB.GetChannel(r.Context(), appID(r), channelID)
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Channel not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not get channel")
+		return
+	}
+	a.respond(w, http.StatusOK, response{
+		ID:        channel.ID,
+		Name:      channel.Name,
+		CreatedAt: channel.CreatedAt.Format(time.RFC1123),
+	})
+}
+
+// channelID returns the channel of a request, which is the default channel
+// for the routes that are not scoped to a channel. If the channel id is
+// malformed, a response is written and ok is false.
+func (a *API) channelID(w http.ResponseWriter, r *http.Request) (id string, ok bool) {
+	id = r.PathValue("channelID")
+	if id == "" {
+		return DefaultChannelID, true
+	}
+	if !validID(id) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid channel id %q", id), "Channel not found")
+		return "", false
+	}
+	return id, true
+}
+
+// inChannel responds with 404 unless the message of the request belongs to
+// the channel of the request. Messages have globally unique ids, so the
+// routes that are not scoped to a channel find messages in any channel.
+func (a *API) inChannel(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channelID := r.PathValue("channelID")
+		if channelID == "" {
+			h(w, r)
+			return
+		}
+		messageID := r.PathValue("messageID")
+		if !validID(channelID) || !validID(messageID) {
+			err := fmt.Errorf("invalid channel id %q or message id %q", channelID, messageID)
+			a.respondError(w, http.StatusNotFound, err, "Message not found")
+			return
+		}
+		msg, err := a.DB.GetMessage(r.Context(), appID(r), messageID)
+		if err == nil && msg.ChannelID != channelID {
+			err = fmt.Errorf("message %s is in channel %s: %w", messageID, msg.ChannelID, ErrNotFound)
+		}
+		if errors.Is(err, ErrNotFound) {
+			a.respondError(w, http.StatusNotFound, err, "Message not found")
+			return
+		}
+		if err != nil {
+			a.respondDBError(w, err, "Could not get message")
+			return
+		}
+		h(w, r)
+	}
+}
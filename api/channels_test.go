@@ -0,0 +1,375 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_createChannel(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "MissingName",
+			req:        `{}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "name", "code": "required", "message": "Name must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:       "NameTooLong",
+			req:        `{"name": "` + strings.Repeat("a", maxChannelName+1) + `"}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "name", "code": "too_long", "message": "Name must be at most 100 characters"}
+				]
+			}`,
+		},
+		{
+			name: "DBError",
+			req:  `{"name": "random"}`,
+			db: &testdb{
+				insertChannel: func(t *testing.T, ch Channel) (Channel, error) {
+					return Channel{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not create channel"
+			}`,
+		},
+		{
+			name: "OK",
+			req:  `{"name": "random"}`,
+			db: &testdb{
+				insertChannel: func(t *testing.T, ch Channel) (Channel, error) {
+					if ch.ID == "" || ch.CreatedAt.IsZero() {
+						t.Errorf("Channel has no id or creation time: %+v", ch)
+					}
+					if ch.Name != "random" {
+						t.Errorf("Got Name %q, want random", ch.Name)
+					}
+					ch.ID = "1"
+					ch.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return ch, nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"name": "random",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Post(srv.URL+"/channels", "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_getChannel(t *testing.T) {
+	const channelID = "3b0b9c1e-0c4f-4d8a-9d7e-2f5a6b7c8d9e"
+	tests := []struct {
+		name       string
+		channelID  string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidID",
+			channelID:  "12345",
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Channel not found"
+			}`,
+		},
+		{
+			name:      "NotFound",
+			channelID: channelID,
+			db: &testdb{
+				getChannel: func(t *testing.T, channelID string) (Channel, error) {
+					return Channel{}, fmt.Errorf("channel %s: %w", channelID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Channel not found"
+			}`,
+		},
+		{
+			name:      "OK",
+			channelID: channelID,
+			db: &testdb{
+				getChannel: func(t *testing.T, id string) (Channel, error) {
+					if id != channelID {
+						t.Errorf("Got channel id %q, want %q", id, channelID)
+					}
+					return Channel{ID: id, Name: "random", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"id": "3b0b9c1e-0c4f-4d8a-9d7e-2f5a6b7c8d9e",
+				"name": "random",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/channels/" + tt.channelID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_channelMessages(t *testing.T) {
+	const (
+		channelID = "3b0b9c1e-0c4f-4d8a-9d7e-2f5a6b7c8d9e"
+		msgID     = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	)
+	notFound := func(t *testing.T, id string) (Channel, error) {
+		return Channel{}, fmt.Errorf("channel %s: %w", id, ErrNotFound)
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		req        string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "List/InvalidChannelID",
+			method:     "GET",
+			path:       "/channels/12345/messages",
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Channel not found"
+			}`,
+		},
+		{
+			name:   "List/UnknownChannel",
+			method: "GET",
+			path:   "/channels/" + channelID + "/messages",
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, nil
+				},
+				getChannel: notFound,
+			},
+			cache: &testcache{
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Channel not found"
+			}`,
+		},
+		{
+			name:   "List/OK",
+			method: "GET",
+			path:   "/channels/" + channelID + "/messages",
+			db: &testdb{
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.ChannelID != channelID {
+						t.Errorf("Got ChannelID %q, want %q", opts.ChannelID, channelID)
+					}
+					return nil, nil
+				},
+				getChannel: func(t *testing.T, id string) (Channel, error) {
+					return Channel{ID: id, Name: "random"}, nil
+				},
+			},
+			cache: &testcache{
+				listMessages: func(t *testing.T, id string) ([]Message, error) {
+					if id != channelID {
+						t.Errorf("Got cached messages of channel %q, want %q", id, channelID)
+					}
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"messages": []
+			}`,
+		},
+		{
+			name:   "Create/UnknownChannel",
+			method: "POST",
+			path:   "/channels/" + channelID + "/messages",
+			req:    `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, fmt.Errorf("channel %s: %w", msg.ChannelID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Channel not found"
+			}`,
+		},
+		{
+			name:   "Create/OK",
+			method: "POST",
+			path:   "/channels/" + channelID + "/messages",
+			req:    `{"text": "hello", "user_id": "test"}`,
+			db: &testdb{
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if msg.ChannelID != channelID {
+						t.Errorf("Got ChannelID %q, want %q", msg.ChannelID, channelID)
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"text": "hello",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+				"version": 1
+			}`,
+		},
+		{
+			name:   "Message/OtherChannel",
+			method: "GET",
+			path:   "/channels/" + channelID + "/messages/" + msgID + "/history",
+			db: &testdb{
+				getMessage: func(t *testing.T, id string) (Message, error) {
+					return Message{ID: id, ChannelID: DefaultChannelID}, nil
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:   "Message/DBError",
+			method: "GET",
+			path:   "/channels/" + channelID + "/messages/" + msgID + "/history",
+			db: &testdb{
+				getMessage: func(t *testing.T, id string) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not get message"
+			}`,
+		},
+		{
+			name:   "Message/OK",
+			method: "GET",
+			path:   "/channels/" + channelID + "/messages/" + msgID + "/history",
+			db: &testdb{
+				getMessage: func(t *testing.T, id string) (Message, error) {
+					return Message{ID: id, ChannelID: channelID}, nil
+				},
+				messageHistory: func(t *testing.T, id string) ([]MessageVersion, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"history": []
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.req))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A Queue durably holds messages that could not be written to the DB, so that
+// they can be replayed once the DB is available again.
+type Queue interface {
+	Enqueue(ctx context.Context, msg Message) error
+	// Replay calls fn for each queued message, oldest first. A message is
+	// removed from the queue once fn succeeds for it. Replay stops at the
+	// first error and returns the number of replayed messages.
+	Replay(ctx context.Context, fn func(Message) error) (int, error)
+}
+
+// ReplayQueue writes the queued messages to the DB every interval until ctx
+// is canceled. Messages keep the id and timestamp they were queued with.
+func (a *API) ReplayQueue(ctx context.Context, interval time.Duration) {
+	if a.Queue == nil {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := a.Queue.Replay(ctx, func(msg Message) error {
+			if msg.ChannelID == "" {
+				// Queued before messages had a channel.
+				msg.ChannelID = DefaultChannelID
+			}
+			_, err := a.DB.InsertMessage(ctx, msg)
+			switch {
+			case errors.Is(err, ErrConflict):
+				// Written by an earlier attempt or another instance.
+				return nil
+			case errors.Is(err, ErrNotFound):
+				// The channel does not exist, so the message can never be
+				// written. Drop it rather than block the queue.
+				a.Logger.Warn("Dropping queued message of unknown channel", "message_id", msg.ID, "channel_id", msg.ChannelID)
+				return nil
+			}
+			return err
+		})
+		if n > 0 {
+			a.Logger.Info("Replayed queued messages", "count", n)
+		}
+		if err != nil && !errors.Is(err, ErrUnavailable) && ctx.Err() == nil {
+			a.Logger.Error("Could not replay queued messages", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// respondDBError responds with 503 if the DB is unavailable and with 500
+// otherwise.
+func (a *API) respondDBError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, ErrUnavailable) {
+		w.Header().Set("Retry-After", "5")
+		a.respondError(w, http.StatusServiceUnavailable, err, msg)
+		return
+	}
+	a.respondError(w, http.StatusInternalServerError, err, msg)
+}
+
+// markDegraded flags a response as served without the DB.
+func markDegraded(w http.ResponseWriter) {
+	w.Header().Set("X-Degraded", "true")
+}
+
+// newID returns a new random resource id.
+func newID() string {
+	return uuid.NewString()
+}
+
+// now returns the current time with the precision of a Postgres timestamp, so
+// that timestamps do not change when they are written to the DB.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// deletedPlaceholder replaces the text of deleted messages when moderators
+// list them.
+const deletedPlaceholder = "This message was deleted"
+
+// isModerator reports whether the user may delete any message and list
+// deleted messages.
+func (a *API) isModerator(userID string) bool {
+	return userID != "" && slices.Contains(a.Moderators, userID)
+}
+
+// deleteMessage soft deletes a message. The user is given by the user_id
+// query parameter and must be the author of the message or a moderator.
+func (a *API) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	messageID := r.PathValue("messageID")
+	if !validID(messageID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", messageID), "Message not found")
+		return
+	}
+	userID, ok := a.actingUser(w, r, "user_id", r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	var v validator
+	v.userID("user_id", userID)
+	if !v.valid() {
+		err := fmt.Errorf("invalid query: %d field errors", len(v.errs))
+		a.respondFieldErrors(w, http.StatusBadRequest, err, "Invalid query parameters", v.errs...)
+		return
+	}
+
+	msg, err := a.DB.DeleteMessage(r.Context(), MessageDeletion{
+		ID:        messageID,
+		AppID:     appID(r),
+		UserID:    userID,
+		Moderator: a.isModerator(userID),
+		DeletedAt: now(),
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	case errors.Is(err, ErrForbidden):
+		a.respondError(w, http.StatusForbidden, err, "Only the author or a moderator can delete a message")
+		return
+	case err != nil:
+		a.respondDBError(w, err, "Could not delete message")
+		return
+	}
+
+	// Leave a tombstone rather than removing the message, so that a cached
+	// page never falls back to a stale copy of it.
+	if err := a.Cache.UpdateMessage(r.Context(), msg); err != nil {
+		a.Logger.Error("Could not mark cached message as deleted", "error", err.Error())
+	}
+	if msg.ParentID != "" {
+		a.refreshCachedParent(r.Context(), appID(r), msg.ParentID)
+	}
+	a.publishMessage(r.Context(), EventMessageDeleted, msg)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeDeleted permanently removes the messages that were deleted more than
+// retention ago, every interval until ctx is canceled.
+func (a *API) PurgeDeleted(ctx context.Context, interval, retention time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := a.DB.PurgeMessages(ctx, now().Add(-retention))
+		if n > 0 {
+			a.Logger.Info("Purged deleted messages", "count", n)
+		}
+		if err != nil && !errors.Is(err, ErrUnavailable) && ctx.Err() == nil {
+			a.Logger.Error("Could not purge deleted messages", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
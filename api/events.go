@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The types of the events published to realtime clients.
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageUpdated  = "message.updated"
+	EventMessageDeleted  = "message.deleted"
+	EventReactionNew     = "reaction.new"
+	EventReactionDeleted = "reaction.deleted"
+)
+
+// defaultEventBuffer is the number of recent events kept for clients that
+// resume a stream.
+const defaultEventBuffer = 1000
+
+// subscriptionBuffer is the number of events a subscriber may fall behind
+// before it is dropped.
+const subscriptionBuffer = 64
+
+// errHubClosed is returned when subscribing after the hub was closed.
+var errHubClosed = errors.New("event hub closed")
+
+// An EventBus delivers events to every instance of the API, so that realtime
+// clients receive the changes made through any instance.
+type EventBus interface {
+	// Publish assigns e the next event id and sends it to the subscribers
+	// of all instances, including this one. Ids increase across all
+	// instances, so clients may resume a stream on any of them.
+	Publish(ctx context.Context, e Event) error
+	// Subscribe calls fn for every event published from the time it returns
+	// until ctx is canceled. Events are not redelivered, so the events
+	// published while the bus is unavailable are lost.
+	Subscribe(ctx context.Context, fn func(Event)) error
+}
+
+// An Event is a change to a message or its reactions that is pushed to
+// realtime clients.
+type Event struct {
+	// ID is assigned when the event is published, by the Bus if there is
+	// one. It increases with every event.
+	ID        uint64
+	Type      string
+	AppID     string
+	ChannelID string
+	MessageID string
+	// ParentID is the message whose thread the event belongs to, if any.
+	ParentID string
+	// Data is the JSON payload sent to clients.
+	Data json.RawMessage
+	// TraceContext holds the W3C trace context of the request that made the
+	// change, so that relaying the event continues its trace.
+	TraceContext map[string]string
+}
+
+// A hub fans out events to subscribers and keeps the latest events, so that
+// clients can resume after reconnecting. It is safe for concurrent use.
+type hub struct {
+	mu     sync.Mutex
+	size   int
+	nextID uint64
+	events []Event // the latest events, oldest first
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+// A subscription receives the events that match its filter. Its channel is
+// closed when the subscriber falls behind or the hub is closed.
+type subscription struct {
+	events chan Event
+	match  func(Event) bool
+}
+
+// newHub returns a hub that keeps the latest size events.
+func newHub(size int) *hub {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+	return &hub{
+		size:   size,
+		nextID: 1,
+		subs:   make(map[*subscription]struct{}),
+	}
+}
+
+// publish sends e to the matching subscribers. Events from the bus keep
+// their id, and others are given the id after the latest one. Subscribers
+// that are too far behind are dropped rather than slowing down the
+// publisher.
+func (h *hub) publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e.ID == 0 {
+		e.ID = h.nextID
+	}
+	h.nextID = max(h.nextID, e.ID+1)
+	if len(h.events) == h.size {
+		h.events = h.events[1:]
+	}
+	h.events = append(h.events, e)
+
+	for s := range h.subs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			delete(h.subs, s)
+			close(s.events)
+		}
+	}
+	return e
+}
+
+// subscribe registers a subscriber for the events that match. It returns
+// the kept events after lastID, so that no event is missed between the
+// replay and the subscription. Events that were already evicted cannot be
+// replayed.
+func (h *hub) subscribe(lastID uint64, match func(Event) bool) (*subscription, []Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, errHubClosed
+	}
+	var replay []Event
+	for _, e := range h.events {
+		if e.ID > lastID && match(e) {
+			replay = append(replay, e)
+		}
+	}
+	s := &subscription{
+		events: make(chan Event, subscriptionBuffer),
+		match:  match,
+	}
+	h.subs[s] = struct{}{}
+	return s, replay, nil
+}
+
+// unsubscribe removes the subscriber. It is a no-op if the subscriber was
+// already dropped.
+func (h *hub) unsubscribe(s *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// close ends all subscriptions and rejects new ones.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// publishMessage publishes a change to a message.
+func (a *API) publishMessage(ctx context.Context, typ string, msg Message) {
+	type payload struct {
+		ID            string `json:"id"`
+		ChannelID     string `json:"channel_id"`
+		ParentID      string `json:"parent_id,omitempty"`
+		Text          string `json:"text"`
+		UserID        string `json:"user_id"`
+		CreatedAt     string `json:"created_at"`
+		UpdatedAt     string `json:"updated_at,omitempty"`
+		Version       int    `json:"version"`
+		DeletedAt     string `json:"deleted_at,omitempty"`
+		ShowInChannel bool   `json:"show_in_channel,omitempty"`
+	}
+
+	p := payload{
+		ID:            msg.ID,
+		ChannelID:     msg.ChannelID,
+		ParentID:      msg.ParentID,
+		Text:          msg.Text,
+		UserID:        msg.UserID,
+		CreatedAt:     msg.CreatedAt.Format(time.RFC1123),
+		Version:       msg.Version,
+		ShowInChannel: msg.ShowInChannel,
+	}
+	if !msg.UpdatedAt.IsZero() {
+		p.UpdatedAt = msg.UpdatedAt.Format(time.RFC1123)
+	}
+	if msg.Deleted() {
+		p.Text = deletedPlaceholder
+		p.DeletedAt = msg.DeletedAt.Format(time.RFC1123)
+	}
+	a.publish(ctx, Event{
+		Type:      typ,
+		AppID:     msg.AppID,
+		ChannelID: msg.ChannelID,
+		MessageID: msg.ID,
+		ParentID:  msg.ParentID,
+	}, p)
+}
+
+// publishReaction publishes a change to the reactions of a message. The
+// message is looked up to find the channel and thread it belongs to.
+func (a *API) publishReaction(ctx context.Context, typ string, r Reaction) {
+	type payload struct {
+		ID        string `json:"id,omitempty"`
+		MessageID string `json:"message_id"`
+		Type      string `json:"type"`
+		Score     int    `json:"score,omitempty"`
+		UserID    string `json:"user_id"`
+		CreatedAt string `json:"created_at,omitempty"`
+	}
+
+	msg, err := a.DB.GetMessage(ctx, r.AppID, r.MessageID)
+	if err != nil {
+		a.Logger.Error("Could not publish reaction event", "error", err.Error())
+		return
+	}
+	p := payload{
+		ID:        r.ID,
+		MessageID: r.MessageID,
+		Type:      r.Type,
+		Score:     r.Score,
+		UserID:    r.UserID,
+	}
+	if !r.CreatedAt.IsZero() {
+		p.CreatedAt = r.CreatedAt.Format(time.RFC1123)
+	}
+	a.publish(ctx, Event{
+		Type:      typ,
+		AppID:     r.AppID,
+		ChannelID: msg.ChannelID,
+		MessageID: msg.ID,
+		ParentID:  msg.ParentID,
+	}, p)
+}
+
+// publish encodes the payload of e and publishes it. Without a Bus, events
+// only reach the clients of this instance and are numbered by it. The same
+// goes for events that cannot be published on the Bus, so their ids may be
+// used again by the Bus.
+func (a *API) publish(ctx context.Context, e Event, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		a.Logger.Error("Could not encode event", "error", err.Error())
+		return
+	}
+	e.Data = data
+	if e.AppID == "" {
+		e.AppID = DefaultAppID
+	}
+	if a.Bus == nil {
+		a.events.publish(e)
+		return
+	}
+	// The event comes back to this instance through RelayEvents.
+	ctx, span := startEventSpan(ctx, a.TracerProvider, "Bus.Publish", trace.SpanKindProducer, e)
+	e.TraceContext = injectTrace(ctx)
+	err = a.Bus.Publish(ctx, e)
+	endSpan(span, err)
+	if err != nil {
+		a.Logger.Error("Could not publish event, delivering it locally only", "error", err.Error())
+		a.events.publish(e)
+	}
+}
+
+// RelayEvents delivers the events published on the Bus by any instance to
+// the realtime clients of this one until ctx is canceled. Every event is
+// relayed in a span of the trace it was published in. While the Bus is
+// unavailable, subscribing is retried every interval.
+func (a *API) RelayEvents(ctx context.Context, interval time.Duration) {
+	a.once.Do(a.setupRoutes)
+	if a.Bus == nil {
+		return
+	}
+	for {
+		err := a.Bus.Subscribe(ctx, func(e Event) {
+			_, span := startEventSpan(extractTrace(ctx, e.TraceContext), a.TracerProvider, "Bus.Receive", trace.SpanKindConsumer, e)
+			a.events.publish(e)
+			span.End()
+		})
+		if err == nil {
+			return
+		}
+		if ctx.Err() == nil {
+			a.Logger.Error("Could not subscribe to events", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
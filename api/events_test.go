@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neilotoole/slogt"
+)
+
+func TestHub(t *testing.T) {
+	h := newHub(3)
+	all := func(Event) bool { return true }
+	for _, typ := range []string{"a", "b", "c", "d"} {
+		h.publish(Event{Type: typ})
+	}
+
+	// Only the latest 3 events are kept.
+	sub, replay, err := h.subscribe(0, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(eventTypes(replay), []string{"b", "c", "d"}); diff != "" {
+		t.Errorf("Replayed events differ (-got +want)\n%s", diff)
+	}
+	h.unsubscribe(sub)
+
+	// Subscribers resume after the last event they received and only get
+	// the events they match.
+	sub, replay, err = h.subscribe(2, func(e Event) bool { return e.Type != "d" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(eventTypes(replay), []string{"c"}); diff != "" {
+		t.Errorf("Replayed events differ (-got +want)\n%s", diff)
+	}
+	h.publish(Event{Type: "d"})
+	h.publish(Event{Type: "e"})
+	if e := <-sub.events; e.Type != "e" || e.ID != 6 {
+		t.Errorf("Got event %+v, want event 6 of type e", e)
+	}
+
+	h.close()
+	if _, ok := <-sub.events; ok {
+		t.Error("Subscription is still open after closing the hub")
+	}
+	if _, _, err := h.subscribe(0, all); err == nil {
+		t.Error("Subscribed to a closed hub")
+	}
+	// Unsubscribing after the hub closed the subscription is a no-op.
+	h.unsubscribe(sub)
+}
+
+func TestHub_slowSubscriber(t *testing.T) {
+	h := newHub(0)
+	sub, _, err := h.subscribe(0, func(Event) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range subscriptionBuffer + 1 {
+		h.publish(Event{Type: "a"})
+	}
+
+	n := 0
+	for range sub.events {
+		n++
+	}
+	if n != subscriptionBuffer {
+		t.Errorf("Got %d events before the subscriber was dropped, want %d", n, subscriptionBuffer)
+	}
+}
+
+func TestAPI_RelayEvents(t *testing.T) {
+	bus := &testbus{}
+	api := &API{
+		Logger: slogt.New(t),
+		Bus:    bus,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	api.RelayEvents(ctx, time.Millisecond)
+	sub, _, err := api.events.subscribe(0, func(Event) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Events of this instance go through the bus, like those of the other
+	// instances.
+	api.publish(ctx, Event{Type: "local"}, nil)
+	bus.Publish(ctx, Event{Type: "remote"})
+	// Events that cannot be published are still delivered locally.
+	bus.setErr(errors.New("bus unavailable"))
+	api.publish(ctx, Event{Type: "fallback"}, nil)
+
+	var got []string
+	for range 3 {
+		got = append(got, (<-sub.events).Type)
+	}
+	if diff := cmp.Diff(got, []string{"local", "remote", "fallback"}); diff != "" {
+		t.Errorf("Received events differ (-got +want)\n%s", diff)
+	}
+}
+
+func TestAPI_RelayEvents_resume(t *testing.T) {
+	bus := &testbus{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	all := func(Event) bool { return true }
+	first := &API{Logger: slogt.New(t), Bus: bus}
+	first.RelayEvents(ctx, time.Millisecond)
+	first.publish(ctx, Event{Type: "a"}, nil)
+	first.publish(ctx, Event{Type: "b"}, nil)
+
+	// The second instance starts after the first events were published.
+	second := &API{Logger: slogt.New(t), Bus: bus}
+	second.RelayEvents(ctx, time.Millisecond)
+	first.publish(ctx, Event{Type: "c"}, nil)
+	second.publish(ctx, Event{Type: "d"}, nil)
+
+	// A client of the first instance received the events up to c, and
+	// resumes on the second one.
+	_, received, err := first.events.subscribe(0, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(eventTypes(received), []string{"a", "b", "c", "d"}); diff != "" {
+		t.Fatalf("Events of the first instance differ (-got +want)\n%s", diff)
+	}
+	_, replay, err := second.events.subscribe(received[2].ID, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(replay, received[3:]); diff != "" {
+		t.Errorf("Resumed events differ (-got +want)\n%s", diff)
+	}
+}
+
+// testbus is an EventBus shared with imaginary other instances. Publish and
+// Subscribe fail with err once it is set.
+type testbus struct {
+	mu     sync.Mutex
+	err    error
+	lastID uint64
+	subs   []func(Event)
+}
+
+func (b *testbus) setErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+func (b *testbus) Publish(ctx context.Context, e Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.lastID++
+	e.ID = b.lastID
+	for _, fn := range b.subs {
+		fn(e)
+	}
+	return nil
+}
+
+func (b *testbus) Subscribe(ctx context.Context, fn func(Event)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.subs = append(b.subs, fn)
+	return nil
+}
+
+func eventTypes(events []Event) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultPingTimeout is the time a dependency has to answer a readiness
+// check.
+const defaultPingTimeout = 2 * time.Second
+
+// A Pinger can check that the service it connects to is reachable. A DB or
+// Cache that does not implement it is assumed to always be available.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ping pings v if it is a Pinger.
+func ping(ctx context.Context, v any) error {
+	if p, ok := v.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// Drain makes the readiness check fail, so that no new traffic is routed to
+// the API while in-flight requests complete, and ends the event streams and
+// WebSocket connections so that their clients reconnect to another instance.
+// It is called when shutdown starts.
+func (a *API) Drain() {
+	a.once.Do(a.setupRoutes)
+	a.draining.Store(true)
+	a.events.close()
+}
+
+// healthz reports that the process is alive. It does not check any
+// dependencies, so that an unavailable DB does not get the process
+// restarted.
+func (a *API) healthz(w http.ResponseWriter, r *http.Request) {
+	a.respond(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether the API can serve traffic. The API is ready if all
+// dependencies are up, degraded if only the cache is down and not ready if
+// the DB is down or the API is shutting down.
+func (a *API) readyz(w http.ResponseWriter, r *http.Request) {
+	type check struct {
+		Status    string  `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+		Error     string  `json:"error,omitempty"`
+	}
+	type response struct {
+		Status string           `json:"status"`
+		Checks map[string]check `json:"checks,omitempty"`
+	}
+
+	if a.draining.Load() {
+		a.respond(w, http.StatusServiceUnavailable, response{Status: "shutting_down"})
+		return
+	}
+
+	timeout := a.PingTimeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	deps := map[string]any{"db": a.DB, "cache": a.Cache}
+	checks := make(map[string]check, len(deps))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, dep := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := ping(ctx, dep)
+			c := check{
+				Status:    "up",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				c.Status, c.Error = "down", err.Error()
+				a.Logger.Warn("Readiness check failed", "dependency", name, "error", c.Error)
+			}
+			mu.Lock()
+			checks[name] = c
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case checks["db"].Status != "up":
+		a.respond(w, http.StatusServiceUnavailable, response{Status: "not_ready", Checks: checks})
+	case checks["cache"].Status != "up":
+		// Requests are served from the DB alone.
+		a.respond(w, http.StatusOK, response{Status: "degraded", Checks: checks})
+	default:
+		a.respond(w, http.StatusOK, response{Status: "ready", Checks: checks})
+	}
+}
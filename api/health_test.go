@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+)
+
+// pingDB and pingCache add a Ping method to the test fakes.
+type (
+	pingDB struct {
+		*testdb
+		err error
+	}
+	pingCache struct {
+		*testcache
+		err error
+	}
+)
+
+func (db pingDB) Ping(context.Context) error   { return db.err }
+func (c pingCache) Ping(context.Context) error { return c.err }
+
+func TestAPI_readyz(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		dbErr      error
+		cacheErr   error
+		drain      bool
+		wantStatus int
+		want       string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "Ready",
+			wantStatus: http.StatusOK,
+			want:       "ready",
+			wantChecks: map[string]string{"db": "up", "cache": "up"},
+		},
+		{
+			name:       "CacheDown",
+			cacheErr:   down,
+			wantStatus: http.StatusOK,
+			want:       "degraded",
+			wantChecks: map[string]string{"db": "up", "cache": "down"},
+		},
+		{
+			name:       "DBDown",
+			dbErr:      down,
+			wantStatus: http.StatusServiceUnavailable,
+			want:       "not_ready",
+			wantChecks: map[string]string{"db": "down", "cache": "up"},
+		},
+		{
+			name:       "Draining",
+			drain:      true,
+			wantStatus: http.StatusServiceUnavailable,
+			want:       "shutting_down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{
+				Logger: slogt.New(t),
+				DB:     pingDB{testdb: &testdb{T: t}, err: tt.dbErr},
+				Cache:  &CacheBreaker{Cache: pingCache{testcache: &testcache{T: t}, err: tt.cacheErr}},
+			}
+			if tt.drain {
+				api.Drain()
+			}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/readyz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+
+			var body struct {
+				Status string `json:"status"`
+				Checks map[string]struct {
+					Status string `json:"status"`
+					Error  string `json:"error"`
+				} `json:"checks"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Status != tt.want {
+				t.Errorf("Got status %q, want %q", body.Status, tt.want)
+			}
+			if len(body.Checks) != len(tt.wantChecks) {
+				t.Errorf("Got %d checks, want %d", len(body.Checks), len(tt.wantChecks))
+			}
+			for name, want := range tt.wantChecks {
+				c := body.Checks[name]
+				if c.Status != want {
+					t.Errorf("Got %s status %q, want %q", name, c.Status, want)
+				}
+				if want == "down" && c.Error == "" {
+					t.Errorf("Check %s is down without an error", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAPI_healthz(t *testing.T) {
+	api := &API{
+		Logger: slogt.New(t),
+		DB:     pingDB{testdb: &testdb{T: t}, err: errors.New("connection refused")},
+	}
+	api.Drain()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// Liveness does not depend on the DB or on shutdown.
+	checkStatus(t, resp.StatusCode, http.StatusOK)
+}
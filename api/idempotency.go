@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// An IdempotentResponse is the response to the first request with an
+// Idempotency-Key, which is replayed to the retries of the request.
+type IdempotentResponse struct {
+	// Fingerprint identifies the request, so that a key cannot be reused
+	// for a different one.
+	Fingerprint string
+	// Done is false while the first request is in progress.
+	Done   bool
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// An IdempotencyStore stores the responses to requests by their
+// Idempotency-Key.
+type IdempotencyStore interface {
+	// Reserve claims key for the request with the given fingerprint until
+	// ttl passes. It returns false and the stored response, which may not be
+	// done yet, if the key is claimed already.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (IdempotentResponse, bool, error)
+	// Complete stores the response to the request that claimed key for ttl.
+	Complete(ctx context.Context, key string, resp IdempotentResponse, ttl time.Duration) error
+	// Release frees key, so that the request may be retried.
+	Release(ctx context.Context, key string) error
+}
+
+const (
+	// idempotencyKeyHeader is the header that holds the idempotency key of
+	// a request.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength is the length of the longest key accepted.
+	maxIdempotencyKeyLength = 255
+	// idempotencyLockTTL is how long a key stays claimed by a request that
+	// has not completed, in case its instance dies.
+	idempotencyLockTTL = time.Minute
+)
+
+// replayedHeaders are the headers stored with a response. Others, such as
+// those of the rate limiter, only describe the first request.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "X-Degraded"}
+
+// idempotent makes a request with an Idempotency-Key header safe to retry.
+// The first response to a key is stored for a.IdempotencyTTL and replayed
+// to the requests that repeat it. Requests that repeat a key while the first
+// one is in progress get 409, and requests that reuse a key with a different
+// method, route or body get 422. Server errors and rate limited requests are
+// not stored, so that the request can be retried. If the store fails,
+// requests are let through.
+func (a *API) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	if a.Idempotency == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get(idempotencyKeyHeader)
+		if idemKey == "" {
+			h(w, r)
+			return
+		}
+		if len(idemKey) > maxIdempotencyKeyLength {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("idempotency key of %d bytes", len(idemKey)), "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("read body: %w", err), "Could not read body")
+			return
+		}
+		if len(body) > maxBodySize {
+			// The handler rejects the body, so there is nothing to store.
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			h(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := a.idempotencyKey(r, idemKey)
+		fingerprint := requestFingerprint(r, body)
+		stored, ok, err := a.Idempotency.Reserve(r.Context(), key, fingerprint, idempotencyLockTTL)
+		if err != nil {
+			a.Logger.Error("Could not reserve idempotency key", "error", err.Error())
+			h(w, r)
+			return
+		}
+		if !ok {
+			a.replay(w, stored, fingerprint)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		// The response is stored even if the client is gone, since that is
+		// when it retries.
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := a.Idempotency.Release(ctx, key); err != nil {
+				a.Logger.Error("Could not release idempotency key", "error", err.Error())
+			}
+		}()
+		h(rec, r)
+
+		if rec.status() >= 500 || rec.status() == http.StatusTooManyRequests {
+			return
+		}
+		resp := IdempotentResponse{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      rec.status(),
+			Header:      make(http.Header),
+			Body:        rec.body.Bytes(),
+		}
+		for _, name := range replayedHeaders {
+			for _, v := range rec.Header().Values(name) {
+				resp.Header.Add(name, v)
+			}
+		}
+		if err := a.Idempotency.Complete(ctx, key, resp, a.idempotencyTTL()); err != nil {
+			a.Logger.Error("Could not store idempotent response", "error", err.Error())
+			return
+		}
+		completed = true
+	}
+}
+
+// replay responds to a request that repeats the idempotency key of stored.
+func (a *API) replay(w http.ResponseWriter, stored IdempotentResponse, fingerprint string) {
+	switch {
+	case stored.Fingerprint != fingerprint:
+		a.respondError(w, http.StatusUnprocessableEntity, errors.New("idempotency key reused for a different request"), "Idempotency-Key was used for a different request")
+	case !stored.Done:
+		w.Header().Set("Retry-After", "1")
+		a.respondError(w, http.StatusConflict, errors.New("idempotency key in use"), "A request with this Idempotency-Key is in progress")
+	default:
+		for name, v := range stored.Header {
+			w.Header()[name] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		if _, err := w.Write(stored.Body); err != nil {
+			a.Logger.Error("Could not write replayed response", "error", err.Error())
+		}
+	}
+}
+
+// idempotencyKey returns the key a response is stored by. Keys are chosen
+// by clients, so they are scoped to the app and, if the request is
+// authenticated, to the user.
+func (a *API) idempotencyKey(r *http.Request, key string) string {
+	scope := appID(r)
+	if userID, ok := UserFromContext(r.Context()); ok {
+		scope += " user:" + userID
+	}
+	return scope + " " + key
+}
+
+func (a *API) idempotencyTTL() time.Duration {
+	if a.IdempotencyTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return a.IdempotencyTTL
+}
+
+// requestFingerprint returns a hash of the method, path and body of r.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder writes a response through and keeps a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseRecorder) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
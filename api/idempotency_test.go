@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_idempotent(t *testing.T) {
+	const body = `{"text": "hello", "user_id": "alice"}`
+	fingerprint := requestFingerprint(httptest.NewRequest("POST", "/messages", nil), []byte(body))
+
+	tests := []struct {
+		name        string
+		key         string
+		body        string
+		stored      map[string]IdempotentResponse
+		storeErr    error
+		wantStatus  int
+		wantBody    string
+		wantInserts int
+	}{
+		{
+			name:        "NoKey",
+			body:        body,
+			wantStatus:  201,
+			wantInserts: 1,
+		},
+		{
+			name:        "NewKey",
+			key:         "key-1",
+			body:        body,
+			wantStatus:  201,
+			wantInserts: 1,
+		},
+		{
+			name: "Replay",
+			key:  "key-1",
+			body: body,
+			stored: map[string]IdempotentResponse{
+				DefaultAppID + " key-1": {
+					Fingerprint: fingerprint,
+					Done:        true,
+					Status:      201,
+					Header:      http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+					Body:        []byte(`{"id": "1"}`),
+				},
+			},
+			wantStatus: 201,
+			wantBody:   `{"id": "1"}`,
+		},
+		{
+			name: "InProgress",
+			key:  "key-1",
+			body: body,
+			stored: map[string]IdempotentResponse{
+				DefaultAppID + " key-1": {Fingerprint: fingerprint},
+			},
+			wantStatus: 409,
+			wantBody:   `{"error": "A request with this Idempotency-Key is in progress"}`,
+		},
+		{
+			name: "DifferentBody",
+			key:  "key-1",
+			body: `{"text": "bye", "user_id": "alice"}`,
+			stored: map[string]IdempotentResponse{
+				DefaultAppID + " key-1": {Fingerprint: fingerprint, Done: true, Status: 201},
+			},
+			wantStatus: 422,
+			wantBody:   `{"error": "Idempotency-Key was used for a different request"}`,
+		},
+		{
+			name:       "KeyTooLong",
+			key:        strings.Repeat("k", maxIdempotencyKeyLength+1),
+			body:       body,
+			wantStatus: 400,
+			wantBody:   `{"error": "Idempotency-Key is too long"}`,
+		},
+		{
+			name:        "StoreError",
+			key:         "key-1",
+			body:        body,
+			storeErr:    errors.New("redis down"),
+			wantStatus:  201,
+			wantInserts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserts int
+			api := &API{
+				DB: &testdb{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						inserts++
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Idempotency: &testidempotency{responses: tt.stored, err: tt.storeErr},
+				Logger:      slogt.New(t),
+			}
+			req := httptest.NewRequest("POST", "/messages", strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			resp := rec.Result()
+
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			if inserts != tt.wantInserts {
+				t.Errorf("Inserted %d messages, want %d", inserts, tt.wantInserts)
+			}
+		})
+	}
+}
+
+func TestAPI_idempotent_retry(t *testing.T) {
+	var (
+		inserts   int
+		insertErr = errors.New("something went wrong")
+	)
+	store := &testidempotency{}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				inserts++
+				if insertErr != nil {
+					return Message{}, insertErr
+				}
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Idempotency: store,
+		Logger:      slogt.New(t),
+	}
+	post := func() *http.Response {
+		req := httptest.NewRequest("POST", "/messages", strings.NewReader(`{"text": "hello", "user_id": "alice"}`))
+		req.Header.Set("Idempotency-Key", "key-1")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	// Server errors are not stored, so the request is retried.
+	checkStatus(t, post().StatusCode, 500)
+	if _, ok := store.responses[DefaultAppID+" key-1"]; ok {
+		t.Fatal("The key is still reserved after a server error")
+	}
+
+	insertErr = nil
+	first := post()
+	checkStatus(t, first.StatusCode, 201)
+	firstBody, _ := io.ReadAll(first.Body)
+
+	retry := post()
+	checkStatus(t, retry.StatusCode, 201)
+	retryBody, _ := io.ReadAll(retry.Body)
+	if string(retryBody) != string(firstBody) {
+		t.Errorf("Got replayed body %s, want %s", retryBody, firstBody)
+	}
+	for _, name := range []string{"Content-Type", "ETag"} {
+		if got, want := retry.Header.Get(name), first.Header.Get(name); got != want {
+			t.Errorf("Got replayed %s header %q, want %q", name, got, want)
+		}
+	}
+	if got := retry.Header.Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("Got Idempotent-Replayed header %q, want true", got)
+	}
+	if inserts != 2 {
+		t.Errorf("Inserted %d times, want 2", inserts)
+	}
+}
+
+func TestAPI_idempotent_rateLimit(t *testing.T) {
+	store := &testidempotency{}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Idempotency: store,
+		RateLimiter: &testlimiter{},
+		RateLimits: map[string]RateLimit{
+			"POST /messages": {Requests: 1, Period: time.Minute},
+		},
+		Logger: slogt.New(t),
+	}
+	post := func(key string) int {
+		req := httptest.NewRequest("POST", "/messages", strings.NewReader(`{"text": "hello", "user_id": "alice"}`))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Result().StatusCode
+	}
+
+	checkStatus(t, post("key-1"), 201)
+	// Retries do not count towards the limit.
+	checkStatus(t, post("key-1"), 201)
+	// Rate limited requests are not stored, so they can be retried later.
+	checkStatus(t, post("key-2"), 429)
+	if _, ok := store.responses[DefaultAppID+" key-2"]; ok {
+		t.Error("The rate limited response was stored")
+	}
+}
+
+// testidempotency is an IdempotencyStore of responses by key that never
+// expire. It fails with err if set.
+type testidempotency struct {
+	err error
+
+	mu        sync.Mutex
+	responses map[string]IdempotentResponse
+}
+
+func (s *testidempotency) Reserve(_ context.Context, key, fingerprint string, _ time.Duration) (IdempotentResponse, bool, error) {
+	if s.err != nil {
+		return IdempotentResponse{}, false, s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp, ok := s.responses[key]; ok {
+		return resp, false, nil
+	}
+	if s.responses == nil {
+		s.responses = make(map[string]IdempotentResponse)
+	}
+	s.responses[key] = IdempotentResponse{Fingerprint: fingerprint}
+	return IdempotentResponse{}, true, nil
+}
+
+func (s *testidempotency) Complete(_ context.Context, key string, resp IdempotentResponse, _ time.Duration) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[key] = resp
+	return nil
+}
+
+func (s *testidempotency) Release(_ context.Context, key string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, key)
+	return nil
+}
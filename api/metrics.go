@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors of the API. A nil *Metrics records
+// nothing.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	cache    *prometheus.CounterVec
+}
+
+// Cache lookup results, see Metrics.cacheLookup.
+const (
+	cacheHit   = "hit"   // the page was served from the cache
+	cacheMiss  = "miss"  // the page was served from the DB, at least in part
+	cacheError = "error" // the cache failed and the DB served the page
+)
+
+// NewMetrics creates the API collectors and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "message_cache_lookups_total",
+			Help: "Number of message pages looked up in the cache by result: hit, miss or error.",
+		}, []string{"result"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.cache)
+	return m
+}
+
+// instrument records the requests handled by h under the given route.
+func (m *Metrics) instrument(method, route string, h http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h(sw, r)
+		m.duration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, method, strconv.Itoa(sw.status())).Inc()
+	}
+}
+
+func (m *Metrics) cacheLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.cache.WithLabelValues(result).Inc()
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets WebSocket connections take over the underlying connection.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
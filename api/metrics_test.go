@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg)
+	api := &API{
+		Logger: slogt.New(t),
+		DB:     &testdb{T: t},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, _ string) ([]Message, error) {
+				return []Message{
+					{ID: "2", Text: "world", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+					{ID: "1", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+				}, nil
+			},
+		},
+		Metrics: metrics,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages?limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, http.StatusOK)
+
+	resp, err = http.Post(srv.URL+"/messages", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, http.StatusUnprocessableEntity)
+
+	for _, tt := range []struct {
+		name string
+		c    prometheus.Collector
+		want float64
+	}{
+		{"GET 200", metrics.requests.WithLabelValues("/messages", "GET", "200"), 1},
+		{"POST 422", metrics.requests.WithLabelValues("/messages", "POST", "422"), 1},
+		{"cache hit", metrics.cache.WithLabelValues(cacheHit), 1},
+		{"cache miss", metrics.cache.WithLabelValues(cacheMiss), 0},
+	} {
+		if got := testutil.ToFloat64(tt.c); got != tt.want {
+			t.Errorf("Got %s metric %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(metrics.duration); n != 2 {
+		t.Errorf("Got %d latency series, want 2", n)
+	}
+}
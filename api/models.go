@@ -2,20 +2,118 @@ package api
 
 import "time"
 
+// DefaultChannelID is the id of the channel that holds the messages of the
+// routes that are not scoped to a channel, such as GET /messages. It is
+// created by the database migrations and belongs to every app.
+const DefaultChannelID = "00000000-0000-0000-0000-000000000001"
+
+// DefaultAppID is the id of the app that holds the data of deployments
+// without API keys, and the data from before apps existed. It is created by
+// the database migrations. An empty app id stands for the default app.
+const DefaultAppID = "00000000-0000-0000-0000-000000000001"
+
+// An App is a product hosted on the deployment. Its data is isolated from
+// the data of the other apps.
+type App struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// A Channel is a conversation that holds messages.
+type Channel struct {
+	ID        string
+	AppID     string
+	Name      string
+	CreatedAt time.Time
+}
+
 // A Message represents a persisted message.
 type Message struct {
 	ID        string
+	AppID     string
+	ChannelID string
+	ParentID  string // set if the message is a reply in the thread of another message
 	Text      string
 	UserID    string
 	CreatedAt time.Time
+	UpdatedAt time.Time // zero if the message was never edited
+	Version   int       // starts at 1 and is incremented by every edit
+	DeletedAt time.Time // zero unless the message was deleted
+	// ShowInChannel lists a reply in its channel as well as in its thread.
+	ShowInChannel bool
+	// ReplyCount is the number of replies to the message that were not
+	// deleted, and LatestReplyAt the time of the latest reply.
+	ReplyCount    int
+	LatestReplyAt time.Time
+}
+
+// Deleted reports whether the message was deleted.
+func (m Message) Deleted() bool {
+	return !m.DeletedAt.IsZero()
+}
+
+// A MessageUpdate changes the text of a message.
+type MessageUpdate struct {
+	ID        string
+	AppID     string
+	Text      string
+	UserID    string    // must be the author of the message
+	UpdatedAt time.Time // the time of the edit
+	// IfVersion, if non-zero, is the version the message must have for the
+	// update to succeed.
+	IfVersion int
+}
+
+// A MessageDeletion soft deletes a message.
+type MessageDeletion struct {
+	ID        string
+	AppID     string
+	UserID    string    // must be the author of the message unless Moderator is set
+	Moderator bool      // whether the user may delete any message
+	DeletedAt time.Time // the time of the deletion
+}
+
+// A MessageVersion is a prior version of an edited message.
+type MessageVersion struct {
+	Version    int
+	Text       string
+	CreatedAt  time.Time // when the version was written
+	ReplacedAt time.Time // when the version was replaced by an edit
 }
 
 // A Reaction represents a reaction to a message such as a like.
 type Reaction struct {
 	ID        string
+	AppID     string
 	MessageID string
 	Type      string
 	Score     int
 	UserID    string
 	CreatedAt time.Time
 }
+
+// ReactionOptions controls how reactions are added by a DB.
+type ReactionOptions struct {
+	// MaxScore caps the score of the reaction of a user of one type to a
+	// message. Higher scores are lowered to the cap. Zero means no cap, but
+	// the API always sets one, see API.MaxReactionScore.
+	MaxScore int
+	// Exclusive limits every user to one reaction type per message. Adding
+	// a reaction removes the reactions of the user of other types.
+	Exclusive bool
+}
+
+// Cursor returns the position of the message in the list of messages.
+func (m Message) Cursor() Cursor {
+	return Cursor{CreatedAt: m.CreatedAt, ID: m.ID}
+}
+
+// A ReactionSummary aggregates the reactions to a message.
+type ReactionSummary struct {
+	Count      int            // total number of reactions
+	TotalScore int            // sum of all reaction scores
+	Counts     map[string]int // number of reactions per type
+	Scores     map[string]int // sum of reaction scores per type
+	Latest     []Reaction     // the latest reactions, newest first
+}
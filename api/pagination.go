@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// A Cursor identifies a position in the list of messages. Messages are
+// sorted by their creation time and id, newest first.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Before reports whether c is positioned before (is older than) o.
+func (c Cursor) Before(o Cursor) bool {
+	if c.CreatedAt.Equal(o.CreatedAt) {
+		return c.ID < o.ID
+	}
+	return c.CreatedAt.Before(o.CreatedAt)
+}
+
+// ListOptions controls which messages are returned by a DB.
+type ListOptions struct {
+	// AppID restricts the result to the messages of an app. Empty means the
+	// default app.
+	AppID string
+	// ChannelID restricts the result to the messages of a channel. Empty
+	// means all channels.
+	ChannelID string
+	// Limit is the maximum number of messages to return. Zero means no
+	// limit.
+	Limit int
+	// Before, if set, restricts the result to messages older than the
+	// cursor.
+	Before *Cursor
+	// After, if set, restricts the result to messages newer than the
+	// cursor. Combined with a Limit, the messages closest to the cursor are
+	// returned. The result is still sorted newest first.
+	After *Cursor
+	// IncludeDeleted includes deleted messages in the result.
+	IncludeDeleted bool
+	// ParentID restricts the result to the replies to a message. Empty
+	// means the messages of the channel, which leave out the replies that
+	// are not shown in the channel.
+	ParentID string
+}
+
+// A pageToken is the opaque cursor handed out to clients. It holds the
+// position to continue from and the direction to continue in.
+type pageToken struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Prev      bool      `json:"prev,omitempty"`
+}
+
+func encodePageToken(c Cursor, prev bool) string {
+	b, _ := json.Marshal(pageToken{CreatedAt: c.CreatedAt, ID: c.ID, Prev: prev})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageToken{}, fmt.Errorf("decode base64: %w", err)
+	}
+	var tok pageToken
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return pageToken{}, fmt.Errorf("decode json: %w", err)
+	}
+	if tok.ID == "" || tok.CreatedAt.IsZero() {
+		return pageToken{}, errors.New("incomplete cursor")
+	}
+	return tok, nil
+}
+
+// parseListOptions parses the limit, cursor and include_deleted query
+// parameters. Invalid parameters are reported to v.
+func parseListOptions(v *validator, q url.Values) ListOptions {
+	opts := ListOptions{Limit: defaultPageSize}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			v.add("limit", codeInvalidValue, "Limit must be a positive integer")
+		} else {
+			opts.Limit = min(limit, maxPageSize)
+		}
+	}
+	if s := q.Get("include_deleted"); s != "" {
+		include, err := strconv.ParseBool(s)
+		if err != nil {
+			v.add("include_deleted", codeInvalidValue, "Include deleted must be true or false")
+		}
+		opts.IncludeDeleted = include
+	}
+	if s := q.Get("cursor"); s != "" {
+		tok, err := decodePageToken(s)
+		if err != nil {
+			v.add("cursor", codeInvalidFormat, "Cursor is malformed")
+			return opts
+		}
+		c := &Cursor{CreatedAt: tok.CreatedAt, ID: tok.ID}
+		if tok.Prev {
+			opts.After = c
+		} else {
+			opts.Before = c
+		}
+	}
+	return opts
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A RateLimit allows Requests requests per Period. Requests that are not
+// made accumulate up to Requests, so clients may burst after being idle.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// A RateLimitResult is the state of a bucket after a request was counted.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests that may be made right away.
+	Remaining int
+	// RetryAfter is the time until the next request is allowed, if this one
+	// was not.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// A RateLimiter counts requests in a token bucket per key.
+type RateLimiter interface {
+	// Allow takes a request from the bucket of key, which holds up to
+	// limit.Requests requests and refills at limit.Requests per
+	// limit.Period. Denied requests are not counted.
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// A FallbackLimiter counts requests with Limiter, and with Fallback while
+// Limiter fails. Fallback is usually local to the instance, so the limits
+// apply per instance until Limiter recovers.
+type FallbackLimiter struct {
+	Limiter  RateLimiter
+	Fallback RateLimiter
+	Logger   *slog.Logger
+
+	failing atomic.Bool
+}
+
+// Allow takes a request from the bucket of key.
+func (l *FallbackLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	res, err := l.Limiter.Allow(ctx, key, limit)
+	if err == nil {
+		if l.failing.Swap(false) {
+			l.Logger.Info("Rate limiter recovered")
+		}
+		return res, nil
+	}
+	if !l.failing.Swap(true) {
+		l.Logger.Warn("Rate limiter failed, limiting per instance", "error", err.Error())
+	}
+	return l.Fallback.Allow(ctx, key, limit)
+}
+
+// rateLimit limits the requests to the route if a.RateLimits has a limit
+// for it. Every user and every IP address has a bucket of its own, and a
+// request has to fit into both. If the limiter fails, requests are let
+// through.
+func (a *API) rateLimit(pattern string, h http.HandlerFunc) http.HandlerFunc {
+	limit, ok := a.RateLimits[pattern]
+	if !ok || a.RateLimiter == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := a.allow(r.Context(), pattern, limit, a.rateLimitKeys(r))
+		if err != nil {
+			a.Logger.Error("Could not check rate limit", "error", err.Error())
+			h(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period)))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			a.respondError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit %s of %s exceeded", limit, pattern), "Too many requests")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// allow takes a request from the buckets of all keys for the route. It
+// returns the result of the bucket with the fewest remaining requests, and
+// denies the request if any bucket is empty.
+func (a *API) allow(ctx context.Context, pattern string, limit RateLimit, keys []string) (RateLimitResult, error) {
+	out := RateLimitResult{Allowed: true, Remaining: math.MaxInt}
+	for _, key := range keys {
+		res, err := a.RateLimiter.Allow(ctx, pattern+" "+key, limit)
+		if err != nil {
+			return RateLimitResult{}, fmt.Errorf("allow %s: %w", key, err)
+		}
+		out.Allowed = out.Allowed && res.Allowed
+		out.Remaining = min(out.Remaining, res.Remaining)
+		out.RetryAfter = max(out.RetryAfter, res.RetryAfter)
+		out.Reset = max(out.Reset, res.Reset)
+	}
+	return out, nil
+}
+
+// rateLimitKeys returns the keys of the buckets a request is counted in: the
+// one of its IP address and, if the request is authenticated, the one of
+// its user. Both belong to the app of the request. The user_id given by an
+// unauthenticated request is not used, since a client could change it to
+// get a new bucket.
+func (a *API) rateLimitKeys(r *http.Request) []string {
+	app := appID(r)
+	keys := []string{app + " ip:" + a.clientIP(r)}
+	if userID, ok := UserFromContext(r.Context()); ok {
+		keys = append(keys, app+" user:"+userID)
+	}
+	return keys
+}
+
+// clientIP returns the IP address of the client. With TrustForwardedFor, it
+// is the address the proxy in front of the API added to X-Forwarded-For.
+func (a *API) clientIP(r *http.Request) string {
+	if a.TrustForwardedFor {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			addrs := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ceilSeconds rounds d up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_rateLimit(t *testing.T) {
+	limits := map[string]RateLimit{
+		"POST /messages": {Requests: 2, Period: time.Minute},
+	}
+
+	tests := []struct {
+		name              string
+		path              string
+		requests          int
+		forwardedFor      string
+		trustForwardedFor bool
+		limiterErr        error
+		wantStatus        int
+		wantBody          string
+		wantHeaders       map[string]string
+		wantKeys          []string
+	}{
+		{
+			name:       "UnderLimit",
+			path:       "/messages",
+			requests:   2,
+			wantStatus: 201,
+			wantHeaders: map[string]string{
+				"RateLimit-Limit":     "2",
+				"RateLimit-Remaining": "0",
+				"RateLimit-Reset":     "60",
+				"RateLimit-Policy":    "2;w=60",
+			},
+			wantKeys: []string{"POST /messages " + DefaultAppID + " ip:192.0.2.1"},
+		},
+		{
+			name:       "OverLimit",
+			path:       "/messages",
+			requests:   3,
+			wantStatus: 429,
+			wantBody:   `{"error": "Too many requests"}`,
+			wantHeaders: map[string]string{
+				"RateLimit-Remaining": "0",
+				"Retry-After":         "30",
+			},
+			wantKeys: []string{"POST /messages " + DefaultAppID + " ip:192.0.2.1"},
+		},
+		{
+			name:        "NoLimit",
+			path:        "/channels/8d6b37a4-5c56-4d5f-9c1b-2c8f6a1e4b7d/messages",
+			requests:    3,
+			wantStatus:  201,
+			wantHeaders: map[string]string{"RateLimit-Limit": ""},
+		},
+		{
+			name:         "UntrustedForwardedFor",
+			path:         "/messages",
+			requests:     1,
+			forwardedFor: "198.51.100.7, 203.0.113.9",
+			wantStatus:   201,
+			wantKeys:     []string{"POST /messages " + DefaultAppID + " ip:192.0.2.1"},
+		},
+		{
+			name:              "TrustedForwardedFor",
+			path:              "/messages",
+			requests:          1,
+			forwardedFor:      "198.51.100.7, 203.0.113.9",
+			trustForwardedFor: true,
+			wantStatus:        201,
+			wantKeys:          []string{"POST /messages " + DefaultAppID + " ip:203.0.113.9"},
+		},
+		{
+			name:        "LimiterError",
+			path:        "/messages",
+			requests:    3,
+			limiterErr:  errors.New("something went wrong"),
+			wantStatus:  201,
+			wantHeaders: map[string]string{"RateLimit-Limit": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &testlimiter{err: tt.limiterErr}
+			api := &API{
+				DB: &testdb{
+					T: t,
+					getChannel: func(t *testing.T, id string) (Channel, error) {
+						return Channel{ID: id}, nil
+					},
+					insertMessage: func(t *testing.T, msg Message) (Message, error) {
+						return msg, nil
+					},
+				},
+				Cache: &testcache{
+					T: t,
+					insertMessage: func(t *testing.T, msg Message) error {
+						return nil
+					},
+				},
+				Logger:            slogt.New(t),
+				RateLimiter:       limiter,
+				RateLimits:        limits,
+				TrustForwardedFor: tt.trustForwardedFor,
+			}
+			var rec *httptest.ResponseRecorder
+			for range tt.requests {
+				req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"text": "hello", "user_id": "alice"}`))
+				if tt.forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+				}
+				rec = httptest.NewRecorder()
+				api.ServeHTTP(rec, req)
+			}
+			resp := rec.Result()
+
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			if tt.wantBody != "" {
+				checkBody(t, resp, tt.wantBody)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := resp.Header.Get(name); got != want {
+					t.Errorf("Got %s header %q, want %q", name, got, want)
+				}
+			}
+			if diff := cmp.Diff(limiter.keys(), tt.wantKeys); diff != "" {
+				t.Errorf("Buckets differ (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAPI_rateLimit_user(t *testing.T) {
+	limiter := &testlimiter{}
+	api := &API{
+		DB: &testdb{
+			T: t,
+			getChannel: func(t *testing.T, id string) (Channel, error) {
+				return Channel{ID: id}, nil
+			},
+		},
+		Logger:      slogt.New(t),
+		RateLimiter: limiter,
+		RateLimits: map[string]RateLimit{
+			"GET /channels/{channelID}": {Requests: 1, Period: time.Second},
+		},
+		Auth: &Auth{HMACSecret: []byte("secret")},
+	}
+	req := httptest.NewRequest("GET", "/channels/"+DefaultChannelID, nil)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []string{
+		"GET /channels/{channelID} " + DefaultAppID + " ip:192.0.2.1",
+		"GET /channels/{channelID} " + DefaultAppID + " user:alice",
+	}
+	if diff := cmp.Diff(limiter.keys(), want); diff != "" {
+		t.Errorf("Buckets differ (-got +want)\n%s", diff)
+	}
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	primary := &testlimiter{err: errors.New("redis down")}
+	fallback := &testlimiter{}
+	l := &FallbackLimiter{Limiter: primary, Fallback: fallback, Logger: slogt.New(t)}
+	limit := RateLimit{Requests: 1, Period: time.Minute}
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		res, err := l.Allow(ctx, "alice", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed != want {
+			t.Errorf("Request %d: got allowed %t from the fallback, want %t", i+1, res.Allowed, want)
+		}
+	}
+
+	// Once the limiter recovers, it counts again.
+	primary.err = nil
+	if res, err := l.Allow(ctx, "alice", limit); err != nil || !res.Allowed {
+		t.Errorf("Got %+v, %v, want the request allowed by the recovered limiter", res, err)
+	}
+	if len(primary.keys()) != 1 {
+		t.Errorf("The recovered limiter counted %d buckets, want 1", len(primary.keys()))
+	}
+}
+
+// testlimiter allows limit.Requests requests per key and never refills. It
+// fails with err if set.
+type testlimiter struct {
+	err error
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (l *testlimiter) Allow(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if l.err != nil {
+		return RateLimitResult{}, l.err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	interval := limit.Period / time.Duration(limit.Requests)
+	n := l.counts[key]
+	if n >= limit.Requests {
+		return RateLimitResult{RetryAfter: interval, Reset: limit.Period}, nil
+	}
+	l.counts[key] = n + 1
+	return RateLimitResult{
+		Allowed:   true,
+		Remaining: limit.Requests - n - 1,
+		Reset:     time.Duration(n+1) * interval,
+	}, nil
+}
+
+// keys returns the keys of the buckets, sorted.
+func (l *testlimiter) keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var keys []string
+	for k := range l.counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
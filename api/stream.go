@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultHeartbeatInterval is the time between heartbeats on an idle stream.
+const defaultHeartbeatInterval = 15 * time.Second
+
+// streamMessages pushes the events of a channel to the client as Server-Sent
+// Events until the client disconnects or the server shuts down. Clients that
+// reconnect with a Last-Event-ID header receive the events they missed, as
+// long as they are still buffered.
+func (a *API) streamMessages(w http.ResponseWriter, r *http.Request) {
+	channelID, ok := a.channelID(w, r)
+	if !ok {
+		return
+	}
+	if channelID != DefaultChannelID {
+		_, err := a.DB.GetChannel(r.Context(), appID(r), channelID)
+		if errors.Is(err, ErrNotFound) {
+			a.respondError(w, http.StatusNotFound, err, "Channel not found")
+			return
+		}
+		if err != nil {
+			a.respondDBError(w, err, "Could not stream messages")
+			return
+		}
+	}
+	var lastID uint64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			a.respondError(w, http.StatusBadRequest, fmt.Errorf("parse Last-Event-ID: %w", err), "Invalid Last-Event-ID header")
+			return
+		}
+		lastID = id
+	}
+
+	app := appID(r)
+	sub, replay, err := a.events.subscribe(lastID, func(e Event) bool {
+		return e.AppID == app && e.ChannelID == channelID
+	})
+	if err != nil {
+		a.respondError(w, http.StatusServiceUnavailable, err, "Server is shutting down")
+		return
+	}
+	defer a.events.unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range replay {
+		writeEvent(w, e)
+	}
+	if err := rc.Flush(); err != nil {
+		a.Logger.Error("Could not stream messages", "error", err.Error())
+		return
+	}
+
+	interval := a.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.events:
+			if !ok {
+				// The client fell behind or the server is shutting down.
+				// Either way it reconnects and resumes from the last event.
+				return
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes e in the Server-Sent Events format. The payload is
+// compact JSON, so it fits on a single data line.
+func writeEvent(w io.Writer, e Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
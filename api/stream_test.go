@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_streamMessages(t *testing.T) {
+	api := &API{
+		DB: &testdb{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return msg, nil
+			},
+			deleteReaction: func(t *testing.T, msgID, userID, reactionType string) (int, error) {
+				return 1, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger:            slogt.New(t),
+		HeartbeatInterval: 10 * time.Millisecond,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// An event published before the client connects is replayed when the
+	// client resumes from an earlier event.
+	post(t, srv.URL+"/messages", `{"text": "first", "user_id": "test"}`)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/messages/stream", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkStatus(t, resp.StatusCode, 200)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Got Content-Type %q, want text/event-stream", ct)
+	}
+	events := readEvents(t, bufio.NewReader(resp.Body))
+
+	e := <-events
+	if e.id != "1" || e.event != EventMessageCreated || e.data["text"] != "first" {
+		t.Errorf("Got %+v, want the first message", e)
+	}
+
+	post(t, srv.URL+"/messages", `{"text": "second", "user_id": "test"}`)
+	e = <-events
+	if e.id != "2" || e.event != EventMessageCreated || e.data["text"] != "second" {
+		t.Errorf("Got %+v, want the second message", e)
+	}
+
+	req, _ = http.NewRequest("DELETE", srv.URL+"/messages/fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a/reactions/like?user_id=test", nil)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	e = <-events
+	if e.event != EventReactionDeleted || e.data["type"] != "like" {
+		t.Errorf("Got %+v, want a deleted reaction", e)
+	}
+
+	// Draining ends the stream.
+	api.Drain()
+	for e := range events {
+		t.Errorf("Got %+v after draining", e)
+	}
+}
+
+func TestAPI_streamMessages_invalid(t *testing.T) {
+	api := &API{
+		DB:     &testdb{T: t},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/messages/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 400)
+	checkBody(t, resp, `{"error": "Invalid Last-Event-ID header"}`)
+
+	api.Drain()
+	resp, err = http.Get(srv.URL + "/messages/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 503)
+}
+
+type sseEvent struct {
+	id, event string
+	data      map[string]any
+}
+
+// readEvents parses the events of an event stream until it ends. Heartbeats
+// are skipped.
+func readEvents(t *testing.T, r *bufio.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		var e sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if e.event != "" {
+					events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.data); err != nil {
+					t.Errorf("Could not decode event data: %v", err)
+				}
+			}
+		}
+	}()
+	return events
+}
+
+func post(t *testing.T, url, body string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("POST %s: got HTTP status %d", url, resp.StatusCode)
+	}
+}
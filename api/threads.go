@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// createReply posts a reply in the thread of a message. Threads are one
+// level deep, so replies cannot be replied to.
+func (a *API) createReply(w http.ResponseWriter, r *http.Request) {
+	type (
+		request struct {
+			Text          string `json:"text"`
+			UserID        string `json:"user_id"`
+			ShowInChannel bool   `json:"show_in_channel"`
+		}
+		response struct {
+			ID            string `json:"id"`
+			ParentID      string `json:"parent_id"`
+			Text          string `json:"text"`
+			UserID        string `json:"user_id"`
+			CreatedAt     string `json:"created_at"`
+			ShowInChannel bool   `json:"show_in_channel"`
+			Version       int    `json:"version"`
+		}
+	)
+
+	parentID := r.PathValue("messageID")
+	if !validID(parentID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", parentID), "Message not found")
+		return
+	}
+	var body request
+	if !a.decodeBody(w, r, &body) {
+		return
+	}
+	userID, ok := a.actingUser(w, r, "user_id", body.UserID)
+	if !ok {
+		return
+	}
+	body.UserID = userID
+	var v validator
+	v.text("text", body.Text)
+	v.userID("user_id", body.UserID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+
+	parent, err := a.DB.GetMessage(r.Context(), appID(r), parentID)
+	if err == nil && parent.Deleted() {
+		err = fmt.Errorf("message %s was deleted: %w", parentID, ErrNotFound)
+	}
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not insert reply")
+		return
+	}
+	if parent.ParentID != "" {
+		err := fmt.Errorf("message %s is a reply to %s", parent.ID, parent.ParentID)
+		a.respondError(w, http.StatusUnprocessableEntity, err, "Replies cannot be replied to")
+		return
+	}
+
+	msg, err := a.DB.InsertMessage(r.Context(), Message{
+		ID:            newID(),
+		AppID:         appID(r),
+		ChannelID:     parent.ChannelID,
+		ParentID:      parent.ID,
+		Text:          body.Text,
+		UserID:        body.UserID,
+		CreatedAt:     now(),
+		Version:       1,
+		ShowInChannel: body.ShowInChannel,
+	})
+	if errors.Is(err, ErrNotFound) {
+		// The parent was deleted in the meantime.
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not insert reply")
+		return
+	}
+
+	// The cache holds the messages listed in the channel only.
+	if msg.ShowInChannel {
+		if err := a.Cache.InsertMessage(r.Context(), msg); err != nil {
+			a.Logger.Error("Could not cache message", "error", err.Error())
+		}
+	}
+	a.refreshCachedParent(r.Context(), appID(r), msg.ParentID)
+	a.publishMessage(r.Context(), EventMessageCreated, msg)
+
+	w.Header().Set("ETag", etag(msg.Version))
+	a.respond(w, http.StatusCreated, response{
+		ID:            msg.ID,
+		ParentID:      msg.ParentID,
+		Text:          msg.Text,
+		UserID:        msg.UserID,
+		CreatedAt:     msg.CreatedAt.Format(time.RFC1123),
+		ShowInChannel: msg.ShowInChannel,
+		Version:       msg.Version,
+	})
+}
+
+// threadParent returns the message whose replies are requested. Deleted
+// messages keep their threads, so they are returned as well. If the message
+// does not exist, a response is written and ok is false.
+func (a *API) threadParent(w http.ResponseWriter, r *http.Request) (msg Message, ok bool) {
+	parentID := r.PathValue("messageID")
+	if !validID(parentID) {
+		a.respondError(w, http.StatusNotFound, fmt.Errorf("invalid message id %q", parentID), "Message not found")
+		return Message{}, false
+	}
+	msg, err := a.DB.GetMessage(r.Context(), appID(r), parentID)
+	if errors.Is(err, ErrNotFound) {
+		a.respondError(w, http.StatusNotFound, err, "Message not found")
+		return Message{}, false
+	}
+	if err != nil {
+		a.respondDBError(w, err, "Could not list replies")
+		return Message{}, false
+	}
+	return msg, true
+}
+
+// refreshCachedParent replaces the cached copy of a thread parent after its
+// reply count changed. Failures are logged, since the DB holds the count.
+func (a *API) refreshCachedParent(ctx context.Context, appID, parentID string) {
+	parent, err := a.DB.GetMessage(ctx, appID, parentID)
+	if err != nil {
+		a.Logger.Error("Could not get thread parent", "error", err.Error())
+		return
+	}
+	if err := a.Cache.UpdateMessage(ctx, parent); err != nil {
+		a.Logger.Error("Could not update cached thread parent", "error", err.Error())
+	}
+}
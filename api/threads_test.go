@@ -0,0 +1,378 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_createReply(t *testing.T) {
+	const parentID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	parent := Message{
+		ID:        parentID,
+		ChannelID: DefaultChannelID,
+		Text:      "hello",
+		UserID:    "test",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:   1,
+	}
+	getParent := func(t *testing.T, msgID string) (Message, error) {
+		if msgID != parentID {
+			t.Errorf("Got message id %q, want %q", msgID, parentID)
+		}
+		return parent, nil
+	}
+	tests := []struct {
+		name       string
+		messageID  string
+		req        string
+		db         *testdb
+		cache      *testcache
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "InvalidMessageID",
+			messageID:  "12345",
+			req:        `{"text": "hi", "user_id": "test"}`,
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:       "MissingFields",
+			messageID:  parentID,
+			req:        `{}`,
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Invalid request",
+				"fields": [
+					{"field": "text", "code": "required", "message": "Text must not be empty"},
+					{"field": "user_id", "code": "required", "message": "User ID must not be empty"}
+				]
+			}`,
+		},
+		{
+			name:      "NotFound",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test"}`,
+			db: &testdb{
+				getMessage: func(t *testing.T, msgID string) (Message, error) {
+					return Message{}, fmt.Errorf("message %s: %w", msgID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:      "DeletedParent",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test"}`,
+			db: &testdb{
+				getMessage: func(t *testing.T, msgID string) (Message, error) {
+					deleted := parent
+					deleted.DeletedAt = parent.CreatedAt.Add(time.Minute)
+					return deleted, nil
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name:      "ReplyToReply",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test"}`,
+			db: &testdb{
+				getMessage: func(t *testing.T, msgID string) (Message, error) {
+					reply := parent
+					reply.ParentID = "5b1d3c4e-8f2a-4b6c-9d0e-1f2a3b4c5d6e"
+					return reply, nil
+				},
+			},
+			wantStatus: 422,
+			wantBody: `{
+				"error": "Replies cannot be replied to"
+			}`,
+		},
+		{
+			name:      "DBError",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test"}`,
+			db: &testdb{
+				getMessage: getParent,
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					return Message{}, errors.New("something went wrong")
+				},
+			},
+			wantStatus: 500,
+			wantBody: `{
+				"error": "Could not insert reply"
+			}`,
+		},
+		{
+			name:      "OK",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test"}`,
+			db: &testdb{
+				getMessage: getParent,
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					want := Message{AppID: DefaultAppID, ChannelID: DefaultChannelID, ParentID: parentID, Text: "hi", UserID: "test", Version: 1}
+					if diff := cmp.Diff(msg, want, cmpopts.IgnoreFields(Message{}, "ID", "CreatedAt")); diff != "" {
+						t.Errorf("Reply differs (-got +want)\n%s", diff)
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				updateMessage: func(t *testing.T, msg Message) error {
+					if msg.ID != parentID {
+						t.Errorf("Got cached message %q, want the parent", msg.ID)
+					}
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"parent_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"text": "hi",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:01:00 UTC",
+				"show_in_channel": false,
+				"version": 1
+			}`,
+		},
+		{
+			name:      "ShowInChannel",
+			messageID: parentID,
+			req:       `{"text": "hi", "user_id": "test", "show_in_channel": true}`,
+			db: &testdb{
+				getMessage: getParent,
+				insertMessage: func(t *testing.T, msg Message) (Message, error) {
+					if !msg.ShowInChannel {
+						t.Error("Reply is not shown in the channel")
+					}
+					msg.ID = "1"
+					msg.CreatedAt = time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)
+					return msg, nil
+				},
+			},
+			cache: &testcache{
+				insertMessage: func(t *testing.T, msg Message) error {
+					if msg.ID != "1" {
+						t.Errorf("Got cached message %q, want the reply", msg.ID)
+					}
+					return nil
+				},
+				updateMessage: func(t *testing.T, msg Message) error {
+					return nil
+				},
+			},
+			wantStatus: 201,
+			wantBody: `{
+				"id": "1",
+				"parent_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				"text": "hi",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:01:00 UTC",
+				"show_in_channel": true,
+				"version": 1
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db == nil {
+				tt.db = &testdb{}
+			}
+			tt.db.T = t
+			if tt.cache == nil {
+				tt.cache = &testcache{}
+			}
+			tt.cache.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  tt.cache,
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			url := srv.URL + "/messages/" + tt.messageID + "/replies"
+			resp, err := http.Post(url, "application/json", strings.NewReader(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listReplies(t *testing.T) {
+	const parentID = "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a"
+	tests := []struct {
+		name       string
+		path       string
+		db         *testdb
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "NotFound",
+			path: "/messages/" + parentID + "/replies",
+			db: &testdb{
+				getMessage: func(t *testing.T, msgID string) (Message, error) {
+					return Message{}, fmt.Errorf("message %s: %w", msgID, ErrNotFound)
+				},
+			},
+			wantStatus: 404,
+			wantBody: `{
+				"error": "Message not found"
+			}`,
+		},
+		{
+			name: "OK",
+			path: "/messages/" + parentID + "/replies?limit=1",
+			db: &testdb{
+				getMessage: func(t *testing.T, msgID string) (Message, error) {
+					return Message{ID: msgID, ChannelID: DefaultChannelID}, nil
+				},
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					if opts.ParentID != parentID || opts.ChannelID != DefaultChannelID {
+						t.Errorf("Got ParentID %q and ChannelID %q", opts.ParentID, opts.ChannelID)
+					}
+					reply := func(id string, minute int) Message {
+						return Message{
+							ID:        id,
+							ChannelID: DefaultChannelID,
+							ParentID:  parentID,
+							Text:      "hi",
+							UserID:    "test",
+							CreatedAt: time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC),
+							Version:   1,
+						}
+					}
+					return []Message{reply("2", 2), reply("1", 1)}, nil
+				},
+				reactionSummaries: func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+					return nil, nil
+				},
+			},
+			wantStatus: 200,
+			wantBody: `{
+				"messages": [
+					{
+						"id": "2",
+						"text": "hi",
+						"user_id": "test",
+						"created_at": "Mon, 01 Jan 2024 00:02:00 UTC",
+						"version": 1,
+						"parent_id": "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+						"reaction_count": 0,
+						"total_score": 0,
+						"reaction_counts": {},
+						"reaction_scores": {},
+						"latest_reactions": []
+					}
+				],
+				"next": "` + encodePageToken(Cursor{CreatedAt: time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC), ID: "2"}, false) + `"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.T = t
+			api := &API{
+				DB:     tt.db,
+				Cache:  &testcache{T: t},
+				Logger: slogt.New(t),
+			}
+
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStatus(t, resp.StatusCode, tt.wantStatus)
+			checkBody(t, resp, tt.wantBody)
+		})
+	}
+}
+
+func TestAPI_listMessages_replyCount(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	api := &API{
+		DB: &testdb{
+			T: t,
+			listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+				return nil, nil
+			},
+			reactionSummaries: func(t *testing.T, msgIDs []string, latest int) (map[string]ReactionSummary, error) {
+				return nil, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			listMessages: func(t *testing.T, _ string) ([]Message, error) {
+				return []Message{{
+					ID:            "1",
+					Text:          "hello",
+					UserID:        "test",
+					CreatedAt:     created,
+					Version:       1,
+					ReplyCount:    2,
+					LatestReplyAt: created.Add(time.Hour),
+				}}, nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, resp.StatusCode, 200)
+	checkBody(t, resp, `{
+		"messages": [
+			{
+				"id": "1",
+				"text": "hello",
+				"user_id": "test",
+				"created_at": "Mon, 01 Jan 2024 00:00:00 UTC",
+				"version": 1,
+				"reply_count": 2,
+				"latest_reply_at": "Mon, 01 Jan 2024 01:00:00 UTC",
+				"reaction_count": 0,
+				"total_score": 0,
+				"reaction_counts": {},
+				"reaction_scores": {},
+				"latest_reactions": []
+			}
+		]
+	}`)
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/GetStream/stream-backend-homework-assignment/api"
+
+// propagator reads and writes W3C trace context headers.
+var propagator = propagation.TraceContext{}
+
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startRequestSpan starts the server span of a request. The span continues
+// the trace of the client if the request has a traceparent header. The span
+// is named after the route once it is matched.
+func (a *API) startRequestSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer(a.TracerProvider).Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan records the response status on the span and ends it.
+func endRequestSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// traceRoute names the request span after the route that matched.
+func traceRoute(method, route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+		h(w, r)
+	}
+}
+
+// startSpan starts a client span for a call to a dependency.
+func startSpan(ctx context.Context, tp trace.TracerProvider, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer(tp).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// startEventSpan starts a span of the given kind for passing e over the
+// event bus.
+func startEventSpan(ctx context.Context, tp trace.TracerProvider, name string, kind trace.SpanKind, e Event) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("event.type", e.Type)}
+	if e.ID != 0 {
+		attrs = append(attrs, attribute.Int64("event.id", int64(e.ID)))
+	}
+	return tracer(tp).Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// injectTrace returns the trace context of ctx for an event to carry, or nil
+// if ctx is not traced.
+func injectTrace(ctx context.Context) map[string]string {
+	carrier := make(propagation.MapCarrier)
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// extractTrace returns ctx with the trace context carried by an event.
+func extractTrace(ctx context.Context, traceContext map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(traceContext))
+}
+
+// endSpan records err on the span and ends it. Missing resources are an
+// expected outcome rather than a failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// A TracedDB wraps a DB and records a span for every call.
+type TracedDB struct {
+	DB             DB
+	TracerProvider trace.TracerProvider // defaults to the global provider
+}
+
+// InsertChannel calls DB.InsertChannel in a span.
+func (t *TracedDB) InsertChannel(ctx context.Context, channel Channel) (_ Channel, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.InsertChannel")
+	defer func() { endSpan(span, err) }()
+	return t.DB.InsertChannel(ctx, channel)
+}
+
+// GetChannel calls DB.GetChannel in a span.
+func (t *TracedDB) GetChannel(ctx context.Context, appID, channelID string) (_ Channel, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.GetChannel",
+		attribute.String("app.id", appID),
+		attribute.String("channel.id", channelID),
+	)
+	defer func() { endSpan(span, err) }()
+	return t.DB.GetChannel(ctx, appID, channelID)
+}
+
+// ListMessages calls DB.ListMessages in a span.
+func (t *TracedDB) ListMessages(ctx context.Context, opts ListOptions, excludeMsgIDs ...string) (msgs []Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.ListMessages",
+		attribute.String("app.id", opts.AppID),
+		attribute.String("channel.id", opts.ChannelID),
+		attribute.Int("list.limit", opts.Limit),
+		attribute.Int("list.excluded", len(excludeMsgIDs)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("list.count", len(msgs)))
+		endSpan(span, err)
+	}()
+	return t.DB.ListMessages(ctx, opts, excludeMsgIDs...)
+}
+
+// GetMessage calls DB.GetMessage in a span.
+func (t *TracedDB) GetMessage(ctx context.Context, appID, msgID string) (_ Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.GetMessage",
+		attribute.String("app.id", appID),
+		attribute.String("message.id", msgID),
+	)
+	defer func() { endSpan(span, err) }()
+	return t.DB.GetMessage(ctx, appID, msgID)
+}
+
+// InsertMessage calls DB.InsertMessage in a span.
+func (t *TracedDB) InsertMessage(ctx context.Context, msg Message) (_ Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.InsertMessage", attribute.String("message.id", msg.ID))
+	defer func() { endSpan(span, err) }()
+	return t.DB.InsertMessage(ctx, msg)
+}
+
+// UpdateMessage calls DB.UpdateMessage in a span.
+func (t *TracedDB) UpdateMessage(ctx context.Context, upd MessageUpdate) (_ Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.UpdateMessage", attribute.String("message.id", upd.ID))
+	defer func() { endSpan(span, err) }()
+	return t.DB.UpdateMessage(ctx, upd)
+}
+
+// MessageHistory calls DB.MessageHistory in a span.
+func (t *TracedDB) MessageHistory(ctx context.Context, appID, msgID string) (_ []MessageVersion, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.MessageHistory",
+		attribute.String("app.id", appID),
+		attribute.String("message.id", msgID),
+	)
+	defer func() { endSpan(span, err) }()
+	return t.DB.MessageHistory(ctx, appID, msgID)
+}
+
+// DeleteMessage calls DB.DeleteMessage in a span.
+func (t *TracedDB) DeleteMessage(ctx context.Context, del MessageDeletion) (_ Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.DeleteMessage", attribute.String("message.id", del.ID))
+	defer func() { endSpan(span, err) }()
+	return t.DB.DeleteMessage(ctx, del)
+}
+
+// PurgeMessages calls DB.PurgeMessages in a span.
+func (t *TracedDB) PurgeMessages(ctx context.Context, deletedBefore time.Time) (n int, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.PurgeMessages")
+	defer func() {
+		span.SetAttributes(attribute.Int("message.count", n))
+		endSpan(span, err)
+	}()
+	return t.DB.PurgeMessages(ctx, deletedBefore)
+}
+
+// InsertReaction calls DB.InsertReaction in a span.
+func (t *TracedDB) InsertReaction(ctx context.Context, reaction Reaction, opts ReactionOptions) (_ Reaction, created bool, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.InsertReaction", attribute.String("message.id", reaction.MessageID))
+	defer func() {
+		span.SetAttributes(attribute.Bool("reaction.created", created))
+		endSpan(span, err)
+	}()
+	return t.DB.InsertReaction(ctx, reaction, opts)
+}
+
+// SetReaction calls DB.SetReaction in a span.
+func (t *TracedDB) SetReaction(ctx context.Context, reaction Reaction, opts ReactionOptions) (_ Reaction, created bool, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.SetReaction", attribute.String("message.id", reaction.MessageID))
+	defer func() {
+		span.SetAttributes(attribute.Bool("reaction.created", created))
+		endSpan(span, err)
+	}()
+	return t.DB.SetReaction(ctx, reaction, opts)
+}
+
+// DeleteReaction calls DB.DeleteReaction in a span.
+func (t *TracedDB) DeleteReaction(ctx context.Context, appID, msgID, userID, reactionType string) (n int, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.DeleteReaction",
+		attribute.String("app.id", appID),
+		attribute.String("message.id", msgID),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("reaction.count", n))
+		endSpan(span, err)
+	}()
+	return t.DB.DeleteReaction(ctx, appID, msgID, userID, reactionType)
+}
+
+// ReactionSummaries calls DB.ReactionSummaries in a span.
+func (t *TracedDB) ReactionSummaries(ctx context.Context, appID string, msgIDs []string, latest int) (_ map[string]ReactionSummary, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.ReactionSummaries",
+		attribute.String("app.id", appID),
+		attribute.Int("message.count", len(msgIDs)),
+	)
+	defer func() { endSpan(span, err) }()
+	return t.DB.ReactionSummaries(ctx, appID, msgIDs, latest)
+}
+
+// Ping pings the DB, if it is a Pinger, in a span.
+func (t *TracedDB) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "DB.Ping")
+	defer func() { endSpan(span, err) }()
+	return ping(ctx, t.DB)
+}
+
+// A TracedCache wraps a Cache and records a span for every call.
+type TracedCache struct {
+	Cache          Cache
+	TracerProvider trace.TracerProvider // defaults to the global provider
+}
+
+// ListMessages calls Cache.ListMessages in a span.
+func (t *TracedCache) ListMessages(ctx context.Context, appID, channelID string) (msgs []Message, err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "Cache.ListMessages",
+		attribute.String("app.id", appID),
+		attribute.String("channel.id", channelID),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("list.count", len(msgs)))
+		endSpan(span, err)
+	}()
+	return t.Cache.ListMessages(ctx, appID, channelID)
+}
+
+// InsertMessage calls Cache.InsertMessage in a span.
+func (t *TracedCache) InsertMessage(ctx context.Context, msg Message) (err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "Cache.InsertMessage", attribute.String("message.id", msg.ID))
+	defer func() { endSpan(span, err) }()
+	return t.Cache.InsertMessage(ctx, msg)
+}
+
+// UpdateMessage calls Cache.UpdateMessage in a span.
+func (t *TracedCache) UpdateMessage(ctx context.Context, msg Message) (err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "Cache.UpdateMessage", attribute.String("message.id", msg.ID))
+	defer func() { endSpan(span, err) }()
+	return t.Cache.UpdateMessage(ctx, msg)
+}
+
+// Clear calls Cache.Clear in a span.
+func (t *TracedCache) Clear(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "Cache.Clear")
+	defer func() { endSpan(span, err) }()
+	return t.Cache.Clear(ctx)
+}
+
+// Ping pings the cache, if it is a Pinger, in a span.
+func (t *TracedCache) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, t.TracerProvider, "Cache.Ping")
+	defer func() { endSpan(span, err) }()
+	return ping(ctx, t.Cache)
+}
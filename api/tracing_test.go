@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neilotoole/slogt"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAPI_tracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	api := &API{
+		Logger: slogt.New(t),
+		DB: &TracedDB{
+			DB: &testdb{
+				T: t,
+				listMessages: func(t *testing.T, opts ListOptions, excludeMsgIDs ...string) ([]Message, error) {
+					return nil, errors.New("connection lost")
+				},
+			},
+			TracerProvider: tp,
+		},
+		Cache: &TracedCache{
+			Cache: &testcache{
+				T: t,
+				listMessages: func(t *testing.T, _ string) ([]Message, error) {
+					return nil, nil
+				},
+			},
+			TracerProvider: tp,
+		},
+		TracerProvider: tp,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/messages", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+parentID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkStatus(t, resp.StatusCode, http.StatusInternalServerError)
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	// Child spans end before their parent.
+	cacheSpan, dbSpan, reqSpan := spans[0], spans[1], spans[2]
+
+	if got, want := reqSpan.Name(), "GET /messages"; got != want {
+		t.Errorf("Got request span %q, want %q", got, want)
+	}
+	if got := reqSpan.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("Request span is in trace %s, want %s", got, traceID)
+	}
+	if got := reqSpan.Parent().SpanID().String(); got != parentID {
+		t.Errorf("Request span has parent %s, want %s", got, parentID)
+	}
+	if reqSpan.SpanKind() != trace.SpanKindServer || reqSpan.Status().Code != codes.Error {
+		t.Errorf("Request span has kind %v and status %v, want a failed server span", reqSpan.SpanKind(), reqSpan.Status())
+	}
+
+	for _, tt := range []struct {
+		span       sdktrace.ReadOnlySpan
+		name       string
+		wantStatus codes.Code
+	}{
+		{cacheSpan, "Cache.ListMessages", codes.Unset},
+		{dbSpan, "DB.ListMessages", codes.Error},
+	} {
+		if tt.span.Name() != tt.name {
+			t.Errorf("Got span %q, want %q", tt.span.Name(), tt.name)
+		}
+		if tt.span.Parent().SpanID() != reqSpan.SpanContext().SpanID() {
+			t.Errorf("Span %q is not a child of the request span", tt.span.Name())
+		}
+		if tt.span.Status().Code != tt.wantStatus {
+			t.Errorf("Span %q has status %v, want %v", tt.span.Name(), tt.span.Status().Code, tt.wantStatus)
+		}
+	}
+
+	// The ids of internal spans are not sent to clients.
+	if got := resp.Header.Get("Traceparent"); got != "" {
+		t.Errorf("Got traceparent %q in the response, want none", got)
+	}
+}
+
+func TestAPI_RelayEvents_tracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bus := &testbus{}
+	publisher := &API{Logger: slogt.New(t), Bus: bus, TracerProvider: tp}
+	relay := &API{Logger: slogt.New(t), Bus: bus, TracerProvider: tp}
+	relay.RelayEvents(ctx, time.Millisecond)
+
+	reqCtx, reqSpan := tp.Tracer("test").Start(ctx, "request")
+	publisher.publish(reqCtx, Event{Type: EventMessageCreated}, nil)
+	reqSpan.End()
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	// The bus delivers the event before Publish returns.
+	receiveSpan, publishSpan := spans[0], spans[1]
+	for _, tt := range []struct {
+		span   sdktrace.ReadOnlySpan
+		name   string
+		kind   trace.SpanKind
+		parent trace.SpanContext
+	}{
+		{publishSpan, "Bus.Publish", trace.SpanKindProducer, reqSpan.SpanContext()},
+		{receiveSpan, "Bus.Receive", trace.SpanKindConsumer, publishSpan.SpanContext()},
+	} {
+		if tt.span.Name() != tt.name || tt.span.SpanKind() != tt.kind {
+			t.Errorf("Got %v span %q, want %v span %q", tt.span.SpanKind(), tt.span.Name(), tt.kind, tt.name)
+		}
+		if tt.span.Parent().TraceID() != tt.parent.TraceID() || tt.span.Parent().SpanID() != tt.parent.SpanID() {
+			t.Errorf("Span %q has parent %v, want %v", tt.span.Name(), tt.span.Parent(), tt.parent)
+		}
+	}
+}
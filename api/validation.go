@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxBodySize      = 64 << 10 // 64 KiB
+	maxTextLength    = 5000     // in characters
+	maxUserIDLength  = 255
+	maxChannelName   = 100 // in characters
+	maxReactionScore = 100
+)
+
+// reactionTypes holds the reaction types that can be added to a message.
+var reactionTypes = map[string]bool{
+	"like":        true,
+	"love":        true,
+	"laugh":       true,
+	"wow":         true,
+	"sad":         true,
+	"angry":       true,
+	"clap":        true,
+	"thumbs_up":   true,
+	"thumbs_down": true,
+}
+
+var userIDPattern = regexp.MustCompile(`^[a-zA-Z0-9@._-]+$`)
+
+// Codes used in field errors.
+const (
+	codeRequired      = "required"
+	codeTooLong       = "too_long"
+	codeInvalidFormat = "invalid_format"
+	codeInvalidType   = "invalid_type"
+	codeInvalidValue  = "invalid_value"
+	codeOutOfRange    = "out_of_range"
+	codeUnknownField  = "unknown_field"
+)
+
+// A FieldError describes why a field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// A validator collects the field errors of a request.
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(field, code, msg string) {
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: msg})
+}
+
+func (v *validator) valid() bool {
+	return len(v.errs) == 0
+}
+
+// text checks that s is a non-blank message text within the length limit.
+func (v *validator) text(field, s string) {
+	switch {
+	case strings.TrimSpace(s) == "":
+		v.add(field, codeRequired, "Text must not be empty")
+	case utf8.RuneCountInString(s) > maxTextLength:
+		v.add(field, codeTooLong, fmt.Sprintf("Text must be at most %d characters", maxTextLength))
+	}
+}
+
+// userID checks that s is a well-formed user id.
+func (v *validator) userID(field, s string) {
+	switch {
+	case s == "":
+		v.add(field, codeRequired, "User ID must not be empty")
+	case len(s) > maxUserIDLength:
+		v.add(field, codeTooLong, fmt.Sprintf("User ID must be at most %d characters", maxUserIDLength))
+	case !userIDPattern.MatchString(s):
+		v.add(field, codeInvalidFormat, "User ID may only contain letters, digits and @ . _ -")
+	}
+}
+
+// channelName checks that s is a non-blank channel name within the length
+// limit.
+func (v *validator) channelName(field, s string) {
+	switch {
+	case strings.TrimSpace(s) == "":
+		v.add(field, codeRequired, "Name must not be empty")
+	case utf8.RuneCountInString(s) > maxChannelName:
+		v.add(field, codeTooLong, fmt.Sprintf("Name must be at most %d characters", maxChannelName))
+	}
+}
+
+// reactionType checks that s is one of the allowed reaction types.
+func (v *validator) reactionType(field, s string) {
+	switch {
+	case s == "":
+		v.add(field, codeRequired, "Reaction type must not be empty")
+	case !reactionTypes[s]:
+		v.add(field, codeInvalidValue, fmt.Sprintf("Unknown reaction type %q", s))
+	}
+}
+
+// score checks that n is a valid reaction score.
+func (v *validator) score(field string, n int) {
+	if n < 1 || n > maxReactionScore {
+		v.add(field, codeOutOfRange, fmt.Sprintf("Score must be between 1 and %d", maxReactionScore))
+	}
+}
+
+// validID reports whether s is a well-formed resource id. Resources with a
+// malformed id cannot exist, so callers respond with 404.
+func validID(s string) bool {
+	return uuid.Validate(s) == nil
+}
+
+// decodeBody decodes the JSON request body into dst. The body must hold a
+// single JSON value without unknown fields and be at most maxBodySize bytes.
+// If the body is rejected, a response is written and false is returned.
+func (a *API) decodeBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	defer r.Body.Close()
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		// Anything but the end of the body after the value is an error.
+		var extra json.RawMessage
+		if err = dec.Decode(&extra); err == io.EOF {
+			return true
+		}
+		if err == nil {
+			err = errors.New("body holds more than one JSON value")
+		}
+	}
+
+	var (
+		maxErr  *http.MaxBytesError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		a.respondError(w, http.StatusRequestEntityTooLarge, err, "Request body too large")
+	case errors.As(err, &typeErr):
+		a.respondFieldErrors(w, http.StatusBadRequest, err, "Could not decode request body", FieldError{
+			Field:   typeErr.Field,
+			Code:    codeInvalidType,
+			Message: fmt.Sprintf("Expected a value of type %s", typeErr.Type),
+		})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The json package does not export a type for this error.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		a.respondFieldErrors(w, http.StatusBadRequest, err, "Could not decode request body", FieldError{
+			Field:   field,
+			Code:    codeUnknownField,
+			Message: "Unknown field",
+		})
+	default:
+		a.respondError(w, http.StatusBadRequest, err, "Could not decode request body")
+	}
+	return false
+}
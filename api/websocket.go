@@ -0,0 +1,446 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	// defaultMaxConnectionsPerUser is the default number of WebSocket
+	// connections a user may hold at once.
+	defaultMaxConnectionsPerUser = 5
+	// defaultWebSocketPingInterval is the default time between pings on a
+	// WebSocket connection.
+	defaultWebSocketPingInterval = 30 * time.Second
+	// wsWriteTimeout is the time a client has to accept a frame or answer a
+	// ping before it is disconnected.
+	wsWriteTimeout = 10 * time.Second
+	// maxSubscriptions is the number of channels and threads a connection
+	// may subscribe to.
+	maxSubscriptions = 100
+	// sendMessageRoute is the route whose rate limit applies to the
+	// messages sent over WebSocket connections.
+	sendMessageRoute = "POST /messages"
+)
+
+// The types of the frames sent by WebSocket clients.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsSendMessage = "send_message"
+)
+
+// A wsRequest is a frame sent by a WebSocket client. Subscriptions name
+// either a channel or the message whose thread is followed.
+type wsRequest struct {
+	// ID is chosen by the client and echoed in the reply.
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+// A wsFrame is a frame sent to a WebSocket client: the reply to a request,
+// with type "ack" or "error", or an event.
+type wsFrame struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id,omitempty"`
+	Event   string       `json:"event,omitempty"`
+	EventID uint64       `json:"event_id,omitempty"`
+	Data    any          `json:"data,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// serveWebSocket upgrades the request to a WebSocket connection of the
+// authenticated user or, without authentication, of the user given by the
+// user_id query parameter. The client subscribes to channels
+// and threads to receive their events, and can send messages.
+func (a *API) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.actingUser(w, r, "user_id", r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	var v validator
+	v.userID("user_id", userID)
+	if !v.valid() {
+		a.respondInvalid(w, &v)
+		return
+	}
+	// Users of different apps are different users.
+	connKey := appID(r) + "/" + userID
+	if !a.acquireConn(connKey) {
+		err := fmt.Errorf("user %s has too many connections", connKey)
+		a.respondError(w, http.StatusTooManyRequests, err, "Too many connections")
+		return
+	}
+	defer a.releaseConn(connKey)
+
+	c := &wsConn{
+		api:       a,
+		appID:     appID(r),
+		userID:    userID,
+		limitKeys: a.rateLimitKeys(r),
+		channels:  make(map[string]bool),
+		threads:   make(map[string]bool),
+	}
+	// Clients receive the events from the time they subscribe, so none are
+	// replayed.
+	sub, _, err := a.events.subscribe(math.MaxUint64, c.match)
+	if err != nil {
+		a.respondError(w, http.StatusServiceUnavailable, err, "Server is shutting down")
+		return
+	}
+	defer a.events.unsubscribe(sub)
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has responded already.
+		a.Logger.Error("Could not accept WebSocket connection", "error", err.Error())
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(maxBodySize)
+	c.conn = conn
+
+	// The connection ends as soon as reading, pinging or sending events
+	// stops.
+	ctx, cancel := context.WithCancel(r.Context())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		c.readRequests(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		c.ping(ctx)
+	}()
+	c.sendEvents(ctx, sub)
+	cancel()
+	conn.CloseNow()
+	wg.Wait()
+}
+
+// acquireConn counts a new connection of the user, identified by its app
+// and id. It reports false if the user holds the maximum number of
+// connections already.
+func (a *API) acquireConn(userID string) bool {
+	limit := a.MaxConnectionsPerUser
+	if limit <= 0 {
+		limit = defaultMaxConnectionsPerUser
+	}
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	if a.conns[userID] >= limit {
+		return false
+	}
+	if a.conns == nil {
+		a.conns = make(map[string]int)
+	}
+	a.conns[userID]++
+	return true
+}
+
+// releaseConn counts a closed connection of the user.
+func (a *API) releaseConn(userID string) {
+	a.connsMu.Lock()
+	defer a.connsMu.Unlock()
+	a.conns[userID]--
+	if a.conns[userID] <= 0 {
+		delete(a.conns, userID)
+	}
+}
+
+// A wsConn is a WebSocket connection and the channels and threads it
+// subscribed to.
+type wsConn struct {
+	api    *API
+	conn   *websocket.Conn
+	appID  string
+	userID string
+	// limitKeys are the rate limit buckets of the messages sent over the
+	// connection.
+	limitKeys []string
+
+	mu       sync.Mutex
+	channels map[string]bool
+	threads  map[string]bool
+}
+
+// match reports whether the connection subscribed to the channel or thread
+// of e. Changes to the parent of a thread belong to the thread as well.
+func (c *wsConn) match(e Event) bool {
+	if e.AppID != c.appID {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[e.ChannelID] || c.threads[e.MessageID] || (e.ParentID != "" && c.threads[e.ParentID])
+}
+
+// sendEvents sends the events the connection subscribed to until ctx is
+// done. The events are buffered by the subscription, and clients that fall
+// too far behind are disconnected.
+func (c *wsConn) sendEvents(ctx context.Context, sub *subscription) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.events:
+			if !ok {
+				if c.api.draining.Load() {
+					c.conn.Close(websocket.StatusGoingAway, "Server is shutting down")
+				} else {
+					c.conn.Close(websocket.StatusPolicyViolation, "Too slow to receive events")
+				}
+				return
+			}
+			err := c.write(ctx, wsFrame{
+				Type:    "event",
+				Event:   e.Type,
+				EventID: e.ID,
+				Data:    e.Data,
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ping checks that the client is alive until ctx is done. It returns when
+// the client does not answer in time.
+func (c *wsConn) ping(ctx context.Context) {
+	interval := c.api.WebSocketPingInterval
+	if interval <= 0 {
+		interval = defaultWebSocketPingInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			pctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := c.conn.Ping(pctx)
+			cancel()
+			if err != nil {
+				c.api.Logger.Info("WebSocket client did not answer ping", "user_id", c.userID, "error", err.Error())
+				return
+			}
+		}
+	}
+}
+
+// readRequests handles the requests of the client until the connection is
+// closed.
+func (c *wsConn) readRequests(ctx context.Context) {
+	for {
+		_, data, err := c.conn.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+				c.api.Logger.Info("Could not read WebSocket frame", "user_id", c.userID, "error", err.Error())
+			}
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.replyError(ctx, req, fmt.Errorf("decode frame: %w", err), "Invalid JSON")
+			continue
+		}
+		switch req.Type {
+		case wsSubscribe, wsUnsubscribe:
+			c.subscribe(ctx, req)
+		case wsSendMessage:
+			c.sendMessage(ctx, req)
+		default:
+			c.replyError(ctx, req, fmt.Errorf("unknown frame type %q", req.Type), "Unknown request type", FieldError{
+				Field:   "type",
+				Code:    codeInvalidValue,
+				Message: "Type must be one of subscribe, unsubscribe or send_message",
+			})
+		}
+	}
+}
+
+// subscribe adds or removes a subscription to a channel or thread.
+// Unsubscribing from a channel or thread that was not subscribed to is a
+// no-op.
+func (c *wsConn) subscribe(ctx context.Context, req wsRequest) {
+	if (req.ChannelID == "") == (req.MessageID == "") {
+		c.replyError(ctx, req, errors.New("subscription without channel or thread"), "Invalid request", FieldError{
+			Field:   "channel_id",
+			Code:    codeRequired,
+			Message: "Exactly one of channel_id and message_id must be set",
+		})
+		return
+	}
+	if req.Type == wsUnsubscribe {
+		c.mu.Lock()
+		delete(c.channels, req.ChannelID)
+		delete(c.threads, req.MessageID)
+		c.mu.Unlock()
+		c.reply(ctx, req, nil)
+		return
+	}
+
+	if req.ChannelID != "" && !c.findChannel(ctx, req) {
+		return
+	}
+	if req.MessageID != "" && !c.findMessage(ctx, req) {
+		return
+	}
+	c.mu.Lock()
+	full := len(c.channels)+len(c.threads) >= maxSubscriptions
+	if !full && req.ChannelID != "" {
+		c.channels[req.ChannelID] = true
+	}
+	if !full && req.MessageID != "" {
+		c.threads[req.MessageID] = true
+	}
+	c.mu.Unlock()
+	if full {
+		err := fmt.Errorf("user %s has %d subscriptions", c.userID, maxSubscriptions)
+		c.replyError(ctx, req, err, "Too many subscriptions")
+		return
+	}
+	c.reply(ctx, req, nil)
+}
+
+// findChannel checks that the channel of req exists. If it does not, an
+// error is sent and false is returned.
+func (c *wsConn) findChannel(ctx context.Context, req wsRequest) bool {
+	if !validID(req.ChannelID) {
+		c.replyError(ctx, req, fmt.Errorf("invalid channel id %q", req.ChannelID), "Channel not found")
+		return false
+	}
+	if req.ChannelID == DefaultChannelID {
+		return true
+	}
+	_, err := c.api.DB.GetChannel(ctx, c.appID, req.ChannelID)
+	if errors.Is(err, ErrNotFound) {
+		c.replyError(ctx, req, err, "Channel not found")
+		return false
+	}
+	if err != nil {
+		c.replyError(ctx, req, err, "Could not subscribe")
+		return false
+	}
+	return true
+}
+
+// findMessage checks that the message of req exists. If it does not, an
+// error is sent and false is returned.
+func (c *wsConn) findMessage(ctx context.Context, req wsRequest) bool {
+	if !validID(req.MessageID) {
+		c.replyError(ctx, req, fmt.Errorf("invalid message id %q", req.MessageID), "Message not found")
+		return false
+	}
+	_, err := c.api.DB.GetMessage(ctx, c.appID, req.MessageID)
+	if errors.Is(err, ErrNotFound) {
+		c.replyError(ctx, req, err, "Message not found")
+		return false
+	}
+	if err != nil {
+		c.replyError(ctx, req, err, "Could not subscribe")
+		return false
+	}
+	return true
+}
+
+// sendMessage posts a message of the user in the channel of req, or in the
+// default channel if none is given.
+func (c *wsConn) sendMessage(ctx context.Context, req wsRequest) {
+	type response struct {
+		ID        string `json:"id"`
+		ChannelID string `json:"channel_id"`
+		Text      string `json:"text"`
+		UserID    string `json:"user_id"`
+		CreatedAt string `json:"created_at"`
+		Version   int    `json:"version"`
+	}
+
+	var v validator
+	v.text("text", req.Text)
+	if !v.valid() {
+		err := fmt.Errorf("invalid request: %d field errors", len(v.errs))
+		c.replyError(ctx, req, err, "Invalid request", v.errs...)
+		return
+	}
+	if req.ChannelID == "" {
+		req.ChannelID = DefaultChannelID
+	}
+	if !validID(req.ChannelID) {
+		c.replyError(ctx, req, fmt.Errorf("invalid channel id %q", req.ChannelID), "Channel not found")
+		return
+	}
+	// Messages count towards the same limit as the ones posted over REST.
+	if limit, ok := c.api.RateLimits[sendMessageRoute]; ok && c.api.RateLimiter != nil {
+		res, err := c.api.allow(ctx, sendMessageRoute, limit, c.limitKeys)
+		if err != nil {
+			c.api.Logger.Error("Could not check rate limit", "error", err.Error())
+		} else if !res.Allowed {
+			err := fmt.Errorf("rate limit %s of %s exceeded, retry after %s", limit, sendMessageRoute, res.RetryAfter)
+			c.replyError(ctx, req, err, "Too many requests")
+			return
+		}
+	}
+
+	msg, _, err := c.api.postMessage(ctx, Message{
+		ID:        newID(),
+		AppID:     c.appID,
+		ChannelID: req.ChannelID,
+		Text:      req.Text,
+		UserID:    c.userID,
+		CreatedAt: now(),
+		Version:   1,
+	})
+	if errors.Is(err, ErrNotFound) {
+		c.replyError(ctx, req, err, "Channel not found")
+		return
+	}
+	if err != nil {
+		c.replyError(ctx, req, err, "Could not insert message")
+		return
+	}
+	c.reply(ctx, req, response{
+		ID:        msg.ID,
+		ChannelID: msg.ChannelID,
+		Text:      msg.Text,
+		UserID:    msg.UserID,
+		CreatedAt: msg.CreatedAt.Format(time.RFC1123),
+		Version:   msg.Version,
+	})
+}
+
+// reply acknowledges req.
+func (c *wsConn) reply(ctx context.Context, req wsRequest, data any) {
+	c.write(ctx, wsFrame{Type: "ack", ID: req.ID, Data: data})
+}
+
+// replyError rejects req.
+func (c *wsConn) replyError(ctx context.Context, req wsRequest, err error, msg string, fields ...FieldError) {
+	c.api.Logger.Error("Error", "error", err.Error())
+	c.write(ctx, wsFrame{Type: "error", ID: req.ID, Error: msg, Fields: fields})
+}
+
+// write sends a frame. Frames may be written concurrently.
+func (c *wsConn) write(ctx context.Context, f wsFrame) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, c.conn, f)
+}
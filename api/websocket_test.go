@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/go-cmp/cmp"
+	"github.com/neilotoole/slogt"
+)
+
+func TestAPI_serveWebSocket(t *testing.T) {
+	const channelID = "8d6b37a4-5c56-4d5f-9c1b-2c8f6a1e4b7d"
+	api := &API{
+		DB: &testdb{
+			T: t,
+			getChannel: func(t *testing.T, id string) (Channel, error) {
+				if id != channelID {
+					return Channel{}, ErrNotFound
+				}
+				return Channel{ID: id, Name: "random"}, nil
+			},
+			insertMessage: func(t *testing.T, msg Message) (Message, error) {
+				return msg, nil
+			},
+		},
+		Cache: &testcache{
+			T: t,
+			insertMessage: func(t *testing.T, msg Message) error {
+				return nil
+			},
+		},
+		Logger: slogt.New(t),
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn := dialWS(ctx, t, srv.URL+"/ws?user_id=alice")
+	defer conn.CloseNow()
+
+	tests := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{
+			name:    "Subscribe",
+			request: `{"id": "1", "type": "subscribe", "channel_id": "` + channelID + `"}`,
+			want:    `{"type": "ack", "id": "1"}`,
+		},
+		{
+			name:    "UnknownChannel",
+			request: `{"id": "2", "type": "subscribe", "channel_id": "0a3e1c5e-6a8f-4c7e-9b0e-2f7d4b1c9a83"}`,
+			want:    `{"type": "error", "id": "2", "error": "Channel not found"}`,
+		},
+		{
+			name:    "NoTarget",
+			request: `{"id": "3", "type": "subscribe"}`,
+			want: `{"type": "error", "id": "3", "error": "Invalid request", "fields": [
+				{"field": "channel_id", "code": "required", "message": "Exactly one of channel_id and message_id must be set"}
+			]}`,
+		},
+		{
+			name:    "UnknownType",
+			request: `{"id": "4", "type": "shout"}`,
+			want: `{"type": "error", "id": "4", "error": "Unknown request type", "fields": [
+				{"field": "type", "code": "invalid_value", "message": "Type must be one of subscribe, unsubscribe or send_message"}
+			]}`,
+		},
+		{
+			name:    "InvalidJSON",
+			request: `{"id": `,
+			want:    `{"type": "error", "error": "Invalid JSON"}`,
+		},
+		{
+			name:    "EmptyText",
+			request: `{"id": "5", "type": "send_message", "channel_id": "` + channelID + `", "text": " "}`,
+			want: `{"type": "error", "id": "5", "error": "Invalid request", "fields": [
+				{"field": "text", "code": "required", "message": "Text must not be empty"}
+			]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.Write(ctx, websocket.MessageText, []byte(tt.request)); err != nil {
+				t.Fatal(err)
+			}
+			checkFrame(ctx, t, conn, tt.want)
+		})
+	}
+
+	// Messages sent over the socket are acknowledged and published to the
+	// subscribers of the channel, including the sender.
+	send := `{"id": "6", "type": "send_message", "channel_id": "` + channelID + `", "text": "hello"}`
+	if err := conn.Write(ctx, websocket.MessageText, []byte(send)); err != nil {
+		t.Fatal(err)
+	}
+	var frames []wsFrame
+	for range 2 {
+		var f wsFrame
+		if err := wsjson.Read(ctx, conn, &f); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+	var ack, event wsFrame
+	for _, f := range frames {
+		if f.Type == "ack" {
+			ack = f
+		} else {
+			event = f
+		}
+	}
+	if ack.ID != "6" || ack.Data.(map[string]any)["user_id"] != "alice" {
+		t.Errorf("Got ack %+v, want the message of alice", ack)
+	}
+	if event.Event != EventMessageCreated || event.Data.(map[string]any)["text"] != "hello" {
+		t.Errorf("Got event %+v, want the new message", event)
+	}
+
+	// Messages posted over REST are published as well, but only to the
+	// subscribers of their channel.
+	post(t, srv.URL+"/messages", `{"text": "elsewhere", "user_id": "bob"}`)
+	post(t, srv.URL+"/channels/"+channelID+"/messages", `{"text": "here", "user_id": "bob"}`)
+	var f wsFrame
+	if err := wsjson.Read(ctx, conn, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Data.(map[string]any)["text"] != "here" {
+		t.Errorf("Got event %+v, want the message in the channel", f)
+	}
+
+	// Unsubscribed clients receive no more events.
+	unsubscribe := `{"id": "7", "type": "unsubscribe", "channel_id": "` + channelID + `"}`
+	if err := conn.Write(ctx, websocket.MessageText, []byte(unsubscribe)); err != nil {
+		t.Fatal(err)
+	}
+	checkFrame(ctx, t, conn, `{"type": "ack", "id": "7"}`)
+	post(t, srv.URL+"/channels/"+channelID+"/messages", `{"text": "unseen", "user_id": "bob"}`)
+
+	// Draining closes the connection.
+	api.Drain()
+	_, _, err := conn.Read(ctx)
+	if got := websocket.CloseStatus(err); got != websocket.StatusGoingAway {
+		t.Errorf("Got close status %v (%v), want %v", got, err, websocket.StatusGoingAway)
+	}
+	waitClosed(ctx, t, api)
+}
+
+func TestAPI_serveWebSocket_connectionLimit(t *testing.T) {
+	api := &API{
+		DB:                    &testdb{T: t},
+		Logger:                slogt.New(t),
+		MaxConnectionsPerUser: 2,
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := dialWS(ctx, t, srv.URL+"/ws?user_id=alice")
+	second := dialWS(ctx, t, srv.URL+"/ws?user_id=alice")
+
+	_, resp, err := websocket.Dial(ctx, wsURL(srv.URL+"/ws?user_id=alice"), nil)
+	if err == nil {
+		t.Fatal("Connected beyond the limit")
+	}
+	checkStatus(t, resp.StatusCode, http.StatusTooManyRequests)
+
+	// Other users are not affected.
+	other := dialWS(ctx, t, srv.URL+"/ws?user_id=bob")
+
+	// Closed connections no longer count.
+	first.Close(websocket.StatusNormalClosure, "")
+	for {
+		conn, _, err := websocket.Dial(ctx, wsURL(srv.URL+"/ws?user_id=alice"), nil)
+		if err == nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("The closed connection still counts")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	second.Close(websocket.StatusNormalClosure, "")
+	other.Close(websocket.StatusNormalClosure, "")
+	waitClosed(ctx, t, api)
+}
+
+// waitClosed waits until the server closed all WebSocket connections, since
+// the test server does not wait for hijacked connections.
+func waitClosed(ctx context.Context, t *testing.T, api *API) {
+	t.Helper()
+	for {
+		api.connsMu.Lock()
+		n := len(api.conns)
+		api.connsMu.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%d users still have connections", n)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func dialWS(ctx context.Context, t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(ctx, wsURL(url), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func wsURL(url string) string {
+	return "ws" + strings.TrimPrefix(url, "http")
+}
+
+func checkFrame(ctx context.Context, t *testing.T, conn *websocket.Conn, want string) {
+	t.Helper()
+	_, got, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotv, wantv any
+	if err := json.Unmarshal(got, &gotv); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantv); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(gotv, wantv); diff != "" {
+		t.Errorf("Frame differs (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// setupAuth returns the configuration that verifies JWTs with the HS256
+// secret, given directly or in secretFile, and the RS256 public key in the
+// PEM file publicKeyFile. Without any key, authentication is disabled and
+// nil is returned.
+func setupAuth(secret, secretFile, publicKeyFile, issuer, audience string) (*api.Auth, error) {
+	if secret != "" && secretFile != "" {
+		return nil, errors.New("both a JWT secret and a JWT secret file are given")
+	}
+	auth := &api.Auth{
+		Issuer:   issuer,
+		Audience: audience,
+	}
+	if secret != "" {
+		auth.HMACSecret = []byte(secret)
+	}
+	if secretFile != "" {
+		b, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT secret: %w", err)
+		}
+		// Editors and echo add a trailing newline.
+		auth.HMACSecret = []byte(strings.TrimSpace(string(b)))
+		if len(auth.HMACSecret) == 0 {
+			return nil, errors.New("JWT secret file is empty")
+		}
+	}
+	if publicKeyFile != "" {
+		b, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT public key: %w", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT public key: %w", err)
+		}
+		auth.RSAPublicKey = key
+	}
+	if len(auth.HMACSecret) == 0 && auth.RSAPublicKey == nil {
+		return nil, nil
+	}
+	return auth, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+)
+
+// parseRateLimits parses comma-separated limits of the form
+// "METHOD /route=requests/period", such as "POST /messages=30/1m". An empty
+// string sets no limits.
+func parseRateLimits(s string) (map[string]api.RateLimit, error) {
+	limits := make(map[string]api.RateLimit)
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		pattern, limit, ok := strings.Cut(entry, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("rate limit %q: want METHOD /route=requests/period", entry)
+		}
+		requests, period, ok := strings.Cut(limit, "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: want requests/period", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(requests))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rate limit %q: requests must be a positive number", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(period))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("rate limit %q: period must be a positive duration", entry)
+		}
+		if _, ok := limits[pattern]; ok {
+			return nil, fmt.Errorf("rate limit %q: %s is limited twice", entry, pattern)
+		}
+		limits[pattern] = api.RateLimit{Requests: n, Period: d}
+	}
+	return limits, nil
+}
@@ -0,0 +1,690 @@
+// Package memory provides storage and caching in memory. It implements the
+// same contracts as the postgres and redis packages, so that the API can run
+// without any external dependencies. All data is lost when the process
+// exits.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/google/uuid"
+)
+
+// DB provides storage in memory. The data of every app is kept apart, except
+// for the default channel, which belongs to all of them. It is safe for
+// concurrent use.
+type DB struct {
+	mu        sync.RWMutex
+	channels  map[string]api.Channel          // by id
+	messages  []api.Message                   // sorted newest first
+	reactions map[string][]api.Reaction       // by message id, oldest first
+	history   map[string][]api.MessageVersion // by message id, oldest first
+}
+
+// NewDB returns a DB that holds only the default channel.
+func NewDB() *DB {
+	return &DB{
+		channels: map[string]api.Channel{
+			api.DefaultChannelID: {ID: api.DefaultChannelID, AppID: api.DefaultAppID, Name: "general", CreatedAt: now()},
+		},
+		reactions: make(map[string][]api.Reaction),
+		history:   make(map[string][]api.MessageVersion),
+	}
+}
+
+// ListMessages returns the messages selected by opts, sorted by creation time
+// and id in descending order.
+func (db *DB) ListMessages(_ context.Context, opts api.ListOptions, excludeMsgIDs ...string) ([]api.Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	appID := orDefault(opts.AppID)
+	out := make([]api.Message, 0, len(db.messages))
+	for _, msg := range db.messages {
+		if msg.AppID != appID {
+			continue
+		}
+		c := msg.Cursor()
+		if opts.Before != nil && !c.Before(*opts.Before) {
+			continue
+		}
+		if opts.After != nil && !opts.After.Before(c) {
+			continue
+		}
+		if opts.ChannelID != "" && msg.ChannelID != opts.ChannelID {
+			continue
+		}
+		if opts.ParentID != "" && msg.ParentID != opts.ParentID {
+			continue
+		}
+		if opts.ParentID == "" && msg.ParentID != "" && !msg.ShowInChannel {
+			continue
+		}
+		if slices.Contains(excludeMsgIDs, msg.ID) {
+			continue
+		}
+		if msg.Deleted() && !opts.IncludeDeleted {
+			continue
+		}
+		out = append(out, msg)
+	}
+	if opts.Limit > 0 && len(out) > opts.Limit {
+		if opts.After != nil {
+			// Keep the messages closest to the cursor.
+			return out[len(out)-opts.Limit:], nil
+		}
+		return out[:opts.Limit], nil
+	}
+	return out, nil
+}
+
+// InsertMessage stores a message. The id and creation time are generated
+// unless they are set on msg, and the message is posted to the default
+// channel unless msg.ChannelID is set. A reply is added to the reply count
+// of its parent. If the channel or the parent does not exist in the app of
+// the message, or the parent was deleted, api.ErrNotFound is returned. If a
+// message with the same id exists, api.ErrConflict is returned.
+func (db *DB) InsertMessage(_ context.Context, msg api.Message) (api.Message, error) {
+	msg.AppID = orDefault(msg.AppID)
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	if msg.ChannelID == "" {
+		msg.ChannelID = api.DefaultChannelID
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = now()
+	}
+	if msg.Version == 0 {
+		msg.Version = 1
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.hasChannel(msg.AppID, msg.ChannelID) {
+		return api.Message{}, fmt.Errorf("channel %s: %w", msg.ChannelID, api.ErrNotFound)
+	}
+	if db.message(msg.ID) >= 0 {
+		return api.Message{}, fmt.Errorf("message %s: %w", msg.ID, api.ErrConflict)
+	}
+	if msg.ParentID != "" {
+		p := db.liveMessage(msg.AppID, msg.ParentID)
+		if p < 0 {
+			return api.Message{}, fmt.Errorf("message %s: %w", msg.ParentID, api.ErrNotFound)
+		}
+		parent := &db.messages[p]
+		parent.ReplyCount++
+		if msg.CreatedAt.After(parent.LatestReplyAt) {
+			parent.LatestReplyAt = msg.CreatedAt
+		}
+	}
+	i, _ := slices.BinarySearchFunc(db.messages, msg, compareNewestFirst)
+	db.messages = slices.Insert(db.messages, i, msg)
+	return msg, nil
+}
+
+// GetMessage returns the message of the app with the given id, including a
+// deleted one. It returns api.ErrNotFound if the message does not exist.
+func (db *DB) GetMessage(_ context.Context, appID, msgID string) (api.Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	i := db.message(msgID)
+	if i < 0 || db.messages[i].AppID != orDefault(appID) {
+		return api.Message{}, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	return db.messages[i], nil
+}
+
+// InsertChannel stores a channel. The id and creation time are generated
+// unless they are set on ch. If a channel with the same id exists,
+// api.ErrConflict is returned.
+func (db *DB) InsertChannel(_ context.Context, ch api.Channel) (api.Channel, error) {
+	ch.AppID = orDefault(ch.AppID)
+	if ch.ID == "" {
+		ch.ID = uuid.NewString()
+	}
+	if ch.CreatedAt.IsZero() {
+		ch.CreatedAt = now()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.channels[ch.ID]; ok {
+		return api.Channel{}, fmt.Errorf("channel %s: %w", ch.ID, api.ErrConflict)
+	}
+	db.channels[ch.ID] = ch
+	return ch, nil
+}
+
+// GetChannel returns the channel of the app with the given id. It returns
+// api.ErrNotFound if the channel does not exist.
+func (db *DB) GetChannel(_ context.Context, appID, channelID string) (api.Channel, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if !db.hasChannel(orDefault(appID), channelID) {
+		return api.Channel{}, fmt.Errorf("channel %s: %w", channelID, api.ErrNotFound)
+	}
+	return db.channels[channelID], nil
+}
+
+// hasChannel reports whether the channel exists in the app. The caller must
+// hold db.mu.
+func (db *DB) hasChannel(appID, channelID string) bool {
+	ch, ok := db.channels[channelID]
+	return ok && (ch.AppID == appID || ch.ID == api.DefaultChannelID)
+}
+
+// UpdateMessage changes the text of a message and keeps the replaced text in
+// its history. It returns api.ErrNotFound if the message does not exist or
+// was deleted, api.ErrForbidden if upd.UserID is not its author and
+// api.ErrVersionMismatch if upd.IfVersion is set and differs from its
+// version.
+func (db *DB) UpdateMessage(_ context.Context, upd api.MessageUpdate) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i := db.liveMessage(upd.AppID, upd.ID)
+	if i < 0 {
+		return api.Message{}, fmt.Errorf("message %s: %w", upd.ID, api.ErrNotFound)
+	}
+	msg := db.messages[i]
+	if msg.UserID != upd.UserID {
+		return api.Message{}, fmt.Errorf("message %s: %w", upd.ID, api.ErrForbidden)
+	}
+	if upd.IfVersion != 0 && upd.IfVersion != msg.Version {
+		return api.Message{}, fmt.Errorf("message %s has version %d: %w", upd.ID, msg.Version, api.ErrVersionMismatch)
+	}
+	if msg.Text == upd.Text {
+		return msg, nil
+	}
+
+	written := msg.CreatedAt
+	if !msg.UpdatedAt.IsZero() {
+		written = msg.UpdatedAt
+	}
+	db.history[msg.ID] = append(db.history[msg.ID], api.MessageVersion{
+		Version:    msg.Version,
+		Text:       msg.Text,
+		CreatedAt:  written,
+		ReplacedAt: upd.UpdatedAt,
+	})
+	msg.Text = upd.Text
+	msg.UpdatedAt = upd.UpdatedAt
+	msg.Version++
+	db.messages[i] = msg
+	return msg, nil
+}
+
+// MessageHistory returns the prior versions of a message, newest first. It
+// returns api.ErrNotFound if the message does not exist or was deleted.
+func (db *DB) MessageHistory(_ context.Context, appID, msgID string) ([]api.MessageVersion, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.liveMessage(appID, msgID) < 0 {
+		return nil, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	out := slices.Clone(db.history[msgID])
+	slices.Reverse(out)
+	if out == nil {
+		out = []api.MessageVersion{}
+	}
+	return out, nil
+}
+
+// DeleteMessage marks a message as deleted and takes a deleted reply off the
+// reply count of its parent. It returns api.ErrNotFound if the message does
+// not exist or was already deleted, and api.ErrForbidden if del.UserID is
+// neither its author nor a moderator.
+func (db *DB) DeleteMessage(_ context.Context, del api.MessageDeletion) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i := db.liveMessage(del.AppID, del.ID)
+	if i < 0 {
+		return api.Message{}, fmt.Errorf("message %s: %w", del.ID, api.ErrNotFound)
+	}
+	if db.messages[i].UserID != del.UserID && !del.Moderator {
+		return api.Message{}, fmt.Errorf("message %s: %w", del.ID, api.ErrForbidden)
+	}
+	db.messages[i].DeletedAt = del.DeletedAt
+	if p := db.message(db.messages[i].ParentID); p >= 0 && db.messages[p].AppID == db.messages[i].AppID {
+		db.messages[p].ReplyCount--
+		db.messages[p].LatestReplyAt = db.latestReplyAt(db.messages[p].ID)
+	}
+	return db.messages[i], nil
+}
+
+// PurgeMessages removes the messages of all apps deleted before the given
+// time, along with their reactions and history. A message that has replies
+// is kept as a stub without text, reactions or history, so that its replies
+// stay in their thread, and is removed once it has no replies left.
+func (db *DB) PurgeMessages(_ context.Context, deletedBefore time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	hasReplies := make(map[string]bool)
+	for _, m := range db.messages {
+		if m.ParentID != "" {
+			hasReplies[m.ParentID] = true
+		}
+	}
+	n := 0
+	db.messages = slices.DeleteFunc(db.messages, func(m api.Message) bool {
+		if !m.Deleted() || !m.DeletedAt.Before(deletedBefore) {
+			return false
+		}
+		delete(db.reactions, m.ID)
+		delete(db.history, m.ID)
+		if hasReplies[m.ID] {
+			return false
+		}
+		n++
+		return true
+	})
+	for i, m := range db.messages {
+		if hasReplies[m.ID] && m.Deleted() && m.DeletedAt.Before(deletedBefore) {
+			db.messages[i].Text = ""
+		}
+	}
+	return n, nil
+}
+
+// InsertReaction stores a reaction, or adds its score to the reaction of the
+// user of the same type if there is one. The returned reaction holds
+// generated fields, such as the reaction id, and whether it was added. If the
+// message does not exist or was deleted, api.ErrNotFound is returned.
+func (db *DB) InsertReaction(_ context.Context, r api.Reaction, opts api.ReactionOptions) (api.Reaction, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putReaction(r, opts, true)
+}
+
+// SetReaction sets the score of the user's reaction of type r.Type to the
+// message, adding the reaction if the user has none. If the message does not
+// exist or was deleted, api.ErrNotFound is returned.
+func (db *DB) SetReaction(_ context.Context, r api.Reaction, opts api.ReactionOptions) (api.Reaction, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putReaction(r, opts, false)
+}
+
+// putReaction adds the reaction of a user, or changes the score of the
+// existing one. The score is added to the existing score if accumulate is
+// set. The caller must hold db.mu.
+func (db *DB) putReaction(r api.Reaction, opts api.ReactionOptions, accumulate bool) (api.Reaction, bool, error) {
+	r.AppID = orDefault(r.AppID)
+	if db.liveMessage(r.AppID, r.MessageID) < 0 {
+		return api.Reaction{}, false, fmt.Errorf("message %s: %w", r.MessageID, api.ErrNotFound)
+	}
+	reactions := db.reactions[r.MessageID]
+	if opts.Exclusive {
+		reactions = slices.DeleteFunc(reactions, func(o api.Reaction) bool { return o.UserID == r.UserID && o.Type != r.Type })
+	}
+
+	i := slices.IndexFunc(reactions, func(o api.Reaction) bool { return o.UserID == r.UserID && o.Type == r.Type })
+	created := i < 0
+	if created {
+		r.ID = uuid.NewString()
+		r.CreatedAt = now()
+		reactions = append(reactions, r)
+		i = len(reactions) - 1
+	} else if accumulate {
+		reactions[i].Score += r.Score
+	} else {
+		reactions[i].Score = r.Score
+	}
+	if opts.MaxScore > 0 {
+		reactions[i].Score = min(reactions[i].Score, opts.MaxScore)
+	}
+	db.reactions[r.MessageID] = reactions
+	return reactions[i], created, nil
+}
+
+// DeleteReaction removes the user's reaction of the given type from the
+// message and returns how many were removed. If the message does not exist
+// or was deleted, api.ErrNotFound is returned.
+func (db *DB) DeleteReaction(_ context.Context, appID, msgID, userID, reactionType string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.liveMessage(appID, msgID) < 0 {
+		return 0, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	reactions := db.reactions[msgID]
+	n := len(reactions)
+	db.reactions[msgID] = slices.DeleteFunc(reactions, func(o api.Reaction) bool {
+		return o.UserID == userID && o.Type == reactionType
+	})
+	return n - len(db.reactions[msgID]), nil
+}
+
+// ReactionSummaries returns the aggregated reactions for each of the given
+// messages of the app, including up to latest of their most recent
+// reactions. Messages without reactions are omitted from the result.
+func (db *DB) ReactionSummaries(_ context.Context, appID string, msgIDs []string, latest int) (map[string]api.ReactionSummary, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	out := make(map[string]api.ReactionSummary, len(msgIDs))
+	for _, id := range msgIDs {
+		reactions := db.reactions[id]
+		if len(reactions) == 0 || reactions[0].AppID != orDefault(appID) {
+			continue
+		}
+		sum := api.ReactionSummary{
+			Counts: make(map[string]int),
+			Scores: make(map[string]int),
+		}
+		for _, r := range reactions {
+			sum.Count++
+			sum.TotalScore += r.Score
+			sum.Counts[r.Type]++
+			sum.Scores[r.Type] += r.Score
+		}
+		if latest > 0 {
+			sorted := slices.Clone(reactions)
+			slices.SortFunc(sorted, func(a, b api.Reaction) int {
+				if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+					return c
+				}
+				return strings.Compare(b.ID, a.ID)
+			})
+			sum.Latest = sorted[:min(latest, len(sorted))]
+		}
+		out[id] = sum
+	}
+	return out, nil
+}
+
+// message returns the index of the message with the given id in any app, or
+// -1 if it does not exist. The caller must hold db.mu.
+func (db *DB) message(id string) int {
+	return slices.IndexFunc(db.messages, func(m api.Message) bool { return m.ID == id })
+}
+
+// latestReplyAt returns the time of the latest live reply to a message, or
+// the zero time if it has none. The caller must hold db.mu.
+func (db *DB) latestReplyAt(parentID string) time.Time {
+	var latest time.Time
+	for _, m := range db.messages {
+		if m.ParentID == parentID && !m.Deleted() && m.CreatedAt.After(latest) {
+			latest = m.CreatedAt
+		}
+	}
+	return latest
+}
+
+// liveMessage returns the index of the message of the app with the given id,
+// or -1 if it does not exist or was deleted. The caller must hold db.mu.
+func (db *DB) liveMessage(appID, id string) int {
+	i := db.message(id)
+	if i < 0 || db.messages[i].AppID != orDefault(appID) || db.messages[i].Deleted() {
+		return -1
+	}
+	return i
+}
+
+const maxSize = 10
+
+// Cache provides caching in memory. Like the Redis cache, it holds the latest
+// 10 messages of each channel of each app. It is safe for concurrent use.
+type Cache struct {
+	mu       sync.RWMutex
+	messages map[channelKey][]api.Message // sorted newest first
+}
+
+// A channelKey identifies the channel of an app.
+type channelKey struct {
+	appID, channelID string
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{messages: make(map[channelKey][]api.Message)}
+}
+
+// ListMessages returns the cached messages of a channel of the app, sorted
+// by their creation time in descending order.
+func (c *Cache) ListMessages(_ context.Context, appID, channelID string) ([]api.Message, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]api.Message{}, c.messages[channelKey{orDefault(appID), channelID}]...), nil
+}
+
+// InsertMessage adds the message to the cache of its channel. The oldest
+// message is evicted if the channel holds more than 10 messages.
+func (c *Cache) InsertMessage(_ context.Context, msg api.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg.AppID = orDefault(msg.AppID)
+	key := channelKey{msg.AppID, msg.ChannelID}
+	msgs := slices.DeleteFunc(c.messages[key], func(m api.Message) bool { return m.ID == msg.ID })
+	i, _ := slices.BinarySearchFunc(msgs, msg, compareNewestFirst)
+	msgs = slices.Insert(msgs, i, msg)
+	if len(msgs) > maxSize {
+		msgs = msgs[:maxSize]
+	}
+	c.messages[key] = msgs
+	return nil
+}
+
+// UpdateMessage replaces the message in the cache if it is cached.
+func (c *Cache) UpdateMessage(_ context.Context, msg api.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg.AppID = orDefault(msg.AppID)
+	msgs := c.messages[channelKey{msg.AppID, msg.ChannelID}]
+	if i := slices.IndexFunc(msgs, func(m api.Message) bool { return m.ID == msg.ID }); i >= 0 {
+		msgs[i] = msg
+	}
+	return nil
+}
+
+// Clear removes all messages from the cache.
+func (c *Cache) Clear(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.messages)
+	return nil
+}
+
+// compareNewestFirst orders messages by creation time and id in descending
+// order.
+func compareNewestFirst(a, b api.Message) int {
+	ca, cb := a.Cursor(), b.Cursor()
+	switch {
+	case cb.Before(ca):
+		return -1
+	case ca.Before(cb):
+		return 1
+	}
+	return 0
+}
+
+// orDefault returns appID, or the id of the default app if it is empty.
+func orDefault(appID string) string {
+	if appID == "" {
+		return api.DefaultAppID
+	}
+	return appID
+}
+
+// now returns the current time with the precision of a Postgres timestamp.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// Bus delivers events within the process, for a single instance of the API.
+// It is safe for concurrent use.
+type Bus struct {
+	mu          sync.Mutex
+	nextSubID   int
+	lastEventID uint64
+	subs        map[int]func(api.Event) // by subscription id
+}
+
+// NewBus returns a Bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]func(api.Event))}
+}
+
+// Publish assigns e the next event id and calls the subscribers with it
+// before it returns. Events are delivered one at a time, in the order of
+// their ids.
+func (b *Bus) Publish(_ context.Context, e api.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastEventID++
+	e.ID = b.lastEventID
+	for _, fn := range b.subs {
+		fn(e)
+	}
+	return nil
+}
+
+// Subscribe calls fn for every event published until ctx is canceled.
+func (b *Bus) Subscribe(ctx context.Context, fn func(api.Event)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextSubID
+	b.nextSubID++
+	b.subs[id] = fn
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	})
+	return nil
+}
+
+// Limiter counts requests in token buckets in memory, for a single instance
+// of the API. It is safe for concurrent use.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // for tests
+}
+
+// A bucket holds the requests a key may make. It is full again at full.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// sweepInterval is the time between removals of the full buckets.
+const sweepInterval = time.Minute
+
+// NewLimiter returns a Limiter with empty buckets.
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a request from the bucket of key. Full buckets are removed
+// from time to time, since they are no different from new ones.
+func (l *Limiter) Allow(_ context.Context, key string, limit api.RateLimit) (api.RateLimitResult, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return api.RateLimitResult{}, fmt.Errorf("invalid rate limit %s", limit)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			if !now.Before(b.full) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	capacity := float64(limit.Requests)
+	interval := limit.Period / time.Duration(limit.Requests) // per request
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(capacity, b.tokens+float64(now.Sub(b.updated))/float64(interval))
+	b.updated = now
+
+	var res api.RateLimitResult
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((capacity - b.tokens) * float64(interval))
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// IdempotencyStore stores the responses to idempotent requests in memory,
+// for a single instance of the API. It is safe for concurrent use.
+type IdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]idempotentResponse
+	lastSweep time.Time
+	now       func() time.Time // for tests
+}
+
+// An idempotentResponse is a stored response and the time it expires.
+type idempotentResponse struct {
+	api.IdempotentResponse
+	expires time.Time
+}
+
+// NewIdempotencyStore returns an empty IdempotencyStore.
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{responses: make(map[string]idempotentResponse), now: time.Now}
+}
+
+// Reserve claims key for the request with the given fingerprint until ttl
+// passes, unless it is claimed already. Expired responses are removed from
+// time to time.
+func (s *IdempotencyStore) Reserve(_ context.Context, key, fingerprint string, ttl time.Duration) (api.IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, resp := range s.responses {
+			if !now.Before(resp.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if resp, ok := s.responses[key]; ok && now.Before(resp.expires) {
+		return resp.IdempotentResponse, false, nil
+	}
+	s.responses[key] = idempotentResponse{
+		IdempotentResponse: api.IdempotentResponse{Fingerprint: fingerprint},
+		expires:            now.Add(ttl),
+	}
+	return api.IdempotentResponse{}, true, nil
+}
+
+// Complete stores the response to the request that claimed key for ttl.
+func (s *IdempotencyStore) Complete(_ context.Context, key string, resp api.IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp.Header = resp.Header.Clone()
+	resp.Body = slices.Clone(resp.Body)
+	s.responses[key] = idempotentResponse{IdempotentResponse: resp, expires: s.now().Add(ttl)}
+	return nil
+}
+
+// Release frees key.
+func (s *IdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, key)
+	return nil
+}
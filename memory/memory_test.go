@@ -0,0 +1,288 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/GetStream/stream-backend-homework-assignment/storetest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDB_ListMessages(t *testing.T) {
+	// msgs are sorted newest first. The second and third message share a
+	// timestamp, so they are ordered by id.
+	msgs := []api.Message{
+		{ID: "d", AppID: api.DefaultAppID, Text: "four", UserID: "test", CreatedAt: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{ID: "c", AppID: api.DefaultAppID, Text: "three", UserID: "test", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: "b", AppID: api.DefaultAppID, Text: "two", UserID: "test", CreatedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: "a", AppID: api.DefaultAppID, Text: "one", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name    string
+		msgs    []api.Message
+		opts    api.ListOptions
+		exclude []string
+		want    []api.Message
+	}{
+		{
+			name: "Empty",
+			want: []api.Message{},
+		},
+		{
+			name: "All",
+			msgs: msgs,
+			want: msgs,
+		},
+		{
+			name: "Limit",
+			msgs: msgs,
+			opts: api.ListOptions{Limit: 2},
+			want: msgs[:2],
+		},
+		{
+			name: "Before",
+			msgs: msgs,
+			opts: api.ListOptions{Limit: 2, Before: &api.Cursor{CreatedAt: msgs[1].CreatedAt, ID: msgs[1].ID}},
+			want: msgs[2:4],
+		},
+		{
+			name: "After",
+			msgs: msgs,
+			opts: api.ListOptions{Limit: 2, After: &api.Cursor{CreatedAt: msgs[3].CreatedAt, ID: msgs[3].ID}},
+			want: msgs[1:3],
+		},
+		{
+			name:    "Exclude",
+			msgs:    msgs,
+			exclude: []string{"d", "b"},
+			want:    []api.Message{msgs[1], msgs[3]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB()
+			db.messages = append(db.messages, tt.msgs...)
+
+			got, err := db.ListMessages(context.Background(), tt.opts, tt.exclude...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("Diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDB_InsertMessage(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	first, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == "" {
+		t.Error("Returned message has empty ID")
+	}
+	if first.CreatedAt.IsZero() {
+		t.Error("Returned message does not have a CreatedAt field")
+	}
+	second, err := db.InsertMessage(ctx, api.Message{Text: "world", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.ListMessages(ctx, api.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []api.Message{second, first}); diff != "" {
+		t.Errorf("Diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestDB_InsertReaction(t *testing.T) {
+	ctx := context.Background()
+	db := NewDB()
+
+	_, _, err := db.InsertReaction(ctx, api.Reaction{MessageID: "missing", Type: "like", Score: 1, UserID: "test"}, api.ReactionOptions{})
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+	}
+
+	msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []api.Reaction{
+		{Type: "like", Score: 1, UserID: "a"},
+		{Type: "clap", Score: 5, UserID: "b"},
+		{Type: "clap", Score: 2, UserID: "c"},
+	} {
+		r.MessageID = msg.ID
+		got, _, err := db.InsertReaction(ctx, r, api.ReactionOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID == "" {
+			t.Error("Returned reaction has empty ID")
+		}
+	}
+
+	got, err := db.ReactionSummaries(ctx, api.DefaultAppID, []string{msg.ID, "missing"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, ok := got[msg.ID]
+	if !ok || len(got) != 1 {
+		t.Fatalf("Got summaries for %d messages, want 1", len(got))
+	}
+	if sum.Count != 3 || sum.TotalScore != 8 {
+		t.Errorf("Got count %d and total score %d, want 3 and 8", sum.Count, sum.TotalScore)
+	}
+	if diff := cmp.Diff(sum.Scores, map[string]int{"like": 1, "clap": 7}); diff != "" {
+		t.Errorf("Scores differ (-got +want)\n%s", diff)
+	}
+	if len(sum.Latest) != 2 {
+		t.Fatalf("Got %d latest reactions, want 2", len(sum.Latest))
+	}
+	if sum.Latest[0].CreatedAt.Before(sum.Latest[1].CreatedAt) {
+		t.Errorf("Latest reactions are not sorted newest first: %+v", sum.Latest)
+	}
+}
+
+func TestCache_InsertMessage_MaxSize(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache()
+
+	// Insert 11 items.
+	start := time.Now()
+	for i := 0; i <= maxSize; i++ {
+		msg := api.Message{
+			ID:        fmt.Sprintf("message-%d", i+1),
+			ChannelID: api.DefaultChannelID,
+			Text:      fmt.Sprintf("Message %d", i+1),
+			UserID:    "testuser",
+			CreatedAt: start.Add(time.Millisecond * time.Duration(i)),
+		}
+		if err := c.InsertMessage(ctx, msg); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	got, err := c.ListMessages(ctx, api.DefaultAppID, api.DefaultChannelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxSize {
+		t.Fatalf("Expected %d items in cache, got %d", maxSize, len(got))
+	}
+	for i, msg := range got {
+		// First message in the list should be #11, then #10, ..., the last one #2.
+		want := fmt.Sprintf("Message %d", maxSize+1-i)
+		if msg.Text != want {
+			t.Errorf("Cached message text does not match; got %q, want %q", msg.Text, want)
+		}
+	}
+}
+
+func TestConcurrentInserts(t *testing.T) {
+	ctx := context.Background()
+	db, c := NewDB(), NewCache()
+
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := db.InsertMessage(ctx, api.Message{Text: "hello", UserID: "test"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := c.InsertMessage(ctx, msg); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	msgs, err := db.ListMessages(ctx, api.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != n {
+		t.Errorf("Got %d messages, want %d", len(msgs), n)
+	}
+	cached, err := c.ListMessages(ctx, api.DefaultAppID, api.DefaultChannelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(cached, msgs[:maxSize]); diff != "" {
+		t.Errorf("Cache does not hold the latest messages (-got +want)\n%s", diff)
+	}
+}
+
+func TestDB_Conformance(t *testing.T) {
+	storetest.TestDB(t, func(t *testing.T) api.DB {
+		return NewDB()
+	})
+}
+
+func TestCache_Conformance(t *testing.T) {
+	storetest.TestCache(t, func(t *testing.T) api.Cache {
+		return NewCache()
+	})
+}
+
+func TestBus_Conformance(t *testing.T) {
+	storetest.TestBus(t, func(t *testing.T) api.EventBus {
+		return NewBus()
+	})
+}
+
+func TestLimiter_Conformance(t *testing.T) {
+	storetest.TestRateLimiter(t, func(t *testing.T) api.RateLimiter {
+		return NewLimiter()
+	})
+}
+
+func TestIdempotencyStore_Conformance(t *testing.T) {
+	storetest.TestIdempotencyStore(t, func(t *testing.T) api.IdempotencyStore {
+		return NewIdempotencyStore()
+	})
+}
+
+func TestLimiter_sweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter()
+	l.now = func() time.Time { return now }
+	limit := api.RateLimit{Requests: 2, Period: time.Hour}
+	for _, key := range []string{"alice", "bob", "bob"} {
+		if _, err := l.Allow(context.Background(), key, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// After half an hour, the bucket of alice is full again and removed,
+	// while bob still has a request to make up for.
+	now = now.Add(30 * time.Minute)
+	if _, err := l.Allow(context.Background(), "carol", limit); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.buckets["alice"]; ok {
+		t.Error("The full bucket was kept")
+	}
+	if _, ok := l.buckets["bob"]; !ok {
+		t.Error("The bucket that is not full was removed")
+	}
+}
@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/uptrace/bun"
+)
+
+// keyPrefix marks API keys, so that leaked keys are easy to recognize.
+const keyPrefix = "sk_"
+
+// CreateApp creates an app and its first API key. The key is returned only
+// here, since only its hash is stored.
+func (pg *Postgres) CreateApp(ctx context.Context, name string) (_ api.App, key string, err error) {
+	a := &app{Name: name}
+	err = pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(a).Returning("*").Exec(ctx); err != nil {
+			return fmt.Errorf("insert app: %w", err)
+		}
+		key, err = insertKey(ctx, tx, a.ID)
+		return err
+	})
+	if err != nil {
+		return api.App{}, "", wrapErr(err)
+	}
+	return a.APIApp(), key, nil
+}
+
+// RotateKey creates a new API key for the app. The keys the app had before
+// stay valid for the grace period, so that clients can switch over, and are
+// revoked after it. It returns api.ErrNotFound if the app does not exist.
+func (pg *Postgres) RotateKey(ctx context.Context, appID string, grace time.Duration) (key string, err error) {
+	err = pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := checkApp(ctx, tx, appID); err != nil {
+			return err
+		}
+		_, err := tx.NewUpdate().
+			Model((*apiKey)(nil)).
+			Set("revoked_at = now() + make_interval(secs => ?)", grace.Seconds()).
+			Where("app_id = ?", appID).
+			Where("(revoked_at IS NULL OR revoked_at > now() + make_interval(secs => ?))", grace.Seconds()).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("revoke keys: %w", err)
+		}
+		key, err = insertKey(ctx, tx, appID)
+		return err
+	})
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return key, nil
+}
+
+// RevokeKeys revokes all API keys of the app immediately and returns how
+// many were valid. It returns api.ErrNotFound if the app does not exist.
+func (pg *Postgres) RevokeKeys(ctx context.Context, appID string) (int, error) {
+	var n int64
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := checkApp(ctx, tx, appID); err != nil {
+			return err
+		}
+		res, err := tx.NewUpdate().
+			Model((*apiKey)(nil)).
+			Set("revoked_at = now()").
+			Where("app_id = ?", appID).
+			Where("(revoked_at IS NULL OR revoked_at > now())").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("revoke keys: %w", err)
+		}
+		n, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return int(n), nil
+}
+
+// AppByKey returns the app of an API key. It returns api.ErrNotFound if the
+// key does not exist or was revoked.
+func (pg *Postgres) AppByKey(ctx context.Context, key string) (api.App, error) {
+	var a app
+	err := pg.bun.NewSelect().
+		Model(&a).
+		Join("JOIN api_keys AS k ON k.app_id = app.id").
+		Where("k.key_hash = ?", hashKey(key)).
+		Where("(k.revoked_at IS NULL OR k.revoked_at > now())").
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.App{}, fmt.Errorf("api key: %w", api.ErrNotFound)
+	}
+	if err != nil {
+		return api.App{}, fmt.Errorf("select: %w", wrapErr(err))
+	}
+	return a.APIApp(), nil
+}
+
+// checkApp returns api.ErrNotFound if the app does not exist.
+func checkApp(ctx context.Context, tx bun.Tx, appID string) error {
+	exists, err := tx.NewSelect().Model((*app)(nil)).Where("id = ?", appID).Exists(ctx)
+	if isNotFound(err) || err == nil && !exists {
+		return fmt.Errorf("app %s: %w", appID, api.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("select app: %w", err)
+	}
+	return nil
+}
+
+// insertKey generates an API key for the app and stores its hash.
+func insertKey(ctx context.Context, tx bun.Tx, appID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	if _, err := tx.NewInsert().Model(&apiKey{KeyHash: hashKey(key), AppID: appID}).Exec(ctx); err != nil {
+		return "", fmt.Errorf("insert key: %w", err)
+	}
+	return key, nil
+}
+
+// hashKey returns the SHA-256 hash of a key. Keys are random, so they do not
+// need a salt or a slow hash.
+func hashKey(key string) []byte {
+	h := sha256.Sum256([]byte(key))
+	return h[:]
+}
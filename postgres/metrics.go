@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/uptrace/bun"
+)
+
+// Instrument registers query latency and error metrics, and the connection
+// pool stats, with reg. It must be called before the database is used.
+func (pg *Postgres) Instrument(reg prometheus.Registerer) {
+	h := &metricsHook{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "postgres_query_duration_seconds",
+			Help:    "Latency of PostgreSQL queries by operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "postgres_query_errors_total",
+			Help: "Number of failed PostgreSQL queries by operation.",
+		}, []string{"operation"}),
+	}
+	reg.MustRegister(h.duration, h.errors, collectors.NewDBStatsCollector(pg.bun.DB, "postgres"))
+	pg.bun.AddQueryHook(h)
+}
+
+// metricsHook is a bun.QueryHook that records query metrics.
+type metricsHook struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func (h *metricsHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context {
+	return ctx
+}
+
+func (h *metricsHook) AfterQuery(_ context.Context, e *bun.QueryEvent) {
+	op := e.Operation()
+	h.duration.WithLabelValues(op).Observe(time.Since(e.StartTime).Seconds())
+	if e.Err != nil && !errors.Is(e.Err, sql.ErrNoRows) {
+		h.errors.WithLabelValues(op).Inc()
+	}
+}
@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the advisory lock that serializes migrations
+// across processes.
+const migrationLockID = 7265783901
+
+var (
+	migrationFileRE = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	migrationNameRE = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// A Migration is a versioned change to the database schema.
+type Migration struct {
+	Version   int
+	Name      string
+	Up        string
+	Down      string
+	AppliedAt time.Time // zero if the migration is pending
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// schemaMigration records an applied migration.
+type schemaMigration struct {
+	bun.BaseModel `bun:"table:schema_migrations"`
+
+	Version   int       `bun:",pk"`
+	Name      string    `bun:",notnull"`
+	AppliedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// Migrations returns the migrations embedded in the binary, sorted by
+// version.
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(sub)
+}
+
+// loadMigrations reads the migrations in fsys. Every migration consists of a
+// <version>_<name>.up.sql and a <version>_<name>.down.sql file.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	var (
+		byVersion = make(map[int]*Migration)
+		files     = make(map[string]bool)
+	)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := migrationFileRE.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration: %w", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, match[2])
+		}
+		files[e.Name()] = true
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if !files[m.String()+".up.sql"] || !files[m.String()+".down.sql"] {
+			return nil, fmt.Errorf("migration %s must have an up and a down file", m)
+		}
+		out = append(out, *m)
+	}
+	slices.SortFunc(out, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return out, nil
+}
+
+// MigrationStatus returns all known migrations, sorted by version. Applied
+// migrations have their AppliedAt field set. Migrations that are applied to
+// the database but unknown to the binary are included without Up and Down.
+func (pg *Postgres) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := pg.createMigrationsTable(ctx, pg.bun); err != nil {
+		return nil, err
+	}
+	var applied []schemaMigration
+	if err := pg.bun.NewSelect().Model(&applied).Scan(ctx); err != nil {
+		return nil, fmt.Errorf("scan migrations: %w", wrapErr(err))
+	}
+	for _, a := range applied {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == a.Version })
+		if i < 0 {
+			migrations = append(migrations, Migration{Version: a.Version, Name: a.Name, AppliedAt: a.AppliedAt})
+			continue
+		}
+		migrations[i].AppliedAt = a.AppliedAt
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Migrate applies all pending migrations in order and returns them. Each
+// migration runs in its own transaction, so a failed migration leaves the
+// schema at the previous version. Concurrent calls, also from other
+// processes, apply every migration once.
+func (pg *Postgres) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range migrations {
+		var ok bool
+		err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := pg.lockMigrations(ctx, tx); err != nil {
+				return err
+			}
+			exists, err := tx.NewSelect().Model((*schemaMigration)(nil)).Where("version = ?", m.Version).Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("check migration: %w", err)
+			}
+			if exists {
+				return nil
+			}
+			// The SQL is run as is, without bun replacing placeholders.
+			if _, err := tx.Tx.ExecContext(ctx, m.Up); err != nil {
+				return err
+			}
+			rec := &schemaMigration{Version: m.Version, Name: m.Name}
+			if _, err := tx.NewInsert().Model(rec).Returning("applied_at").Exec(ctx); err != nil {
+				return fmt.Errorf("record migration: %w", err)
+			}
+			m.AppliedAt, ok = rec.AppliedAt, true
+			return nil
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", m, wrapErr(err))
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// Rollback reverts the latest n applied migrations, newest first, and
+// returns them.
+func (pg *Postgres) Rollback(ctx context.Context, n int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for range n {
+		var (
+			m  Migration
+			ok bool
+		)
+		err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := pg.lockMigrations(ctx, tx); err != nil {
+				return err
+			}
+			var latest schemaMigration
+			err := tx.NewSelect().Model(&latest).Order("version DESC").Limit(1).Scan(ctx)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("scan migrations: %w", err)
+			}
+			i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == latest.Version })
+			if i < 0 {
+				return fmt.Errorf("migration %d_%s is applied but unknown", latest.Version, latest.Name)
+			}
+			m = migrations[i]
+			if _, err := tx.Tx.ExecContext(ctx, m.Down); err != nil {
+				return fmt.Errorf("migration %s: %w", m, err)
+			}
+			if _, err := tx.NewDelete().Model(&latest).WherePK().Exec(ctx); err != nil {
+				return fmt.Errorf("remove migration: %w", err)
+			}
+			ok = true
+			return nil
+		})
+		if err != nil {
+			return reverted, wrapErr(err)
+		}
+		if !ok {
+			break
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// lockMigrations creates the migrations table if needed and takes a lock
+// that is held until the transaction ends.
+func (pg *Postgres) lockMigrations(ctx context.Context, tx bun.Tx) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", migrationLockID); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	return pg.createMigrationsTable(ctx, tx)
+}
+
+func (pg *Postgres) createMigrationsTable(ctx context.Context, db bun.IDB) error {
+	_, err := db.NewCreateTable().Model((*schemaMigration)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", wrapErr(err))
+	}
+	return nil
+}
+
+// CreateMigration writes empty up and down files for a new migration to dir
+// and returns their paths. The version follows the latest migration in dir.
+func CreateMigration(dir, name string) (up, down string, err error) {
+	if !migrationNameRE.MatchString(name) {
+		return "", "", fmt.Errorf("invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+	migrations, err := loadMigrations(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := 1
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version + 1
+	}
+	m := Migration{Version: version, Name: name}
+	up = filepath.Join(dir, m.String()+".up.sql")
+	down = filepath.Join(dir, m.String()+".down.sql")
+	for _, path := range []string{up, down} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			return "", "", fmt.Errorf("create migration: %w", err)
+		}
+	}
+	return up, down, nil
+}
@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("No migrations are embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration %s has version %d, want %d", m, m.Version, i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("Migration %s is empty", m)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name:  "Sorted",
+			files: []string{"0010_c.up.sql", "0010_c.down.sql", "0002_b.down.sql", "0002_b.up.sql", "0001_a.up.sql", "0001_a.down.sql"},
+			want:  []string{"0001_a", "0002_b", "0010_c"},
+		},
+		{
+			name:    "MissingDown",
+			files:   []string{"0001_a.up.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidName",
+			files:   []string{"0001_a.up.sql", "0001_a.down.sql", "schema.sql"},
+			wantErr: true,
+		},
+		{
+			name:    "DuplicateVersion",
+			files:   []string{"0001_a.up.sql", "0001_a.down.sql", "0001_b.up.sql", "0001_b.down.sql"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := make(fstest.MapFS)
+			for _, name := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			migrations, err := loadMigrations(fsys)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(migrations) != len(tt.want) {
+				t.Fatalf("Got %d migrations, want %d", len(migrations), len(tt.want))
+			}
+			for i, m := range migrations {
+				if m.String() != tt.want[i] {
+					t.Errorf("Migration %d is %s, want %s", i, m, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, down, err := CreateMigration(dir, "add_column")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "0002_add_column.up.sql"); up != want {
+		t.Errorf("Got up file %s, want %s", up, want)
+	}
+	if want := filepath.Join(dir, "0002_add_column.down.sql"); down != want {
+		t.Errorf("Got down file %s, want %s", down, want)
+	}
+	migrations, err := loadMigrations(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Errorf("Got %d migrations, want 2", len(migrations))
+	}
+
+	if _, _, err := CreateMigration(dir, "Add Column"); err == nil {
+		t.Error("Expected an error for an invalid name")
+	}
+}
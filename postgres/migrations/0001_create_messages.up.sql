@@ -1,6 +1,5 @@
--- Add your db schema creation SQL for reactions here
-
--- Messages
+-- The messages table as it was first created by the docker entrypoint, so
+-- that existing databases can be migrated from here.
 CREATE TABLE IF NOT EXISTS messages (
   id uuid DEFAULT gen_random_uuid(),
   message_text TEXT NOT NULL,
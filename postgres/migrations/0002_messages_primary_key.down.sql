@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS messages_created_at_id_idx;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_pkey;
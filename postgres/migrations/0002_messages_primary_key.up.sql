@@ -0,0 +1,15 @@
+-- Ids are generated by default, so only rows inserted with an explicit NULL
+-- id are missing one.
+UPDATE messages SET id = gen_random_uuid() WHERE id IS NULL;
+
+DO $$
+BEGIN
+  IF NOT EXISTS (
+    SELECT 1 FROM pg_constraint WHERE conrelid = 'messages'::regclass AND contype = 'p'
+  ) THEN
+    ALTER TABLE messages ADD CONSTRAINT messages_pkey PRIMARY KEY (id);
+  END IF;
+END $$;
+
+-- Messages are paginated by (created_at, id), newest first.
+CREATE INDEX IF NOT EXISTS messages_created_at_id_idx ON messages (created_at DESC, id DESC);
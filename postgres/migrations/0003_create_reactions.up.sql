@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS reactions (
+  id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+  message_id uuid NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+  reaction_type VARCHAR(255) NOT NULL,
+  score INTEGER NOT NULL DEFAULT 1 CHECK (score > 0),
+  user_id VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Reactions are always looked up by message, newest first.
+CREATE INDEX IF NOT EXISTS reactions_message_id_created_at_idx ON reactions (message_id, created_at DESC, id DESC);
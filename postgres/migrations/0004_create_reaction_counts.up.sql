@@ -0,0 +1,15 @@
+-- Reaction counts are maintained on insert so that listing messages does not
+-- have to aggregate all of their reactions.
+CREATE TABLE IF NOT EXISTS reaction_counts (
+  message_id uuid NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+  reaction_type VARCHAR(255) NOT NULL,
+  count INTEGER NOT NULL DEFAULT 0,
+  score BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (message_id, reaction_type)
+);
+
+INSERT INTO reaction_counts (message_id, reaction_type, count, score)
+SELECT message_id, reaction_type, count(*), sum(score)
+FROM reactions
+GROUP BY message_id, reaction_type
+ON CONFLICT DO NOTHING;
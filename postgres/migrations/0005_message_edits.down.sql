@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS message_history;
+
+ALTER TABLE messages
+  DROP COLUMN IF EXISTS updated_at,
+  DROP COLUMN IF EXISTS version;
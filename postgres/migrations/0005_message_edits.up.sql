@@ -0,0 +1,14 @@
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP,
+  ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+-- Prior versions of edited messages. A version is written when it is
+-- replaced, so unedited messages have no history.
+CREATE TABLE IF NOT EXISTS message_history (
+  message_id uuid NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+  version INTEGER NOT NULL,
+  message_text TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  replaced_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (message_id, version)
+);
@@ -0,0 +1,2 @@
+-- Merged duplicates are not split up again.
+DROP INDEX IF EXISTS reactions_message_id_user_id_reaction_type_idx;
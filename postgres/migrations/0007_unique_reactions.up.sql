@@ -0,0 +1,29 @@
+-- A user holds at most one reaction of each type to a message. Duplicates
+-- are merged into the oldest reaction, keeping their total score.
+WITH ranked AS (
+  SELECT
+    id,
+    first_value(id) OVER (
+      PARTITION BY message_id, user_id, reaction_type
+      ORDER BY created_at, id
+    ) AS keep_id,
+    sum(score) OVER (PARTITION BY message_id, user_id, reaction_type) AS total
+  FROM reactions
+), merged AS (
+  UPDATE reactions r
+  SET score = ranked.total
+  FROM ranked
+  WHERE r.id = ranked.id AND ranked.id = ranked.keep_id AND r.score <> ranked.total
+)
+DELETE FROM reactions r
+USING ranked
+WHERE r.id = ranked.id AND ranked.id <> ranked.keep_id;
+
+-- Merging changed the number of reactions per type.
+DELETE FROM reaction_counts;
+INSERT INTO reaction_counts (message_id, reaction_type, count, score)
+SELECT message_id, reaction_type, count(*), sum(score)
+FROM reactions
+GROUP BY message_id, reaction_type;
+
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_message_id_user_id_reaction_type_idx ON reactions (message_id, user_id, reaction_type);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messages_channel_id_created_at_idx;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS channel_id;
+
+DROP TABLE IF EXISTS channels;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS channels (
+  id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Existing messages, and the messages posted to the routes that are not
+-- scoped to a channel, belong to the default channel. Its id matches
+-- api.DefaultChannelID.
+INSERT INTO channels (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'general')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS channel_id uuid NOT NULL
+  DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES channels (id);
+
+-- Messages are listed by channel, newest first.
+CREATE INDEX IF NOT EXISTS messages_channel_id_created_at_idx ON messages (channel_id, created_at DESC, id DESC);
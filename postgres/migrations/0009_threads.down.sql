@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS messages_parent_id_created_at_idx;
+
+ALTER TABLE messages
+  DROP COLUMN IF EXISTS latest_reply_at,
+  DROP COLUMN IF EXISTS reply_count,
+  DROP COLUMN IF EXISTS show_in_channel,
+  DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,8 @@
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS parent_id uuid REFERENCES messages (id) ON DELETE CASCADE,
+  ADD COLUMN IF NOT EXISTS show_in_channel BOOLEAN NOT NULL DEFAULT false,
+  ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS latest_reply_at TIMESTAMP;
+
+-- Replies are listed by thread, newest first.
+CREATE INDEX IF NOT EXISTS messages_parent_id_created_at_idx ON messages (parent_id, created_at DESC, id DESC) WHERE parent_id IS NOT NULL;
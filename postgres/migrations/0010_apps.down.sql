@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS messages_app_id_channel_id_created_at_idx;
+CREATE INDEX IF NOT EXISTS messages_channel_id_created_at_idx ON messages (channel_id, created_at DESC, id DESC);
+
+ALTER TABLE reactions DROP COLUMN IF EXISTS app_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS app_id;
+ALTER TABLE channels DROP COLUMN IF EXISTS app_id;
+
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS apps;
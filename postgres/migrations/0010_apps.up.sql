@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS apps (
+  id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Existing data, and the requests of deployments without API keys, belong
+-- to the default app. Its id matches api.DefaultAppID.
+INSERT INTO apps (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'default')
+ON CONFLICT DO NOTHING;
+
+-- Only the SHA-256 hashes of the keys are stored. Rotated keys stay valid
+-- until they are revoked.
+CREATE TABLE IF NOT EXISTS api_keys (
+  key_hash BYTEA PRIMARY KEY,
+  app_id uuid NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_app_id_idx ON api_keys (app_id);
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS app_id uuid NOT NULL
+  DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES apps (id);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS app_id uuid NOT NULL
+  DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES apps (id);
+ALTER TABLE reactions ADD COLUMN IF NOT EXISTS app_id uuid NOT NULL
+  DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES apps (id);
+
+-- Messages are listed by app and channel, newest first.
+DROP INDEX IF EXISTS messages_channel_id_created_at_idx;
+CREATE INDEX IF NOT EXISTS messages_app_id_channel_id_created_at_idx ON messages (app_id, channel_id, created_at DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS reactions_message_id_created_at_id_idx;
+CREATE INDEX IF NOT EXISTS reactions_message_id_created_at_idx ON reactions (message_id, created_at DESC, id DESC);
@@ -0,0 +1,5 @@
+-- Databases created from the old schema.sql have an index of the same name
+-- without id, which 0003 left in place. Reactions are listed by
+-- (created_at, id), so the index is replaced under a name of its own.
+DROP INDEX IF EXISTS reactions_message_id_created_at_idx;
+CREATE INDEX IF NOT EXISTS reactions_message_id_created_at_id_idx ON reactions (message_id, created_at DESC, id DESC);
@@ -4,21 +4,138 @@ import (
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/uptrace/bun"
 )
 
 // A message represents a message in the database.
 type message struct {
 	ID          string    `bun:",pk,type:uuid,default:uuid_generate_v4()"`
+	AppID       string    `bun:",type:uuid,nullzero,notnull"`
+	ChannelID   string    `bun:",type:uuid,nullzero,notnull"`
 	MessageText string    `bun:"message_text,notnull"`
 	UserID      string    `bun:",notnull"`
 	CreatedAt   time.Time `bun:",nullzero,default:now()"`
+	UpdatedAt   time.Time `bun:",nullzero"`
+	Version     int       `bun:",nullzero,notnull,default:1"`
+	// DeletedAt is set when the message is soft deleted. It is not a bun
+	// soft_delete column, since deleted messages are listed to moderators.
+	DeletedAt     time.Time `bun:",nullzero"`
+	ParentID      string    `bun:",type:uuid,nullzero"`
+	ShowInChannel bool      `bun:",notnull"`
+	ReplyCount    int       `bun:",notnull"`
+	LatestReplyAt time.Time `bun:",nullzero"`
 }
 
 func (m message) APIMessage() api.Message {
 	return api.Message{
 		ID:        m.ID,
+		AppID:     m.AppID,
+		ChannelID: m.ChannelID,
 		Text:      m.MessageText,
 		UserID:    m.UserID,
 		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+		Version:   m.Version,
+		DeletedAt: m.DeletedAt,
+
+		ParentID:      m.ParentID,
+		ShowInChannel: m.ShowInChannel,
+		ReplyCount:    m.ReplyCount,
+		LatestReplyAt: m.LatestReplyAt,
+	}
+}
+
+// A channel represents a channel in the database.
+type channel struct {
+	ID        string    `bun:",pk,type:uuid,default:gen_random_uuid()"`
+	AppID     string    `bun:",type:uuid,nullzero,notnull"`
+	Name      string    `bun:",notnull"`
+	CreatedAt time.Time `bun:",nullzero,default:now()"`
+}
+
+func (c channel) APIChannel() api.Channel {
+	return api.Channel{
+		ID:        c.ID,
+		AppID:     c.AppID,
+		Name:      c.Name,
+		CreatedAt: c.CreatedAt,
 	}
 }
+
+// A messageVersion is a prior version of an edited message.
+type messageVersion struct {
+	bun.BaseModel `bun:"table:message_history,alias:mh"`
+
+	MessageID   string    `bun:",pk,type:uuid"`
+	Version     int       `bun:",pk"`
+	MessageText string    `bun:"message_text,notnull"`
+	CreatedAt   time.Time `bun:",notnull"`
+	ReplacedAt  time.Time `bun:",notnull"`
+}
+
+func (v messageVersion) APIMessageVersion() api.MessageVersion {
+	return api.MessageVersion{
+		Version:    v.Version,
+		Text:       v.MessageText,
+		CreatedAt:  v.CreatedAt,
+		ReplacedAt: v.ReplacedAt,
+	}
+}
+
+// A reaction represents a reaction to a message in the database.
+type reaction struct {
+	ID           string    `bun:",pk,type:uuid,default:gen_random_uuid()"`
+	AppID        string    `bun:",type:uuid,nullzero,notnull"`
+	MessageID    string    `bun:",type:uuid,notnull"`
+	ReactionType string    `bun:"reaction_type,notnull"`
+	Score        int       `bun:",notnull,default:1"`
+	UserID       string    `bun:",notnull"`
+	CreatedAt    time.Time `bun:",nullzero,default:now()"`
+}
+
+func (r reaction) APIReaction() api.Reaction {
+	return api.Reaction{
+		ID:        r.ID,
+		AppID:     r.AppID,
+		MessageID: r.MessageID,
+		Type:      r.ReactionType,
+		Score:     r.Score,
+		UserID:    r.UserID,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+// A reactionCount holds the aggregated reactions of one type to a message.
+type reactionCount struct {
+	bun.BaseModel `bun:"table:reaction_counts,alias:rc"`
+
+	MessageID    string `bun:",pk,type:uuid"`
+	ReactionType string `bun:",pk"`
+	Count        int    `bun:",notnull"`
+	Score        int    `bun:",notnull"`
+}
+
+// An app represents an app in the database.
+type app struct {
+	ID        string    `bun:",pk,type:uuid,default:gen_random_uuid()"`
+	Name      string    `bun:",notnull"`
+	CreatedAt time.Time `bun:",nullzero,default:now()"`
+}
+
+func (a app) APIApp() api.App {
+	return api.App{
+		ID:        a.ID,
+		Name:      a.Name,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+// An apiKey is the hash of a key that identifies an app.
+type apiKey struct {
+	bun.BaseModel `bun:"table:api_keys,alias:k"`
+
+	KeyHash   []byte    `bun:",pk"`
+	AppID     string    `bun:",type:uuid,notnull"`
+	CreatedAt time.Time `bun:",nullzero,default:now()"`
+	RevokedAt time.Time `bun:",nullzero"`
+}
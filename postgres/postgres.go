@@ -3,12 +3,21 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/extra/bunotel"
 )
 
 // Postgres provides storage in PostgreSQL.
@@ -16,31 +25,78 @@ type Postgres struct {
 	bun *bun.DB
 }
 
+// New returns a client for the database at connStr without connecting to
+// it. Connections are opened when they are needed, so the database may come
+// up later.
+func New(connStr string) *Postgres {
+	connector := pgdriver.NewConnector(pgdriver.WithDSN(connStr))
+	db := bun.NewDB(sql.OpenDB(connector), pgdialect.New())
+	// Queries are traced with the global tracer provider. Their arguments
+	// are left out of the spans.
+	db.AddQueryHook(bunotel.NewQueryHook(bunotel.WithDBName(connector.Config().Database)))
+	return &Postgres{
+		bun: db,
+	}
+}
+
 // Connect connects to the database and ping the DB to ensure the connection is
 // working.
 func Connect(ctx context.Context, connStr string) (*Postgres, error) {
-	sqlDB := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(connStr)))
-	if err := sqlDB.PingContext(ctx); err != nil {
+	pg := New(connStr)
+	if err := pg.bun.PingContext(ctx); err != nil {
+		pg.bun.Close()
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
-	db := bun.NewDB(sqlDB, pgdialect.New())
-	return &Postgres{
-		bun: db,
-	}, nil
+	return pg, nil
 }
 
-// ListMessages returns all messages in the database.
-func (pg *Postgres) ListMessages(ctx context.Context, excludeMsgIDs ...string) ([]api.Message, error) {
+// Ping checks that the database is reachable.
+func (pg *Postgres) Ping(ctx context.Context) error {
+	if err := pg.bun.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping database: %w", wrapErr(err))
+	}
+	return nil
+}
+
+// ListMessages returns the messages in the database selected by opts, sorted
+// by creation time and id in descending order.
+func (pg *Postgres) ListMessages(ctx context.Context, opts api.ListOptions, excludeMsgIDs ...string) ([]api.Message, error) {
 	var msgs []message
-	q := pg.bun.NewSelect().
-		Model(&msgs).
-		Order("created_at DESC")
+	q := pg.bun.NewSelect().Model(&msgs).Where("app_id = ?", appOrDefault(opts.AppID))
 
+	if opts.ChannelID != "" {
+		q = q.Where("channel_id = ?", opts.ChannelID)
+	}
+	if opts.ParentID != "" {
+		q = q.Where("parent_id = ?", opts.ParentID)
+	} else {
+		q = q.Where("(parent_id IS NULL OR show_in_channel)")
+	}
+	if opts.Before != nil {
+		q = q.Where("(created_at, id) < (?, ?)", opts.Before.CreatedAt, opts.Before.ID)
+	}
+	if opts.After != nil {
+		// Select the messages closest to the cursor by scanning in ascending
+		// order. The result is reversed below.
+		q = q.Where("(created_at, id) > (?, ?)", opts.After.CreatedAt, opts.After.ID).
+			Order("created_at ASC", "id ASC")
+	} else {
+		q = q.Order("created_at DESC", "id DESC")
+	}
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
 	if len(excludeMsgIDs) > 0 {
 		q = q.Where("id NOT IN (?)", bun.In(excludeMsgIDs))
 	}
+	if !opts.IncludeDeleted {
+		q = q.Where("deleted_at IS NULL")
+	}
 	if err := q.Scan(ctx); err != nil {
-		return nil, fmt.Errorf("scan: %w", err)
+		return nil, fmt.Errorf("scan: %w", wrapErr(err))
+	}
+	if opts.After != nil {
+		slices.Reverse(msgs)
 	}
 	out := make([]api.Message, len(msgs))
 	for i, m := range msgs {
@@ -49,19 +105,676 @@ func (pg *Postgres) ListMessages(ctx context.Context, excludeMsgIDs ...string) (
 	return out, nil
 }
 
-// InsertMessage inserts a message into the database. The returned message
-// holds auto generated fields, such as the message id.
+// InsertMessage inserts a message into the database. The id and creation time
+// are generated unless they are set on msg, and the message is posted to the
+// default channel unless msg.ChannelID is set. A reply is added to the reply
+// count of its parent in the same transaction. The returned message holds
+// the generated fields. If the channel or the parent does not exist in the
+// app of the message, or the parent was deleted, api.ErrNotFound is
+// returned. If a message with the same id exists, api.ErrConflict is
+// returned.
 func (pg *Postgres) InsertMessage(ctx context.Context, msg api.Message) (api.Message, error) {
+	if msg.ChannelID == "" {
+		msg.ChannelID = api.DefaultChannelID
+	}
 	m := &message{
+		ID:          msg.ID,
+		AppID:       appOrDefault(msg.AppID),
+		ChannelID:   msg.ChannelID,
 		MessageText: msg.Text,
 		UserID:      msg.UserID,
+		CreatedAt:   msg.CreatedAt,
+		Version:     msg.Version,
+
+		ParentID:      msg.ParentID,
+		ShowInChannel: msg.ShowInChannel,
+	}
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// The foreign key only checks that the channel exists in any app.
+		if m.ChannelID != api.DefaultChannelID {
+			exists, err := tx.NewSelect().
+				Model((*channel)(nil)).
+				Where("id = ?", m.ChannelID).
+				Where("app_id = ?", m.AppID).
+				Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("select channel: %w", err)
+			}
+			if !exists {
+				return fmt.Errorf("channel %s: %w", m.ChannelID, api.ErrNotFound)
+			}
+		}
+		if _, err := tx.NewInsert().Model(m).Exec(ctx); err != nil {
+			return err
+		}
+		if m.ParentID == "" {
+			return nil
+		}
+		res, err := tx.NewUpdate().
+			Model((*message)(nil)).
+			Set("reply_count = reply_count + 1").
+			Set("latest_reply_at = GREATEST(latest_reply_at, ?)", m.CreatedAt).
+			Where("id = ?", m.ParentID).
+			Where("app_id = ?", m.AppID).
+			Where("deleted_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update parent: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return fmt.Errorf("message %s: %w", m.ParentID, api.ErrNotFound)
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		return m.APIMessage(), nil
+	case errors.Is(err, api.ErrNotFound):
+		return api.Message{}, err
+	case isConflict(err):
+		return api.Message{}, fmt.Errorf("message %s: %w", msg.ID, api.ErrConflict)
+	case isNotFound(err) && m.ParentID != "":
+		return api.Message{}, fmt.Errorf("channel %s or message %s: %w", msg.ChannelID, m.ParentID, api.ErrNotFound)
+	case isNotFound(err):
+		return api.Message{}, fmt.Errorf("channel %s: %w", msg.ChannelID, api.ErrNotFound)
+	}
+	return api.Message{}, fmt.Errorf("insert: %w", wrapErr(err))
+}
+
+// GetMessage returns the message of the app with the given id, including a
+// deleted one. It returns api.ErrNotFound if the message does not exist.
+func (pg *Postgres) GetMessage(ctx context.Context, appID, msgID string) (api.Message, error) {
+	var m message
+	err := pg.bun.NewSelect().
+		Model(&m).
+		Where("id = ?", msgID).
+		Where("app_id = ?", appOrDefault(appID)).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) || isNotFound(err) {
+		return api.Message{}, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	if err != nil {
+		return api.Message{}, fmt.Errorf("select: %w", wrapErr(err))
+	}
+	return m.APIMessage(), nil
+}
+
+// InsertChannel inserts a channel into the database. The id and creation
+// time are generated unless they are set on ch. If a channel with the same
+// id exists, api.ErrConflict is returned, and if the app does not exist,
+// api.ErrNotFound.
+func (pg *Postgres) InsertChannel(ctx context.Context, ch api.Channel) (api.Channel, error) {
+	c := &channel{
+		ID:        ch.ID,
+		AppID:     appOrDefault(ch.AppID),
+		Name:      ch.Name,
+		CreatedAt: ch.CreatedAt,
+	}
+	if _, err := pg.bun.NewInsert().Model(c).Exec(ctx); err != nil {
+		if isConflict(err) {
+			return api.Channel{}, fmt.Errorf("channel %s: %w", ch.ID, api.ErrConflict)
+		}
+		if isNotFound(err) {
+			return api.Channel{}, fmt.Errorf("app %s: %w", c.AppID, api.ErrNotFound)
+		}
+		return api.Channel{}, fmt.Errorf("insert: %w", wrapErr(err))
+	}
+	return c.APIChannel(), nil
+}
+
+// GetChannel returns the channel of the app with the given id. The default
+// channel belongs to every app. It returns api.ErrNotFound if the channel
+// does not exist.
+func (pg *Postgres) GetChannel(ctx context.Context, appID, channelID string) (api.Channel, error) {
+	var c channel
+	err := pg.bun.NewSelect().
+		Model(&c).
+		Where("id = ?", channelID).
+		Where("(app_id = ? OR id = ?)", appOrDefault(appID), api.DefaultChannelID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) || isNotFound(err) {
+		return api.Channel{}, fmt.Errorf("channel %s: %w", channelID, api.ErrNotFound)
+	}
+	if err != nil {
+		return api.Channel{}, fmt.Errorf("select: %w", wrapErr(err))
+	}
+	return c.APIChannel(), nil
+}
+
+// UpdateMessage changes the text of a message and keeps the replaced text in
+// the message history. It returns api.ErrNotFound if the message does not
+// exist or was deleted, api.ErrForbidden if upd.UserID is not its author and
+// api.ErrVersionMismatch if upd.IfVersion is set and differs from its
+// version.
+func (pg *Postgres) UpdateMessage(ctx context.Context, upd api.MessageUpdate) (api.Message, error) {
+	var m message
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Lock the row so that concurrent edits are applied one by one.
+		err := tx.NewSelect().
+			Model(&m).
+			Where("id = ?", upd.ID).
+			Where("app_id = ?", appOrDefault(upd.AppID)).
+			Where("deleted_at IS NULL").
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message %s: %w", upd.ID, api.ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("select: %w", err)
+		}
+		if m.UserID != upd.UserID {
+			return fmt.Errorf("message %s: %w", upd.ID, api.ErrForbidden)
+		}
+		if upd.IfVersion != 0 && upd.IfVersion != m.Version {
+			return fmt.Errorf("message %s has version %d: %w", upd.ID, m.Version, api.ErrVersionMismatch)
+		}
+		if m.MessageText == upd.Text {
+			return nil
+		}
+
+		v := &messageVersion{
+			MessageID:   m.ID,
+			Version:     m.Version,
+			MessageText: m.MessageText,
+			CreatedAt:   m.CreatedAt,
+			ReplacedAt:  upd.UpdatedAt,
+		}
+		if !m.UpdatedAt.IsZero() {
+			v.CreatedAt = m.UpdatedAt
+		}
+		if _, err := tx.NewInsert().Model(v).Exec(ctx); err != nil {
+			return fmt.Errorf("insert history: %w", err)
+		}
+		m.MessageText = upd.Text
+		m.UpdatedAt = upd.UpdatedAt
+		m.Version++
+		_, err = tx.NewUpdate().
+			Model(&m).
+			Column("message_text", "updated_at", "version").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		return nil
+	})
+	if isNotFound(err) {
+		return api.Message{}, fmt.Errorf("message %s: %w", upd.ID, api.ErrNotFound)
 	}
-	if _, err := pg.bun.NewInsert().Model(m).Exec(ctx); err != nil {
-		return api.Message{}, fmt.Errorf("insert: %w", err)
+	if err != nil {
+		return api.Message{}, wrapErr(err)
 	}
 	return m.APIMessage(), nil
 }
 
-func (pg *Postgres) InsertReaction(ctx context.Context, reaction api.Reaction) (api.Reaction, error) {
-	panic("not implemented")
+// MessageHistory returns the prior versions of a message, newest first. It
+// returns api.ErrNotFound if the message does not exist or was deleted.
+func (pg *Postgres) MessageHistory(ctx context.Context, appID, msgID string) ([]api.MessageVersion, error) {
+	exists, err := pg.bun.NewSelect().
+		Model((*message)(nil)).
+		Where("id = ?", msgID).
+		Where("app_id = ?", appOrDefault(appID)).
+		Where("deleted_at IS NULL").
+		Exists(ctx)
+	if isNotFound(err) || err == nil && !exists {
+		return nil, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", wrapErr(err))
+	}
+
+	var versions []messageVersion
+	err = pg.bun.NewSelect().
+		Model(&versions).
+		Where("message_id = ?", msgID).
+		Order("version DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scan history: %w", wrapErr(err))
+	}
+	out := make([]api.MessageVersion, len(versions))
+	for i, v := range versions {
+		out[i] = v.APIMessageVersion()
+	}
+	return out, nil
+}
+
+// DeleteMessage soft deletes a message by setting its deletion time. Its
+// reactions and history are kept until the message is purged. A deleted
+// reply is taken off the reply count and the latest reply time of its
+// parent. It returns
+// api.ErrNotFound if the message does not exist or was already deleted, and
+// api.ErrForbidden if del.UserID is neither its author nor a moderator.
+func (pg *Postgres) DeleteMessage(ctx context.Context, del api.MessageDeletion) (api.Message, error) {
+	var m message
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&m).
+			Where("id = ?", del.ID).
+			Where("app_id = ?", appOrDefault(del.AppID)).
+			Where("deleted_at IS NULL").
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("message %s: %w", del.ID, api.ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("select: %w", err)
+		}
+		if m.UserID != del.UserID && !del.Moderator {
+			return fmt.Errorf("message %s: %w", del.ID, api.ErrForbidden)
+		}
+		m.DeletedAt = del.DeletedAt
+		if _, err := tx.NewUpdate().Model(&m).Column("deleted_at").WherePK().Exec(ctx); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		if m.ParentID == "" {
+			return nil
+		}
+		_, err = tx.NewUpdate().
+			Model((*message)(nil)).
+			Set("reply_count = reply_count - 1").
+			Set("latest_reply_at = (SELECT max(created_at) FROM messages WHERE parent_id = ? AND deleted_at IS NULL)", m.ParentID).
+			Where("id = ?", m.ParentID).
+			Where("app_id = ?", m.AppID).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update parent: %w", err)
+		}
+		return nil
+	})
+	if isNotFound(err) {
+		return api.Message{}, fmt.Errorf("message %s: %w", del.ID, api.ErrNotFound)
+	}
+	if err != nil {
+		return api.Message{}, wrapErr(err)
+	}
+	return m.APIMessage(), nil
+}
+
+// PurgeMessages deletes the messages of all apps that were soft deleted
+// before the given time. Their reactions and history are removed by the
+// foreign keys. A message that has replies is kept as a stub without text,
+// reactions or history, so that its replies stay in their thread, and is
+// deleted once it has no replies left.
+func (pg *Postgres) PurgeMessages(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var n int
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*message)(nil)).
+			Where("deleted_at < ?", deletedBefore).
+			Where("NOT EXISTS (SELECT 1 FROM messages AS reply WHERE reply.parent_id = message.id)").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+
+		var stubs []string
+		err = tx.NewUpdate().
+			Model((*message)(nil)).
+			Set("message_text = ''").
+			Where("deleted_at < ?", deletedBefore).
+			Where("message_text <> ''").
+			Returning("id").
+			Scan(ctx, &stubs)
+		if err != nil {
+			return fmt.Errorf("update stubs: %w", err)
+		}
+		n = int(deleted)
+		if len(stubs) == 0 {
+			return nil
+		}
+		for _, model := range []any{(*reaction)(nil), (*reactionCount)(nil), (*messageVersion)(nil)} {
+			_, err := tx.NewDelete().
+				Model(model).
+				Where("message_id IN (?)", bun.In(stubs)).
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("delete from stubs: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return n, nil
+}
+
+// InsertReaction inserts a reaction into the database and updates the
+// reaction counts of the message. If the user already reacted with the type,
+// the score is added to the existing reaction instead. The returned reaction
+// holds auto generated fields, such as the reaction id, and whether it was
+// added. If the message does not exist or was deleted, api.ErrNotFound is
+// returned.
+func (pg *Postgres) InsertReaction(ctx context.Context, r api.Reaction, opts api.ReactionOptions) (api.Reaction, bool, error) {
+	return pg.putReaction(ctx, r, opts, true)
+}
+
+// SetReaction sets the score of the user's reaction of type r.Type to the
+// message, adding the reaction if the user has none. The reaction counts of
+// the message are updated in the same transaction. If the message does not
+// exist or was deleted, api.ErrNotFound is returned.
+func (pg *Postgres) SetReaction(ctx context.Context, r api.Reaction, opts api.ReactionOptions) (api.Reaction, bool, error) {
+	return pg.putReaction(ctx, r, opts, false)
+}
+
+// putReaction adds the reaction of a user, or changes the score of the
+// existing one. The score is added to the existing score if accumulate is
+// set.
+func (pg *Postgres) putReaction(ctx context.Context, r api.Reaction, opts api.ReactionOptions, accumulate bool) (api.Reaction, bool, error) {
+	var (
+		m       reaction
+		created bool
+	)
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := lockUserReactions(ctx, tx, appOrDefault(r.AppID), r.MessageID, r.UserID); err != nil {
+			return err
+		}
+		if opts.Exclusive {
+			var others []reaction
+			err := tx.NewDelete().
+				Model(&others).
+				Where("message_id = ?", r.MessageID).
+				Where("user_id = ?", r.UserID).
+				Where("reaction_type <> ?", r.Type).
+				Returning("reaction_type, score").
+				Scan(ctx)
+			if err != nil {
+				return fmt.Errorf("delete other types: %w", err)
+			}
+			for _, o := range others {
+				if err := addReactionCounts(ctx, tx, r.MessageID, o.ReactionType, -1, -o.Score); err != nil {
+					return err
+				}
+			}
+		}
+
+		err := tx.NewSelect().
+			Model(&m).
+			Where("message_id = ?", r.MessageID).
+			Where("user_id = ?", r.UserID).
+			Where("reaction_type = ?", r.Type).
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			m = reaction{
+				AppID:        appOrDefault(r.AppID),
+				MessageID:    r.MessageID,
+				ReactionType: r.Type,
+				Score:        capScore(r.Score, opts),
+				UserID:       r.UserID,
+			}
+			if _, err := tx.NewInsert().Model(&m).Exec(ctx); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			created = true
+			return addReactionCounts(ctx, tx, m.MessageID, m.ReactionType, 1, m.Score)
+		}
+		if err != nil {
+			return fmt.Errorf("select: %w", err)
+		}
+
+		score := r.Score
+		if accumulate {
+			score += m.Score
+		}
+		score = capScore(score, opts)
+		if score == m.Score {
+			return nil
+		}
+		delta := score - m.Score
+		m.Score = score
+		if _, err := tx.NewUpdate().Model(&m).Column("score").WherePK().Exec(ctx); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+		return addReactionCounts(ctx, tx, m.MessageID, m.ReactionType, 0, delta)
+	})
+	if isNotFound(err) {
+		return api.Reaction{}, false, fmt.Errorf("message %s: %w", r.MessageID, api.ErrNotFound)
+	}
+	if err != nil {
+		return api.Reaction{}, false, wrapErr(err)
+	}
+	return m.APIReaction(), created, nil
+}
+
+// capScore lowers score to the cap of opts.
+func capScore(score int, opts api.ReactionOptions) int {
+	if opts.MaxScore > 0 {
+		return min(score, opts.MaxScore)
+	}
+	return score
+}
+
+// DeleteReaction removes the user's reaction of the given type from the
+// message and subtracts it from its reaction counts. It returns how many
+// reactions were removed. If the message does not exist or was deleted,
+// api.ErrNotFound is returned.
+func (pg *Postgres) DeleteReaction(ctx context.Context, appID, msgID, userID, reactionType string) (int, error) {
+	var scores []int
+	err := pg.bun.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := lockUserReactions(ctx, tx, appOrDefault(appID), msgID, userID); err != nil {
+			return err
+		}
+		err := tx.NewDelete().
+			Model((*reaction)(nil)).
+			Where("message_id = ?", msgID).
+			Where("user_id = ?", userID).
+			Where("reaction_type = ?", reactionType).
+			Returning("score").
+			Scan(ctx, &scores)
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		score := 0
+		for _, s := range scores {
+			score += s
+		}
+		return addReactionCounts(ctx, tx, msgID, reactionType, -len(scores), -score)
+	})
+	if isNotFound(err) {
+		return 0, fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	return len(scores), nil
+}
+
+// lockMessage locks the message of the app against deletion until the end
+// of the transaction. The foreign keys only cover messages that were purged,
+// so it returns api.ErrNotFound if the message was soft deleted or belongs
+// to another app.
+func lockMessage(ctx context.Context, tx bun.Tx, appID, msgID string) error {
+	var id string
+	err := tx.NewSelect().
+		Model((*message)(nil)).
+		Column("id").
+		Where("id = ?", msgID).
+		Where("app_id = ?", appID).
+		Where("deleted_at IS NULL").
+		For("SHARE").
+		Scan(ctx, &id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("message %s: %w", msgID, api.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("select message: %w", err)
+	}
+	return nil
+}
+
+// lockUserReactions serializes the changes to the reactions of one user to a
+// message until the end of the transaction, so that the score of a reaction
+// is read and written by one request at a time. It also locks the message,
+// see lockMessage.
+func lockUserReactions(ctx context.Context, tx bun.Tx, appID, msgID, userID string) error {
+	key := msgID + "/" + userID
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended(?, 0))", key); err != nil {
+		return fmt.Errorf("lock reactions: %w", err)
+	}
+	return lockMessage(ctx, tx, appID, msgID)
+}
+
+// addReactionCounts adds count and score to the reaction counts of the given
+// type. The counts are updated in place, so that concurrent changes add up.
+// Counts that drop to zero are removed, like types without any reactions.
+func addReactionCounts(ctx context.Context, tx bun.Tx, msgID, reactionType string, count, score int) error {
+	if count == 0 && score == 0 {
+		return nil
+	}
+	c := &reactionCount{
+		MessageID:    msgID,
+		ReactionType: reactionType,
+		Count:        count,
+		Score:        score,
+	}
+	_, err := tx.NewInsert().
+		Model(c).
+		On("CONFLICT (message_id, reaction_type) DO UPDATE").
+		Set("count = rc.count + EXCLUDED.count").
+		Set("score = rc.score + EXCLUDED.score").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update counts: %w", err)
+	}
+	if count < 0 {
+		_, err := tx.NewDelete().
+			Model((*reactionCount)(nil)).
+			Where("message_id = ?", msgID).
+			Where("reaction_type = ?", reactionType).
+			Where("count <= 0").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete counts: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReactionSummaries returns the aggregated reactions for each of the given
+// messages of the app, including up to latest of their most recent
+// reactions. Messages without reactions are omitted from the result. It runs
+// two queries regardless of the number of messages.
+func (pg *Postgres) ReactionSummaries(ctx context.Context, appID string, msgIDs []string, latest int) (map[string]api.ReactionSummary, error) {
+	appID = appOrDefault(appID)
+	out := make(map[string]api.ReactionSummary, len(msgIDs))
+	if len(msgIDs) == 0 {
+		return out, nil
+	}
+
+	var counts []reactionCount
+	err := pg.bun.NewSelect().
+		Model(&counts).
+		Where("message_id IN (?)", bun.In(msgIDs)).
+		Where("EXISTS (SELECT 1 FROM messages WHERE id = rc.message_id AND app_id = ?)", appID).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scan counts: %w", wrapErr(err))
+	}
+	for _, c := range counts {
+		sum, ok := out[c.MessageID]
+		if !ok {
+			sum.Counts = make(map[string]int)
+			sum.Scores = make(map[string]int)
+		}
+		sum.Count += c.Count
+		sum.TotalScore += c.Score
+		sum.Counts[c.ReactionType] = c.Count
+		sum.Scores[c.ReactionType] = c.Score
+		out[c.MessageID] = sum
+	}
+
+	if latest <= 0 {
+		return out, nil
+	}
+	// The lateral join walks the (message_id, created_at, id) index once per
+	// message, rather than sorting all reactions of the messages.
+	var reactions []reaction
+	err = pg.bun.NewRaw(`
+		SELECT r.*
+		FROM unnest(?::uuid[]) AS m(id)
+		CROSS JOIN LATERAL (
+			SELECT * FROM reactions
+			WHERE message_id = m.id AND app_id = ?
+			ORDER BY created_at DESC, id DESC
+			LIMIT ?
+		) AS r`, pgdialect.Array(msgIDs), appID, latest).
+		Scan(ctx, &reactions)
+	if err != nil {
+		return nil, fmt.Errorf("scan latest reactions: %w", wrapErr(err))
+	}
+	for _, r := range reactions {
+		sum := out[r.MessageID]
+		sum.Latest = append(sum.Latest, r.APIReaction())
+		out[r.MessageID] = sum
+	}
+	return out, nil
+}
+
+// appOrDefault returns appID, or the id of the default app if it is empty.
+func appOrDefault(appID string) string {
+	if appID == "" {
+		return api.DefaultAppID
+	}
+	return appID
+}
+
+// isNotFound reports whether err was caused by referencing a row that does
+// not exist, either because a foreign key is violated or because the given
+// id is not a valid uuid.
+func isNotFound(err error) bool {
+	var pgErr pgdriver.Error
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Field('C') {
+	case "23503", // foreign_key_violation
+		"22P02": // invalid_text_representation
+		return true
+	}
+	return false
+}
+
+// isConflict reports whether err was caused by a unique constraint.
+func isConflict(err error) bool {
+	var pgErr pgdriver.Error
+	return errors.As(err, &pgErr) && pgErr.Field('C') == "23505" // unique_violation
+}
+
+// wrapErr marks errors caused by an unreachable database with
+// api.ErrUnavailable.
+func wrapErr(err error) error {
+	if err == nil || !isUnavailable(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", api.ErrUnavailable, err)
+}
+
+func isUnavailable(err error) bool {
+	var (
+		pgErr  pgdriver.Error
+		netErr net.Error
+	)
+	switch {
+	case errors.As(err, &pgErr):
+		code := pgErr.Field('C')
+		return strings.HasPrefix(code, "08") || // connection_exception
+			code == "57P01" || // admin_shutdown
+			code == "57P02" || // crash_shutdown
+			code == "57P03" // cannot_connect_now
+	case errors.As(err, &netErr),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET):
+		return true
+	}
+	return false
 }
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// Instrument registers command latency and error metrics, and the number of
+// evicted messages, with reg. It must be called before Redis is used.
+func (r *Redis) Instrument(reg prometheus.Registerer) {
+	h := &metricsHook{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "redis_command_duration_seconds",
+			Help:    "Latency of Redis commands by command name. Pipelines are recorded as a single pipeline command.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"command"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "redis_command_errors_total",
+			Help: "Number of failed Redis commands by command name.",
+		}, []string{"command"}),
+	}
+	r.evictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "redis_cache_evictions_total",
+		Help: "Number of messages evicted from the cache because it was full.",
+	})
+	reg.MustRegister(h.duration, h.errors, r.evictions)
+	r.cli.AddHook(h)
+}
+
+// metricsHook is a redis.Hook that records command metrics.
+type metricsHook struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func (h *metricsHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.errors.WithLabelValues("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (h *metricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.observe(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (h *metricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.observe("pipeline", start, err)
+		return err
+	}
+}
+
+func (h *metricsHook) observe(name string, start time.Time, err error) {
+	h.duration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	// A missing key is a result, not a failure.
+	if err != nil && !errors.Is(err, redis.Nil) {
+		h.errors.WithLabelValues(name).Inc()
+	}
+}
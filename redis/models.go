@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"encoding/json"
+	"net/http"
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
@@ -9,16 +11,60 @@ import (
 // A message represents a message in the database.
 type message struct {
 	ID        string    `redis:"id" json:"id"`
+	AppID     string    `redis:"app_id" json:"app_id"`
+	ChannelID string    `redis:"channel_id" json:"channel_id"`
+	ParentID  string    `redis:"parent_id" json:"parent_id"`
 	Text      string    `redis:"text" json:"text"`
 	UserID    string    `redis:"user_id" json:"user_id"`
 	CreatedAt time.Time `redis:"created_at" json:"created_at"`
+	UpdatedAt time.Time `redis:"updated_at" json:"updated_at"`
+	Version   int       `redis:"version" json:"version"`
+	DeletedAt time.Time `redis:"deleted_at" json:"deleted_at"`
+
+	ShowInChannel bool      `redis:"show_in_channel" json:"show_in_channel"`
+	ReplyCount    int       `redis:"reply_count" json:"reply_count"`
+	LatestReplyAt time.Time `redis:"latest_reply_at" json:"latest_reply_at"`
 }
 
 func (m message) APIMessage() api.Message {
 	return api.Message{
 		ID:        m.ID,
+		AppID:     m.AppID,
+		ChannelID: m.ChannelID,
+		ParentID:  m.ParentID,
 		Text:      m.Text,
 		UserID:    m.UserID,
 		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+		Version:   m.Version,
+		DeletedAt: m.DeletedAt,
+
+		ShowInChannel: m.ShowInChannel,
+		ReplyCount:    m.ReplyCount,
+		LatestReplyAt: m.LatestReplyAt,
 	}
 }
+
+// An event represents an event published to the other instances. The id is
+// assigned by Redis when the event is published, so it is sent ahead of the
+// JSON rather than in it.
+type event struct {
+	ID           uint64            `json:"-"`
+	Type         string            `json:"type"`
+	AppID        string            `json:"app_id"`
+	ChannelID    string            `json:"channel_id"`
+	MessageID    string            `json:"message_id"`
+	ParentID     string            `json:"parent_id"`
+	Data         json.RawMessage   `json:"data"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// An idempotentResponse represents a stored response to an idempotent
+// request.
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
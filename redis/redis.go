@@ -2,41 +2,98 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
 // Redis provides caching in Redis.
 type Redis struct {
-	cli *redis.Client
+	cli       *redis.Client
+	evictions prometheus.Counter // set by Instrument
 }
 
-// Connect connects to the Redis server and pings the server to ensure the
-// connection is working.
-func Connect(ctx context.Context, addr string) (*Redis, error) {
+// New returns a Redis client for the server at addr. It does not connect to
+// the server until the first command is issued, so the server does not have
+// to be available yet.
+func New(addr string) *Redis {
 	cli := redis.NewClient(&redis.Options{
 		Addr: addr,
 	})
-	if err := cli.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("ping redis: %w", err)
-	}
+	// Commands are traced with the global tracer provider. This only fails
+	// for client types other than *redis.Client.
+	_ = redisotel.InstrumentTracing(cli)
 	return &Redis{
 		cli: cli,
-	}, nil
+	}
+}
+
+// Connect connects to the Redis server and pings the server to ensure the
+// connection is working.
+func Connect(ctx context.Context, addr string) (*Redis, error) {
+	r := New(addr)
+	if err := r.cli.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("ping redis: %w", err)
+	}
+	return r, nil
+}
+
+// Ping checks that the Redis server is reachable.
+func (r *Redis) Ping(ctx context.Context) error {
+	if err := r.cli.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("ping redis: %w", err)
+	}
+	return nil
 }
 
 const (
+	appPrefix     = "apps"
 	messagePrefix = "messages"
+	channelPrefix = "channels"
 	maxSize       = 10
+
+	queueKey      = "queue:messages"
+	deadLetterKey = "queue:messages:dead"
+
+	eventsChannel = "events"
+	eventIDKey    = "events:id"
+
+	rateLimitPrefix   = "ratelimit"
+	idempotencyPrefix = "idempotency"
 )
 
-// ListMessages returns a list of message from Redis. The messages are sorted
-// by the timestamp in descending order.
-func (r *Redis) ListMessages(ctx context.Context) ([]api.Message, error) {
-	vals, err := r.cli.ZRevRangeByScore(ctx, messagePrefix, &redis.ZRangeBy{
+// channelKey returns the key of the sorted set that indexes the cached
+// messages of a channel of an app. The messages themselves are stored in
+// hashes keyed by their app and id.
+func channelKey(appID, channelID string) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", appPrefix, appOrDefault(appID), channelPrefix, channelID, messagePrefix)
+}
+
+// messageKey returns the key of the hash that holds a cached message.
+func messageKey(appID, msgID string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", appPrefix, appOrDefault(appID), messagePrefix, msgID)
+}
+
+// appOrDefault returns appID, or the id of the default app if it is empty.
+func appOrDefault(appID string) string {
+	if appID == "" {
+		return api.DefaultAppID
+	}
+	return appID
+}
+
+// ListMessages returns a list of message of a channel of the app from Redis.
+// The messages are sorted by the timestamp in descending order.
+func (r *Redis) ListMessages(ctx context.Context, appID, channelID string) ([]api.Message, error) {
+	vals, err := r.cli.ZRevRangeByScore(ctx, channelKey(appID, channelID), &redis.ZRangeBy{
 		Min: "-inf",
 		Max: fmt.Sprintf("%d", time.Now().UnixNano()),
 	}).Result()
@@ -58,15 +115,16 @@ func (r *Redis) ListMessages(ctx context.Context) ([]api.Message, error) {
 	return out, nil
 }
 
-// InsertMessage adds the message to Redis with the message:MESSAGE_ID as the key and adds the key to a sorted set.
+// InsertMessage adds the message to Redis with apps:APP_ID:messages:MESSAGE_ID as the key and adds the key to the sorted set of its channel.
 func (r *Redis) InsertMessage(ctx context.Context, msg api.Message) error {
+	msg.AppID = appOrDefault(msg.AppID)
 	m := message(msg)
 
 	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
 		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			key := fmt.Sprintf("%s:%s", messagePrefix, m.ID)
+			key := messageKey(m.AppID, m.ID)
 			pipe.HSet(ctx, key, m)
-			pipe.ZAdd(ctx, messagePrefix, redis.Z{
+			pipe.ZAdd(ctx, channelKey(m.AppID, m.ChannelID), redis.Z{
 				Score:  float64(msg.CreatedAt.UnixNano()),
 				Member: key,
 			})
@@ -81,23 +139,289 @@ func (r *Redis) InsertMessage(ctx context.Context, msg api.Message) error {
 	}
 
 	// Simulate an eviction strategy by removing the oldest key in case the max cache size is exceeded.
-	err = r.evictOldest(ctx)
+	err = r.evictOldest(ctx, m.AppID, m.ChannelID)
 	if err != nil {
 		return fmt.Errorf("evict oldest: %w", err)
 	}
 	return nil
 }
 
-func (r *Redis) evictOldest(ctx context.Context) error {
-	vals, err := r.cli.ZRange(ctx, messagePrefix, 0, int64(-maxSize-1)).Result()
+// UpdateMessage rewrites the hash of the message if it is cached. Messages
+// that are not cached are not added, since they may be older than the cached
+// ones.
+func (r *Redis) UpdateMessage(ctx context.Context, msg api.Message) error {
+	msg.AppID = appOrDefault(msg.AppID)
+	key := messageKey(msg.AppID, msg.ID)
+	err := r.cli.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil || n == 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, message(msg))
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return fmt.Errorf("redis update message: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all cached messages of all channels of all apps.
+func (r *Redis) Clear(ctx context.Context) error {
+	iter := r.cli.Scan(ctx, 0, channelKey("*", "*"), 0).Iterator()
+	for iter.Next(ctx) {
+		set := iter.Val()
+		keys, err := r.cli.ZRange(ctx, set, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("zrange: %w", err)
+		}
+		keys = append(keys, set)
+		if err := r.cli.Del(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("del: %w", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+	return nil
+}
+
+func (r *Redis) evictOldest(ctx context.Context, appID, channelID string) error {
+	set := channelKey(appID, channelID)
+	vals, err := r.cli.ZRange(ctx, set, 0, int64(-maxSize-1)).Result()
 	if err != nil {
 		return fmt.Errorf("zrevrange: %w", err)
 	}
 
 	for _, key := range vals {
-		_ = r.cli.ZRem(ctx, messagePrefix, key).Err()
+		_ = r.cli.ZRem(ctx, set, key).Err()
 		_ = r.cli.Del(ctx, key).Err()
 	}
+	if r.evictions != nil {
+		r.evictions.Add(float64(len(vals)))
+	}
+
+	return nil
+}
+
+// Enqueue appends the message to the queue of messages that wait to be
+// written to the database.
+func (r *Redis) Enqueue(ctx context.Context, msg api.Message) error {
+	b, err := json.Marshal(message(msg))
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+	if err := r.cli.RPush(ctx, queueKey, b).Err(); err != nil {
+		return fmt.Errorf("rpush: %w", err)
+	}
+	return nil
+}
+
+// Replay calls fn for each queued message, oldest first, and removes the
+// message once fn succeeds. A message stays queued until it is replayed, so
+// a crash can cause a message to be replayed twice, but never to be lost.
+// Messages that cannot be decoded are moved to a dead letter list.
+func (r *Redis) Replay(ctx context.Context, fn func(api.Message) error) (int, error) {
+	var n int
+	for {
+		val, err := r.cli.LIndex(ctx, queueKey, 0).Result()
+		if errors.Is(err, redis.Nil) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("lindex: %w", err)
+		}
+
+		var msg message
+		if err := json.Unmarshal([]byte(val), &msg); err != nil {
+			_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.LRem(ctx, queueKey, 1, val)
+				pipe.RPush(ctx, deadLetterKey, val)
+				return nil
+			})
+			if err != nil {
+				return n, fmt.Errorf("move to dead letters: %w", err)
+			}
+			continue
+		}
+		if err := fn(msg.APIMessage()); err != nil {
+			return n, err
+		}
+		// Remove this exact entry, since another instance may be replaying
+		// the queue concurrently.
+		if err := r.cli.LRem(ctx, queueKey, 1, val).Err(); err != nil {
+			return n, fmt.Errorf("lrem: %w", err)
+		}
+		n++
+	}
+}
+
+// publishScript assigns the next event id and publishes the event with it,
+// in one step, so that the ids increase in the order the events are
+// received.
+var publishScript = redis.NewScript(`
+local id = redis.call('INCR', KEYS[1])
+redis.call('PUBLISH', ARGV[1], id .. ' ' .. ARGV[2])
+return id
+`)
+
+// Publish assigns e the next event id, which increases across all
+// instances, and sends it to the instances subscribed to the events
+// channel. Messages on the channel are the id and the JSON of the event,
+// separated by a space.
+func (r *Redis) Publish(ctx context.Context, e api.Event) error {
+	b, err := json.Marshal(event(e))
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	if err := publishScript.Run(ctx, r.cli, []string{eventIDKey}, eventsChannel, b).Err(); err != nil {
+		return fmt.Errorf("run publish script: %w", err)
+	}
+	return nil
+}
+
+// Subscribe subscribes to the events channel and calls fn for every event
+// until ctx is canceled. The client resubscribes when the connection to
+// Redis is lost, but the events published in the meantime are lost. Events
+// that cannot be decoded are skipped.
+func (r *Redis) Subscribe(ctx context.Context, fn func(api.Event)) error {
+	ps := r.cli.Subscribe(ctx, eventsChannel)
+	// Wait for the confirmation, so that no event is missed after Subscribe
+	// returns.
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	go func() {
+		defer ps.Close()
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				// The channel is closed when the client shuts down.
+				if !ok {
+					return
+				}
+				id, payload, _ := strings.Cut(m.Payload, " ")
+				var e event
+				if err := json.Unmarshal([]byte(payload), &e); err != nil {
+					continue
+				}
+				n, err := strconv.ParseUint(id, 10, 64)
+				if err != nil {
+					continue
+				}
+				e.ID = n
+				fn(api.Event(e))
+			}
+		}
+	}()
+	return nil
+}
 
+// allowScript takes a request from a token bucket, stored as a hash of the
+// tokens left and the time they were counted. The bucket is refilled by the
+// time passed since, according to the clock of the Redis server, so that
+// all instances agree. It returns whether the request is allowed, the
+// requests remaining, and the microseconds until the next request is
+// allowed and until the bucket is full.
+var allowScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2]) -- microseconds per request
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) / interval)
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) * interval)
+end
+local reset = math.ceil((capacity - tokens) * interval)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// Allow takes a request from the bucket of key atomically, so that the
+// limit holds across all instances. Buckets expire once they are full.
+func (r *Redis) Allow(ctx context.Context, key string, limit api.RateLimit) (api.RateLimitResult, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return api.RateLimitResult{}, fmt.Errorf("invalid rate limit %s", limit)
+	}
+	interval := limit.Period.Microseconds() / int64(limit.Requests)
+	vals, err := allowScript.Run(ctx, r.cli, []string{rateLimitPrefix + ":" + key}, limit.Requests, max(interval, 1)).Int64Slice()
+	if err != nil {
+		return api.RateLimitResult{}, fmt.Errorf("run allow script: %w", err)
+	}
+	if len(vals) != 4 {
+		return api.RateLimitResult{}, fmt.Errorf("allow script returned %d values", len(vals))
+	}
+	return api.RateLimitResult{
+		Allowed:    vals[0] == 1,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Microsecond,
+		Reset:      time.Duration(vals[3]) * time.Microsecond,
+	}, nil
+}
+
+// reserveScript sets a key unless it exists, and returns the value it holds
+// otherwise, so that a key is never claimed twice.
+var reserveScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+  return false
+end
+return redis.call('GET', KEYS[1])
+`)
+
+// Reserve claims key for the request with the given fingerprint until ttl
+// passes, unless it is claimed already.
+func (r *Redis) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (api.IdempotentResponse, bool, error) {
+	b, err := json.Marshal(idempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		return api.IdempotentResponse{}, false, fmt.Errorf("encode response: %w", err)
+	}
+	val, err := reserveScript.Run(ctx, r.cli, []string{idempotencyPrefix + ":" + key}, b, max(ttl.Milliseconds(), 1)).Text()
+	if errors.Is(err, redis.Nil) {
+		return api.IdempotentResponse{}, true, nil
+	}
+	if err != nil {
+		return api.IdempotentResponse{}, false, fmt.Errorf("run reserve script: %w", err)
+	}
+	var resp idempotentResponse
+	if err := json.Unmarshal([]byte(val), &resp); err != nil {
+		return api.IdempotentResponse{}, false, fmt.Errorf("decode response: %w", err)
+	}
+	return api.IdempotentResponse(resp), false, nil
+}
+
+// Complete stores the response to the request that claimed key for ttl.
+func (r *Redis) Complete(ctx context.Context, key string, resp api.IdempotentResponse, ttl time.Duration) error {
+	b, err := json.Marshal(idempotentResponse(resp))
+	if err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+	if err := r.cli.Set(ctx, idempotencyPrefix+":"+key, b, ttl).Err(); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	return nil
+}
+
+// Release frees key.
+func (r *Redis) Release(ctx context.Context, key string) error {
+	if err := r.cli.Del(ctx, idempotencyPrefix+":"+key).Err(); err != nil {
+		return fmt.Errorf("del: %w", err)
+	}
 	return nil
 }
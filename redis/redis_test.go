@@ -4,11 +4,13 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/GetStream/stream-backend-homework-assignment/storetest"
 	"github.com/google/go-cmp/cmp"
 	"github.com/redis/go-redis/v9"
 )
@@ -27,7 +29,7 @@ func TestRedis_ListMessages(t *testing.T) {
 			name: "One",
 			setup: func(r *Redis) error {
 				members := map[string]message{
-					"messages:9cbf8127-299b-4a84-8920-cd35ea0c084c": message{
+					messageKey(api.DefaultAppID, "9cbf8127-299b-4a84-8920-cd35ea0c084c"): message{
 						ID:        "9cbf8127-299b-4a84-8920-cd35ea0c084c",
 						Text:      "hello",
 						UserID:    "test",
@@ -49,13 +51,13 @@ func TestRedis_ListMessages(t *testing.T) {
 			name: "Two",
 			setup: func(r *Redis) error {
 				members := map[string]message{
-					"messages:1bb3fbd9-01b8-41ed-ac45-3f7c6235e657": message{
+					messageKey(api.DefaultAppID, "1bb3fbd9-01b8-41ed-ac45-3f7c6235e657"): message{
 						ID:        "1bb3fbd9-01b8-41ed-ac45-3f7c6235e657",
 						Text:      "hello",
 						UserID:    "test",
 						CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 					},
-					"messages:7f1f1803-d3cf-46a9-acd2-6aa9d4b8b4c0": message{
+					messageKey(api.DefaultAppID, "7f1f1803-d3cf-46a9-acd2-6aa9d4b8b4c0"): message{
 						ID:        "7f1f1803-d3cf-46a9-acd2-6aa9d4b8b4c0",
 						Text:      "world",
 						UserID:    "test",
@@ -93,7 +95,7 @@ func TestRedis_ListMessages(t *testing.T) {
 				}
 			}
 
-			got, err := r.ListMessages(ctx)
+			got, err := r.ListMessages(ctx, api.DefaultAppID, api.DefaultChannelID)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -113,11 +115,12 @@ func TestRedis_InsertMessage(t *testing.T) {
 		{
 			name: "OK",
 			msg: api.Message{
-				Text:   "Hello",
-				UserID: "testuser",
+				ChannelID: api.DefaultChannelID,
+				Text:      "Hello",
+				UserID:    "testuser",
 			},
 			check: func(t *testing.T, r *Redis) {
-				vals, err := r.cli.ZRange(context.Background(), messagePrefix, 0, 10).Result()
+				vals, err := r.cli.ZRange(context.Background(), channelKey(api.DefaultAppID, api.DefaultChannelID), 0, 10).Result()
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -166,6 +169,7 @@ func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 	for i := 0; i <= maxSize; i++ {
 		msg := api.Message{
 			ID:        fmt.Sprintf("message-%d", i+1),
+			ChannelID: api.DefaultChannelID,
 			Text:      fmt.Sprintf("Message %d", i+1),
 			UserID:    "testuser",
 			CreatedAt: time.Now().Add(time.Millisecond * time.Duration(i)),
@@ -176,7 +180,7 @@ func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 	}
 
 	// Fetching all 11 items should return 10 items because no more than 10 messages should be stored.
-	vals, err := r.cli.ZRevRange(ctx, messagePrefix, 0, 10).Result()
+	vals, err := r.cli.ZRevRange(ctx, channelKey(api.DefaultAppID, api.DefaultChannelID), 0, 10).Result()
 
 	if err != nil {
 		t.Fatal(err)
@@ -197,6 +201,72 @@ func TestRedis_InsertMessage_MaxSize(t *testing.T) {
 	}
 }
 
+func TestRedis_Queue(t *testing.T) {
+	r := connect(t)
+	ctx := context.Background()
+
+	msgs := []api.Message{
+		{ID: "1", Text: "hello", UserID: "test", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "2", Text: "world", UserID: "test", CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, msg := range msgs {
+		if err := r.Enqueue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failure stops the replay and leaves the message in the queue.
+	fail := errors.New("db down")
+	n, err := r.Replay(ctx, func(msg api.Message) error {
+		if msg.ID == "2" {
+			return fail
+		}
+		return nil
+	})
+	if !errors.Is(err, fail) || n != 1 {
+		t.Fatalf("Got %d replayed messages and error %v, want 1 and %v", n, err, fail)
+	}
+
+	var got []api.Message
+	n, err = r.Replay(ctx, func(msg api.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Got %d replayed messages, want 1", n)
+	}
+	if diff := cmp.Diff(got, msgs[1:]); diff != "" {
+		t.Errorf("Replayed messages differ (-got +want)\n%s", diff)
+	}
+}
+
+func TestRedis_Conformance(t *testing.T) {
+	storetest.TestCache(t, func(t *testing.T) api.Cache {
+		return connect(t)
+	})
+}
+
+func TestRedis_BusConformance(t *testing.T) {
+	storetest.TestBus(t, func(t *testing.T) api.EventBus {
+		return connect(t)
+	})
+}
+
+func TestRedis_LimiterConformance(t *testing.T) {
+	storetest.TestRateLimiter(t, func(t *testing.T) api.RateLimiter {
+		return connect(t)
+	})
+}
+
+func TestRedis_IdempotencyConformance(t *testing.T) {
+	storetest.TestIdempotencyStore(t, func(t *testing.T) api.IdempotencyStore {
+		return connect(t)
+	})
+}
+
 func connect(t *testing.T) *Redis {
 	t.Helper()
 	addr := "localhost:6379"
@@ -222,7 +292,7 @@ func set(t *testing.T, r *Redis, messages map[string]message) error {
 			return err
 		}
 
-		if err := r.cli.ZAdd(context.Background(), messagePrefix, redis.Z{
+		if err := r.cli.ZAdd(context.Background(), channelKey(api.DefaultAppID, api.DefaultChannelID), redis.Z{
 			Score:  float64(msg.CreatedAt.UnixNano()),
 			Member: key,
 		}).Err(); err != nil {
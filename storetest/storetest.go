@@ -0,0 +1,1597 @@
+// Package storetest implements tests for implementations of api.DB,
+// api.Cache, api.EventBus, api.RateLimiter and api.IdempotencyStore, so that
+// every backend is held to the same contract.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GetStream/stream-backend-homework-assignment/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+// cacheSize is the number of messages a cache holds.
+const cacheSize = 10
+
+// missingID is a well-formed id that no message has.
+const missingID = "00000000-0000-0000-0000-000000000000"
+
+// OtherAppID is an app besides the default app. DBs under test must accept
+// data of this app, and the tests check that it is kept apart from the data
+// of the default app.
+const OtherAppID = "00000000-0000-4000-b000-000000000001"
+
+// TestDB tests a DB implementation. The newDB function is called for every
+// test and must return an empty DB.
+func TestDB(t *testing.T, newDB func(t *testing.T) api.DB) {
+	t.Run("ListMessages/Empty", func(t *testing.T) {
+		db := newDB(t)
+		got, err := db.ListMessages(ctx(t), api.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || len(got) != 0 {
+			t.Errorf("Got %v, want an empty non-nil slice", got)
+		}
+	})
+	t.Run("InsertMessage", func(t *testing.T) {
+		db := newDB(t)
+		got, err := db.InsertMessage(ctx(t), api.Message{Text: "hello", UserID: "testuser"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID == "" {
+			t.Error("Returned message has empty ID")
+		}
+		if got.CreatedAt.IsZero() {
+			t.Error("Returned message does not have a CreatedAt field")
+		}
+		if got.Text != "hello" || got.UserID != "testuser" {
+			t.Errorf("Returned message does not match the inserted one: %+v", got)
+		}
+	})
+	t.Run("InsertMessage/ID", func(t *testing.T) {
+		db := newDB(t)
+		msg := testMessages(1)[0]
+		got, err := db.InsertMessage(ctx(t), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msg); diff != "" {
+			t.Errorf("Returned message differs (-got +want)\n%s", diff)
+		}
+		if _, err := db.InsertMessage(ctx(t), msg); !errors.Is(err, api.ErrConflict) {
+			t.Errorf("Got error %v, want %v", err, api.ErrConflict)
+		}
+	})
+	t.Run("InsertMessage/DefaultChannel", func(t *testing.T) {
+		db := newDB(t)
+		got, err := db.InsertMessage(ctx(t), api.Message{Text: "hello", UserID: "testuser"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ChannelID != api.DefaultChannelID {
+			t.Errorf("Got channel %q, want the default channel", got.ChannelID)
+		}
+	})
+	t.Run("InsertMessage/UnknownChannel", func(t *testing.T) {
+		db := newDB(t)
+		_, err := db.InsertMessage(ctx(t), api.Message{ChannelID: missingID, Text: "hello", UserID: "testuser"})
+		if !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("GetMessage", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		got, err := db.GetMessage(ctx(t), api.DefaultAppID, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msg); diff != "" {
+			t.Errorf("Message differs (-got +want)\n%s", diff)
+		}
+
+		// Deleted messages are returned as well.
+		deleted, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: msg.ID, UserID: msg.UserID, DeletedAt: msg.CreatedAt.Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = db.GetMessage(ctx(t), api.DefaultAppID, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, deleted); diff != "" {
+			t.Errorf("Deleted message differs (-got +want)\n%s", diff)
+		}
+
+		if _, err := db.GetMessage(ctx(t), api.DefaultAppID, missingID); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("Channels", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.GetChannel(ctx(t), api.DefaultAppID, api.DefaultChannelID); err != nil {
+			t.Fatalf("Could not get the default channel: %v", err)
+		}
+
+		want := api.Channel{
+			ID:        "00000000-0000-4000-9000-000000000001",
+			AppID:     api.DefaultAppID,
+			Name:      "random",
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		got, err := db.InsertChannel(ctx(t), want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Returned channel differs (-got +want)\n%s", diff)
+		}
+		got, err = db.GetChannel(ctx(t), api.DefaultAppID, want.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Channel differs (-got +want)\n%s", diff)
+		}
+		if _, err := db.InsertChannel(ctx(t), want); !errors.Is(err, api.ErrConflict) {
+			t.Errorf("Got error %v, want %v", err, api.ErrConflict)
+		}
+		if _, err := db.GetChannel(ctx(t), api.DefaultAppID, missingID); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("Apps/Channels", func(t *testing.T) {
+		db := newDB(t)
+		ch, err := db.InsertChannel(ctx(t), api.Channel{AppID: OtherAppID, Name: "random"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ch.AppID != OtherAppID {
+			t.Errorf("Got app %q, want %q", ch.AppID, OtherAppID)
+		}
+		if _, err := db.GetChannel(ctx(t), OtherAppID, ch.ID); err != nil {
+			t.Errorf("Could not get the channel of the app: %v", err)
+		}
+		if _, err := db.GetChannel(ctx(t), api.DefaultAppID, ch.ID); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v for the channel of another app, want %v", err, api.ErrNotFound)
+		}
+		if _, err := db.GetChannel(ctx(t), OtherAppID, api.DefaultChannelID); err != nil {
+			t.Errorf("Could not get the default channel: %v", err)
+		}
+		_, err = db.InsertMessage(ctx(t), api.Message{ChannelID: ch.ID, Text: "hello", UserID: "testuser"})
+		if !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v for a message in the channel of another app, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("Apps/Messages", func(t *testing.T) {
+		db := newDB(t)
+		mine := insertMessages(t, db, 1)
+		other, err := db.InsertMessage(ctx(t), api.Message{AppID: OtherAppID, Text: "hello", UserID: "testuser"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.AppID != OtherAppID {
+			t.Errorf("Got app %q, want %q", other.AppID, OtherAppID)
+		}
+
+		got, err := db.ListMessages(ctx(t), api.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, mine); diff != "" {
+			t.Errorf("Messages of the default app differ (-got +want)\n%s", diff)
+		}
+		got, err = db.ListMessages(ctx(t), api.ListOptions{AppID: OtherAppID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, []api.Message{other}); diff != "" {
+			t.Errorf("Messages of the other app differ (-got +want)\n%s", diff)
+		}
+
+		// The message cannot be reached through the default app.
+		reaction := api.Reaction{MessageID: other.ID, Type: "like", Score: 1, UserID: "testuser"}
+		checks := map[string]func() error{
+			"GetMessage": func() error {
+				_, err := db.GetMessage(ctx(t), api.DefaultAppID, other.ID)
+				return err
+			},
+			"UpdateMessage": func() error {
+				_, err := db.UpdateMessage(ctx(t), api.MessageUpdate{ID: other.ID, Text: "bye", UserID: "testuser", UpdatedAt: time.Now()})
+				return err
+			},
+			"MessageHistory": func() error {
+				_, err := db.MessageHistory(ctx(t), api.DefaultAppID, other.ID)
+				return err
+			},
+			"DeleteMessage": func() error {
+				_, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: other.ID, UserID: "testuser", DeletedAt: time.Now()})
+				return err
+			},
+			"InsertReply": func() error {
+				_, err := db.InsertMessage(ctx(t), api.Message{ParentID: other.ID, Text: "hi", UserID: "testuser"})
+				return err
+			},
+			"InsertReaction": func() error {
+				_, _, err := db.InsertReaction(ctx(t), reaction, api.ReactionOptions{})
+				return err
+			},
+			"SetReaction": func() error {
+				_, _, err := db.SetReaction(ctx(t), reaction, api.ReactionOptions{})
+				return err
+			},
+			"DeleteReaction": func() error {
+				_, err := db.DeleteReaction(ctx(t), api.DefaultAppID, other.ID, "testuser", "like")
+				return err
+			},
+		}
+		for name, check := range checks {
+			if err := check(); !errors.Is(err, api.ErrNotFound) {
+				t.Errorf("%s: got error %v, want %v", name, err, api.ErrNotFound)
+			}
+		}
+
+		reaction.AppID = OtherAppID
+		if _, _, err := db.InsertReaction(ctx(t), reaction, api.ReactionOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		sums, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{other.ID}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != 0 {
+			t.Errorf("Got reactions %v of another app, want none", sums)
+		}
+		sums, err = db.ReactionSummaries(ctx(t), OtherAppID, []string{other.ID}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sums[other.ID].Count != 1 {
+			t.Errorf("Got reactions %v, want the reaction of the app", sums)
+		}
+	})
+	t.Run("ListMessages/Channel", func(t *testing.T) {
+		db := newDB(t)
+		ch, err := db.InsertChannel(ctx(t), api.Channel{Name: "random"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		general := insertMessages(t, db, 2)
+		random, err := db.InsertMessage(ctx(t), api.Message{ChannelID: ch.ID, Text: "hello", UserID: "testuser"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.ListMessages(ctx(t), api.ListOptions{ChannelID: api.DefaultChannelID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, general); diff != "" {
+			t.Errorf("Messages of the default channel differ (-got +want)\n%s", diff)
+		}
+		got, err = db.ListMessages(ctx(t), api.ListOptions{ChannelID: ch.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, []api.Message{random}); diff != "" {
+			t.Errorf("Messages of the new channel differ (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("ListMessages/Order", func(t *testing.T) {
+		db := newDB(t)
+		want := insertMessages(t, db, 5)
+		got, err := db.ListMessages(ctx(t), api.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Messages are not sorted newest first (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("ListMessages/Exclude", func(t *testing.T) {
+		db := newDB(t)
+		msgs := insertMessages(t, db, 4)
+		got, err := db.ListMessages(ctx(t), api.ListOptions{}, msgs[0].ID, msgs[2].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, []api.Message{msgs[1], msgs[3]}); diff != "" {
+			t.Errorf("Diff (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("ListMessages/Pagination", func(t *testing.T) {
+		db := newDB(t)
+		want := insertMessages(t, db, 7)
+
+		// Walk the pages from newest to oldest.
+		var got []api.Message
+		opts := api.ListOptions{Limit: 3}
+		for {
+			page, err := db.ListMessages(ctx(t), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) > opts.Limit {
+				t.Fatalf("Got %d messages, want at most %d", len(page), opts.Limit)
+			}
+			if len(page) == 0 {
+				break
+			}
+			got = append(got, page...)
+			c := page[len(page)-1].Cursor()
+			opts.Before = &c
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Paging forwards has gaps or duplicates (-got +want)\n%s", diff)
+		}
+
+		// And back again from the oldest message.
+		got = []api.Message{want[len(want)-1]}
+		c := want[len(want)-1].Cursor()
+		opts = api.ListOptions{Limit: 3, After: &c}
+		for {
+			page, err := db.ListMessages(ctx(t), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			got = append(page, got...)
+			c := page[0].Cursor()
+			opts.After = &c
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Paging backwards has gaps or duplicates (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("UpdateMessage", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		edited := msg.CreatedAt.Add(time.Minute)
+		got, err := db.UpdateMessage(ctx(t), api.MessageUpdate{
+			ID:        msg.ID,
+			Text:      "Edited",
+			UserID:    msg.UserID,
+			UpdatedAt: edited,
+			IfVersion: msg.Version,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Text != "Edited" || got.Version != msg.Version+1 || !got.UpdatedAt.Equal(edited) {
+			t.Errorf("Returned message does not reflect the edit: %+v", got)
+		}
+		list, err := db.ListMessages(ctx(t), api.ListOptions{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(list, []api.Message{got}); diff != "" {
+			t.Errorf("Listed message does not match the edited one (-got +want)\n%s", diff)
+		}
+
+		history, err := db.MessageHistory(ctx(t), api.DefaultAppID, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []api.MessageVersion{{
+			Version:    msg.Version,
+			Text:       msg.Text,
+			CreatedAt:  msg.CreatedAt,
+			ReplacedAt: edited,
+		}}
+		if diff := cmp.Diff(history, want); diff != "" {
+			t.Errorf("History does not hold the prior version (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("UpdateMessage/Errors", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		tests := []struct {
+			name string
+			upd  api.MessageUpdate
+			want error
+		}{
+			{
+				name: "NotFound",
+				upd:  api.MessageUpdate{ID: missingID, Text: "Edited", UserID: msg.UserID},
+				want: api.ErrNotFound,
+			},
+			{
+				name: "Forbidden",
+				upd:  api.MessageUpdate{ID: msg.ID, Text: "Edited", UserID: "someone-else"},
+				want: api.ErrForbidden,
+			},
+			{
+				name: "VersionMismatch",
+				upd:  api.MessageUpdate{ID: msg.ID, Text: "Edited", UserID: msg.UserID, IfVersion: msg.Version + 1},
+				want: api.ErrVersionMismatch,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.upd.UpdatedAt = time.Now().UTC()
+				if _, err := db.UpdateMessage(ctx(t), tt.upd); !errors.Is(err, tt.want) {
+					t.Errorf("Got error %v, want %v", err, tt.want)
+				}
+			})
+		}
+		history, err := db.MessageHistory(ctx(t), api.DefaultAppID, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 0 {
+			t.Errorf("Got %d versions after failed edits, want 0", len(history))
+		}
+	})
+	t.Run("MessageHistory/NotFound", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.MessageHistory(ctx(t), api.DefaultAppID, missingID); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("DeleteMessage", func(t *testing.T) {
+		db := newDB(t)
+		msgs := insertMessages(t, db, 2)
+		deletedAt := msgs[0].CreatedAt.Add(time.Minute)
+		got, err := db.DeleteMessage(ctx(t), api.MessageDeletion{
+			ID:        msgs[0].ID,
+			UserID:    msgs[0].UserID,
+			DeletedAt: deletedAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := msgs[0]
+		want.DeletedAt = deletedAt
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Returned message differs (-got +want)\n%s", diff)
+		}
+
+		list, err := db.ListMessages(ctx(t), api.ListOptions{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(list, msgs[1:]); diff != "" {
+			t.Errorf("Deleted message was listed (-got +want)\n%s", diff)
+		}
+		list, err = db.ListMessages(ctx(t), api.ListOptions{Limit: 10, IncludeDeleted: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(list, []api.Message{want, msgs[1]}); diff != "" {
+			t.Errorf("Deleted message was not listed with IncludeDeleted (-got +want)\n%s", diff)
+		}
+
+		// A deleted message can no longer be changed.
+		if _, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: msgs[0].ID, UserID: msgs[0].UserID}); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Deleting twice: got error %v, want %v", err, api.ErrNotFound)
+		}
+		if _, err := db.UpdateMessage(ctx(t), api.MessageUpdate{ID: msgs[0].ID, Text: "Edited", UserID: msgs[0].UserID}); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("UpdateMessage: got error %v, want %v", err, api.ErrNotFound)
+		}
+		if _, err := db.MessageHistory(ctx(t), api.DefaultAppID, msgs[0].ID); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("MessageHistory: got error %v, want %v", err, api.ErrNotFound)
+		}
+		_, _, err = db.InsertReaction(ctx(t), api.Reaction{MessageID: msgs[0].ID, Type: "like", Score: 1, UserID: "testuser"}, api.ReactionOptions{})
+		if !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("InsertReaction: got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("DeleteMessage/Errors", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		tests := []struct {
+			name string
+			del  api.MessageDeletion
+			want error
+		}{
+			{
+				name: "NotFound",
+				del:  api.MessageDeletion{ID: missingID, UserID: msg.UserID},
+				want: api.ErrNotFound,
+			},
+			{
+				name: "Forbidden",
+				del:  api.MessageDeletion{ID: msg.ID, UserID: "someone-else"},
+				want: api.ErrForbidden,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.del.DeletedAt = time.Now().UTC()
+				if _, err := db.DeleteMessage(ctx(t), tt.del); !errors.Is(err, tt.want) {
+					t.Errorf("Got error %v, want %v", err, tt.want)
+				}
+			})
+		}
+	})
+	t.Run("DeleteMessage/Moderator", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		got, err := db.DeleteMessage(ctx(t), api.MessageDeletion{
+			ID:        msg.ID,
+			UserID:    "moderator",
+			Moderator: true,
+			DeletedAt: msg.CreatedAt.Add(time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Deleted() {
+			t.Error("Returned message is not marked as deleted")
+		}
+	})
+	t.Run("PurgeMessages", func(t *testing.T) {
+		db := newDB(t)
+		msgs := insertMessages(t, db, 3)
+		if _, _, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: msgs[0].ID, Type: "like", Score: 1, UserID: "testuser"}, api.ReactionOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		start := msgs[0].CreatedAt
+		for i, msg := range msgs[:2] {
+			// Only the first message was deleted before the retention
+			// cutoff.
+			_, err := db.DeleteMessage(ctx(t), api.MessageDeletion{
+				ID:        msg.ID,
+				UserID:    msg.UserID,
+				DeletedAt: start.Add(time.Duration(i) * time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := db.PurgeMessages(ctx(t), start.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("Purged %d messages, want 1", n)
+		}
+		list, err := db.ListMessages(ctx(t), api.ListOptions{IncludeDeleted: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, msg := range list {
+			ids = append(ids, msg.ID)
+		}
+		if diff := cmp.Diff(ids, []string{msgs[1].ID, msgs[2].ID}); diff != "" {
+			t.Errorf("Remaining messages differ (-got +want)\n%s", diff)
+		}
+		sums, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{msgs[0].ID}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != 0 {
+			t.Errorf("Reactions of the purged message remain: %+v", sums)
+		}
+	})
+	t.Run("Replies", func(t *testing.T) {
+		db := newDB(t)
+		parent := insertMessages(t, db, 1)[0]
+		replies := insertReplies(t, db, parent, 2)
+		// Only the latest reply is shown in the channel.
+		shown := replies[0]
+
+		got, err := db.GetMessage(ctx(t), api.DefaultAppID, parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ReplyCount != 2 || !got.LatestReplyAt.Equal(shown.CreatedAt) {
+			t.Errorf("Got %d replies, the latest at %v; want 2, the latest at %v", got.ReplyCount, got.LatestReplyAt, shown.CreatedAt)
+		}
+		parent = got
+
+		list, err := db.ListMessages(ctx(t), api.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(list, []api.Message{shown, parent}); diff != "" {
+			t.Errorf("Messages of the channel differ (-got +want)\n%s", diff)
+		}
+		list, err = db.ListMessages(ctx(t), api.ListOptions{ParentID: parent.ID, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(list, replies); diff != "" {
+			t.Errorf("Replies differ (-got +want)\n%s", diff)
+		}
+
+		// Deleted replies are not counted.
+		_, err = db.DeleteMessage(ctx(t), api.MessageDeletion{ID: shown.ID, UserID: shown.UserID, DeletedAt: shown.CreatedAt.Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = db.GetMessage(ctx(t), api.DefaultAppID, parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ReplyCount != 1 || !got.LatestReplyAt.Equal(replies[1].CreatedAt) {
+			t.Errorf("Got %d replies, the latest at %v after deleting one; want 1, the latest at %v", got.ReplyCount, got.LatestReplyAt, replies[1].CreatedAt)
+		}
+		_, err = db.DeleteMessage(ctx(t), api.MessageDeletion{ID: replies[1].ID, UserID: replies[1].UserID, DeletedAt: shown.CreatedAt.Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = db.GetMessage(ctx(t), api.DefaultAppID, parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ReplyCount != 0 || !got.LatestReplyAt.IsZero() {
+			t.Errorf("Got %d replies, the latest at %v after deleting all; want none", got.ReplyCount, got.LatestReplyAt)
+		}
+	})
+	t.Run("Replies/NotFound", func(t *testing.T) {
+		db := newDB(t)
+		parent := insertMessages(t, db, 1)[0]
+		reply := api.Message{ParentID: missingID, Text: "reply", UserID: "testuser"}
+		if _, err := db.InsertMessage(ctx(t), reply); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v for a missing parent, want %v", err, api.ErrNotFound)
+		}
+
+		_, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: parent.ID, UserID: parent.UserID, DeletedAt: parent.CreatedAt.Add(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply.ParentID = parent.ID
+		if _, err := db.InsertMessage(ctx(t), reply); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v for a deleted parent, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("PurgeMessages/Replies", func(t *testing.T) {
+		db := newDB(t)
+		parent := insertMessages(t, db, 1)[0]
+		replies := insertReplies(t, db, parent, 2)
+		if _, _, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: parent.ID, Type: "like", Score: 1, UserID: "testuser"}, api.ReactionOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		deletedAt := parent.CreatedAt.Add(time.Minute)
+		_, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: parent.ID, UserID: parent.UserID, DeletedAt: deletedAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		purge := func(want int) {
+			t.Helper()
+			n, err := db.PurgeMessages(ctx(t), deletedAt.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != want {
+				t.Errorf("Purged %d messages, want %d", n, want)
+			}
+		}
+
+		// The live replies survive the purge of their parent, which is kept
+		// as a stub. Stubs are only counted once they are removed.
+		purge(0)
+		list, err := db.ListMessages(ctx(t), api.ListOptions{ParentID: parent.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != len(replies) {
+			t.Errorf("Got %d replies after the purge of their parent, want %d", len(list), len(replies))
+		}
+		stub, err := db.GetMessage(ctx(t), api.DefaultAppID, parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stub.Text != "" || !stub.Deleted() {
+			t.Errorf("Got parent %+v, want a deleted stub without text", stub)
+		}
+		sums, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{parent.ID}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != 0 {
+			t.Errorf("Reactions of the purged message remain: %+v", sums)
+		}
+
+		// Once its replies are purged, the stub goes as well.
+		for _, reply := range replies {
+			_, err := db.DeleteMessage(ctx(t), api.MessageDeletion{ID: reply.ID, UserID: reply.UserID, DeletedAt: deletedAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		purge(len(replies))
+		purge(1)
+		list, err = db.ListMessages(ctx(t), api.ListOptions{IncludeDeleted: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 0 {
+			t.Errorf("Messages remain after all were purged: %+v", list)
+		}
+	})
+	t.Run("InsertReaction", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		got, created, err := db.InsertReaction(ctx(t), api.Reaction{
+			MessageID: msg.ID,
+			Type:      "like",
+			Score:     3,
+			UserID:    "testuser",
+		}, api.ReactionOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !created {
+			t.Error("The reaction was not created")
+		}
+		if got.ID == "" {
+			t.Error("Returned reaction has empty ID")
+		}
+		if got.CreatedAt.IsZero() {
+			t.Error("Returned reaction does not have a CreatedAt field")
+		}
+		if got.MessageID != msg.ID || got.Type != "like" || got.Score != 3 || got.UserID != "testuser" {
+			t.Errorf("Returned reaction does not match the inserted one: %+v", got)
+		}
+	})
+	t.Run("InsertReaction/NotFound", func(t *testing.T) {
+		db := newDB(t)
+		_, _, err := db.InsertReaction(ctx(t), api.Reaction{
+			MessageID: missingID,
+			Type:      "like",
+			Score:     1,
+			UserID:    "testuser",
+		}, api.ReactionOptions{})
+		if !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("ReactionSummaries", func(t *testing.T) {
+		db := newDB(t)
+		msgs := insertMessages(t, db, 2)
+		var reactions []api.Reaction
+		for _, r := range []api.Reaction{
+			{Type: "like", Score: 1, UserID: "a"},
+			{Type: "clap", Score: 5, UserID: "b"},
+			{Type: "clap", Score: 2, UserID: "c"},
+		} {
+			r.MessageID = msgs[0].ID
+			r, _, err := db.InsertReaction(ctx(t), r, api.ReactionOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reactions = append(reactions, r)
+		}
+
+		got, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{msgs[0].ID, msgs[1].ID}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]api.ReactionSummary{
+			msgs[0].ID: {
+				Count:      3,
+				TotalScore: 8,
+				Counts:     map[string]int{"like": 1, "clap": 2},
+				Scores:     map[string]int{"like": 1, "clap": 7},
+				Latest:     sortReactions(reactions)[:2],
+			},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Diff (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("SetReaction", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		like := api.Reaction{MessageID: msg.ID, Type: "like", Score: 1, UserID: "a"}
+		created, ok, err := db.SetReaction(ctx(t), like, api.ReactionOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || created.ID == "" || created.Score != 1 {
+			t.Errorf("Got reaction %+v, created %t, want a new reaction", created, ok)
+		}
+
+		like.Score = 3
+		for i := 0; i < 2; i++ {
+			// Setting the same score again changes nothing.
+			got, ok, err := db.SetReaction(ctx(t), like, api.ReactionOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok || got.ID != created.ID || got.Score != 3 {
+				t.Errorf("Got reaction %+v, created %t, want %s with score 3", got, ok, created.ID)
+			}
+		}
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      1,
+			TotalScore: 3,
+			Counts:     map[string]int{"like": 1},
+			Scores:     map[string]int{"like": 3},
+		})
+	})
+	t.Run("InsertReaction/Accumulate", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		opts := api.ReactionOptions{MaxScore: 50}
+		var first api.Reaction
+		for i, want := range []int{20, 40, 50} {
+			r, created, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "clap", Score: 20, UserID: "a"}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != (i == 0) {
+				t.Errorf("Insert %d created a reaction: %t, want %t", i+1, created, i == 0)
+			}
+			if i == 0 {
+				first = r
+			}
+			if r.ID != first.ID || r.Score != want {
+				t.Errorf("Got reaction %s with score %d, want %s with score %d", r.ID, r.Score, first.ID, want)
+			}
+		}
+		// Other users have a cap of their own.
+		if _, _, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "clap", Score: 5, UserID: "b"}, opts); err != nil {
+			t.Fatal(err)
+		}
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      2,
+			TotalScore: 55,
+			Counts:     map[string]int{"clap": 2},
+			Scores:     map[string]int{"clap": 55},
+		})
+	})
+	t.Run("InsertReaction/Exclusive", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		opts := api.ReactionOptions{Exclusive: true}
+		for _, r := range []api.Reaction{
+			{Type: "like", Score: 1, UserID: "a"},
+			{Type: "like", Score: 1, UserID: "b"},
+			{Type: "clap", Score: 5, UserID: "a"},
+		} {
+			r.MessageID = msg.ID
+			if _, _, err := db.InsertReaction(ctx(t), r, opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The clap replaced the like of user a.
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      2,
+			TotalScore: 6,
+			Counts:     map[string]int{"like": 1, "clap": 1},
+			Scores:     map[string]int{"like": 1, "clap": 5},
+		})
+
+		if _, _, err := db.SetReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "wow", Score: 1, UserID: "a"}, opts); err != nil {
+			t.Fatal(err)
+		}
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      2,
+			TotalScore: 2,
+			Counts:     map[string]int{"like": 1, "wow": 1},
+			Scores:     map[string]int{"like": 1, "wow": 1},
+		})
+	})
+	t.Run("SetReaction/MaxScore", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		got, _, err := db.SetReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "clap", Score: 80, UserID: "a"}, api.ReactionOptions{MaxScore: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Score != 50 {
+			t.Errorf("Got score %d, want it capped at 50", got.Score)
+		}
+	})
+	t.Run("DeleteReaction", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+		for _, r := range []api.Reaction{
+			{Type: "like", Score: 1, UserID: "a"},
+			{Type: "clap", Score: 5, UserID: "a"},
+			{Type: "like", Score: 1, UserID: "b"},
+		} {
+			r.MessageID = msg.ID
+			if _, _, err := db.InsertReaction(ctx(t), r, api.ReactionOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, want := range []int{1, 0} {
+			n, err := db.DeleteReaction(ctx(t), api.DefaultAppID, msg.ID, "a", "like")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != want {
+				t.Errorf("Deleted %d reactions, want %d", n, want)
+			}
+		}
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      2,
+			TotalScore: 6,
+			Counts:     map[string]int{"like": 1, "clap": 1},
+			Scores:     map[string]int{"like": 1, "clap": 5},
+		})
+
+		// Types without reactions are left out of the summary.
+		if _, err := db.DeleteReaction(ctx(t), api.DefaultAppID, msg.ID, "a", "clap"); err != nil {
+			t.Fatal(err)
+		}
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      1,
+			TotalScore: 1,
+			Counts:     map[string]int{"like": 1},
+			Scores:     map[string]int{"like": 1},
+		})
+	})
+	t.Run("DeleteReaction/NotFound", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.DeleteReaction(ctx(t), api.DefaultAppID, missingID, "a", "like"); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+		if _, _, err := db.SetReaction(ctx(t), api.Reaction{MessageID: missingID, Type: "like", Score: 1, UserID: "a"}, api.ReactionOptions{}); !errors.Is(err, api.ErrNotFound) {
+			t.Errorf("Got error %v, want %v", err, api.ErrNotFound)
+		}
+	})
+	t.Run("Concurrency/Accumulate", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+
+		const n = 10
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "clap", Score: 3, UserID: "a"}, api.ReactionOptions{})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      1,
+			TotalScore: 3 * n,
+			Counts:     map[string]int{"clap": 1},
+			Scores:     map[string]int{"clap": 3 * n},
+		})
+	})
+	t.Run("Concurrency/Reactions", func(t *testing.T) {
+		db := newDB(t)
+		msg := insertMessages(t, db, 1)[0]
+
+		// Every user sets a reaction, then the odd users remove theirs.
+		// Each request is sent twice at once, like a retry.
+		const n = 10
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			user := fmt.Sprintf("user%d", i)
+			for j := 0; j < 2; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, _, err := db.SetReaction(ctx(t), api.Reaction{MessageID: msg.ID, Type: "clap", Score: 3, UserID: user}, api.ReactionOptions{})
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+		for i := 1; i < n; i += 2 {
+			user := fmt.Sprintf("user%d", i)
+			for j := 0; j < 2; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := db.DeleteReaction(ctx(t), api.DefaultAppID, msg.ID, user, "clap"); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+
+		assertSummary(t, db, msg.ID, api.ReactionSummary{
+			Count:      n / 2,
+			TotalScore: 3 * n / 2,
+			Counts:     map[string]int{"clap": n / 2},
+			Scores:     map[string]int{"clap": 3 * n / 2},
+		})
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		db := newDB(t)
+		target := insertMessages(t, db, 1)[0]
+
+		const n = 20
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := db.InsertMessage(ctx(t), api.Message{Text: fmt.Sprintf("Message %d", i), UserID: "testuser"})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				_, _, err := db.InsertReaction(ctx(t), api.Reaction{MessageID: target.ID, Type: "clap", Score: 2, UserID: fmt.Sprintf("user%d", i)}, api.ReactionOptions{})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		msgs, err := db.ListMessages(ctx(t), api.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != n+1 {
+			t.Errorf("Got %d messages, want %d", len(msgs), n+1)
+		}
+		sums, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{target.ID}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sums[target.ID]; sum.Count != n || sum.TotalScore != 2*n {
+			t.Errorf("Got %d reactions with a total score of %d, want %d and %d", sum.Count, sum.TotalScore, n, 2*n)
+		}
+	})
+}
+
+// TestCache tests a Cache implementation. The newCache function is called
+// for every test and must return an empty Cache.
+func TestCache(t *testing.T, newCache func(t *testing.T) api.Cache) {
+	t.Run("ListMessages/Empty", func(t *testing.T) {
+		c := newCache(t)
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || len(got) != 0 {
+			t.Errorf("Got %v, want an empty non-nil slice", got)
+		}
+	})
+	t.Run("ListMessages/Order", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(3)
+		// Insert out of order.
+		for _, i := range []int{1, 2, 0} {
+			if err := c.InsertMessage(ctx(t), msgs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs); diff != "" {
+			t.Errorf("Messages are not sorted newest first (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("InsertMessage/MaxSize", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(cacheSize + 1)
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if err := c.InsertMessage(ctx(t), msgs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[:cacheSize]); diff != "" {
+			t.Errorf("The oldest message was not evicted (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("UpdateMessage", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(2)
+		if err := c.InsertMessage(ctx(t), msgs[1]); err != nil {
+			t.Fatal(err)
+		}
+		edited := msgs[1]
+		edited.Text, edited.Version = "Edited", 2
+		edited.UpdatedAt = edited.CreatedAt.Add(time.Minute)
+		if err := c.UpdateMessage(ctx(t), edited); err != nil {
+			t.Fatal(err)
+		}
+		// Messages that are not cached are not added.
+		if err := c.UpdateMessage(ctx(t), msgs[0]); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, []api.Message{edited}); diff != "" {
+			t.Errorf("The cached message was not replaced (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("UpdateMessage/Tombstone", func(t *testing.T) {
+		c := newCache(t)
+		msg := testMessages(1)[0]
+		if err := c.InsertMessage(ctx(t), msg); err != nil {
+			t.Fatal(err)
+		}
+		msg.DeletedAt = msg.CreatedAt.Add(time.Minute)
+		if err := c.UpdateMessage(ctx(t), msg); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, []api.Message{msg}); diff != "" {
+			t.Errorf("The cached message was not marked as deleted (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("ListMessages/Channel", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(cacheSize + 1)
+		// The latest message is in another channel, so it neither shows up
+		// in the default channel nor evicts its oldest message.
+		msgs[0].ChannelID = missingID
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if err := c.InsertMessage(ctx(t), msgs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[1:]); diff != "" {
+			t.Errorf("Messages of the default channel differ (-got +want)\n%s", diff)
+		}
+		got, err = c.ListMessages(ctx(t), api.DefaultAppID, missingID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[:1]); diff != "" {
+			t.Errorf("Messages of the other channel differ (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("ListMessages/App", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(2)
+		msgs[0].AppID = OtherAppID
+		for _, msg := range msgs {
+			if err := c.InsertMessage(ctx(t), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[1:]); diff != "" {
+			t.Errorf("Messages of the default app differ (-got +want)\n%s", diff)
+		}
+		got, err = c.ListMessages(ctx(t), OtherAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[:1]); diff != "" {
+			t.Errorf("Messages of the other app differ (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("Clear", func(t *testing.T) {
+		c := newCache(t)
+		for _, msg := range testMessages(3) {
+			if err := c.InsertMessage(ctx(t), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Clear(ctx(t)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("Got %d messages after clearing the cache, want 0", len(got))
+		}
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		c := newCache(t)
+		msgs := testMessages(2 * cacheSize)
+		var wg sync.WaitGroup
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.InsertMessage(ctx(t), msg); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, err := c.ListMessages(ctx(t), api.DefaultAppID, api.DefaultChannelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, msgs[:cacheSize]); diff != "" {
+			t.Errorf("The cache does not hold the latest messages (-got +want)\n%s", diff)
+		}
+	})
+}
+
+// TestBus tests an EventBus implementation. The newBus function is called
+// for every test and must return a bus without other publishers.
+func TestBus(t *testing.T, newBus func(t *testing.T) api.EventBus) {
+	t.Run("Publish", func(t *testing.T) {
+		b := newBus(t)
+		// Every subscriber receives every event.
+		var subs []chan api.Event
+		for range 2 {
+			events := make(chan api.Event, 2)
+			err := b.Subscribe(ctx(t), func(e api.Event) { events <- e })
+			if err != nil {
+				t.Fatal(err)
+			}
+			subs = append(subs, events)
+		}
+
+		want := []api.Event{
+			{
+				Type:      api.EventMessageCreated,
+				ChannelID: api.DefaultChannelID,
+				MessageID: "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				Data:      []byte(`{"text":"hello"}`),
+			},
+			{
+				Type:      api.EventReactionNew,
+				ChannelID: api.DefaultChannelID,
+				MessageID: "0f7c3a52-8f4d-4d1b-9e6a-5b2c7d8e9f10",
+				ParentID:  "fc8d6f9a-9d1e-4a8b-a6b1-3f0c2b1e7d5a",
+				Data:      []byte(`{"type":"like"}`),
+			},
+		}
+		for _, e := range want {
+			if err := b.Publish(ctx(t), e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var ids []uint64
+		for i, events := range subs {
+			var got []api.Event
+			for range want {
+				select {
+				case e := <-events:
+					got = append(got, e)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Subscriber %d received %d events, want %d", i, len(got), len(want))
+				}
+			}
+			// Events get increasing ids, which are the same for every
+			// subscriber.
+			for j := range got {
+				if i == 0 {
+					if got[j].ID == 0 || j > 0 && got[j].ID <= ids[j-1] {
+						t.Errorf("Event %d got id %d after %v", j, got[j].ID, ids)
+					}
+					ids = append(ids, got[j].ID)
+				} else if got[j].ID != ids[j] {
+					t.Errorf("Subscriber %d got id %d for event %d, want %d", i, got[j].ID, j, ids[j])
+				}
+				got[j].ID = 0
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("Subscriber %d received other events (-got +want)\n%s", i, diff)
+			}
+		}
+	})
+}
+
+// TestRateLimiter tests a RateLimiter implementation. The newLimiter
+// function is called for every test and must return a limiter with empty
+// buckets.
+func TestRateLimiter(t *testing.T, newLimiter func(t *testing.T) api.RateLimiter) {
+	t.Run("Allow", func(t *testing.T) {
+		l := newLimiter(t)
+		limit := api.RateLimit{Requests: 3, Period: time.Minute}
+		for i := range limit.Requests {
+			res, err := l.Allow(ctx(t), "alice", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Allowed {
+				t.Fatalf("Request %d was denied", i+1)
+			}
+			if want := limit.Requests - i - 1; res.Remaining != want {
+				t.Errorf("Got %d remaining requests, want %d", res.Remaining, want)
+			}
+			if res.Reset <= 0 || res.Reset > limit.Period {
+				t.Errorf("Got reset %s, want at most %s", res.Reset, limit.Period)
+			}
+		}
+		res, err := l.Allow(ctx(t), "alice", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed || res.Remaining != 0 {
+			t.Errorf("Got %+v beyond the limit, want a denied request", res)
+		}
+		if interval := limit.Period / time.Duration(limit.Requests); res.RetryAfter <= 0 || res.RetryAfter > interval {
+			t.Errorf("Got retry after %s, want at most %s", res.RetryAfter, interval)
+		}
+
+		// Other keys have buckets of their own.
+		res, err = l.Allow(ctx(t), "bob", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Error("The request of another key was denied")
+		}
+	})
+	t.Run("Refill", func(t *testing.T) {
+		l := newLimiter(t)
+		limit := api.RateLimit{Requests: 1, Period: 200 * time.Millisecond}
+		if res, err := l.Allow(ctx(t), "alice", limit); err != nil || !res.Allowed {
+			t.Fatalf("Got %+v, %v, want an allowed request", res, err)
+		}
+		res, err := l.Allow(ctx(t), "alice", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			t.Fatal("Request beyond the limit was allowed")
+		}
+		time.Sleep(res.RetryAfter + 20*time.Millisecond)
+		if res, err := l.Allow(ctx(t), "alice", limit); err != nil || !res.Allowed {
+			t.Errorf("Got %+v, %v after the bucket refilled, want an allowed request", res, err)
+		}
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		l := newLimiter(t)
+		limit := api.RateLimit{Requests: 10, Period: time.Hour}
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed int
+		)
+		for range 3 * limit.Requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := l.Allow(ctx(t), "alice", limit)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != limit.Requests {
+			t.Errorf("Allowed %d concurrent requests, want %d", allowed, limit.Requests)
+		}
+	})
+}
+
+// TestIdempotencyStore tests an IdempotencyStore implementation. The
+// newStore function is called for every test and must return an empty store.
+func TestIdempotencyStore(t *testing.T, newStore func(t *testing.T) api.IdempotencyStore) {
+	t.Run("Reserve", func(t *testing.T) {
+		s := newStore(t)
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute); err != nil || !ok {
+			t.Fatalf("Got %t, %v, want the new key reserved", ok, err)
+		}
+		got, ok, err := s.Reserve(ctx(t), "key", "other", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("Reserved a key twice")
+		}
+		want := api.IdempotentResponse{Fingerprint: "fingerprint"}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Reservation differs (-got +want)\n%s", diff)
+		}
+
+		// Other keys are reserved on their own.
+		if _, ok, err := s.Reserve(ctx(t), "other", "fingerprint", time.Minute); err != nil || !ok {
+			t.Errorf("Got %t, %v, want another key reserved", ok, err)
+		}
+	})
+	t.Run("Complete", func(t *testing.T) {
+		s := newStore(t)
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute); err != nil || !ok {
+			t.Fatalf("Got %t, %v, want the new key reserved", ok, err)
+		}
+		want := api.IdempotentResponse{
+			Fingerprint: "fingerprint",
+			Done:        true,
+			Status:      201,
+			Header:      http.Header{"Content-Type": {"application/json"}},
+			Body:        []byte(`{"id": "1"}`),
+		}
+		if err := s.Complete(ctx(t), "key", want, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("Reserved a completed key")
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Response differs (-got +want)\n%s", diff)
+		}
+	})
+	t.Run("Release", func(t *testing.T) {
+		s := newStore(t)
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute); err != nil || !ok {
+			t.Fatalf("Got %t, %v, want the new key reserved", ok, err)
+		}
+		if err := s.Release(ctx(t), "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute); err != nil || !ok {
+			t.Errorf("Got %t, %v, want the released key reserved", ok, err)
+		}
+		if err := s.Release(ctx(t), "missing"); err != nil {
+			t.Errorf("Releasing a missing key failed: %v", err)
+		}
+	})
+	t.Run("Expiry", func(t *testing.T) {
+		s := newStore(t)
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", 50*time.Millisecond); err != nil || !ok {
+			t.Fatalf("Got %t, %v, want the new key reserved", ok, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute); err != nil || !ok {
+			t.Errorf("Got %t, %v, want the expired key reserved", ok, err)
+		}
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		s := newStore(t)
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			reserved int
+		)
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, ok, err := s.Reserve(ctx(t), "key", "fingerprint", time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok {
+					mu.Lock()
+					reserved++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if reserved != 1 {
+			t.Errorf("Reserved a key %d times concurrently, want once", reserved)
+		}
+	})
+}
+
+// ctx returns a context that is canceled when the test ends.
+func ctx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// insertMessages inserts n messages into db and returns them newest first.
+func insertMessages(t *testing.T, db api.DB, n int) []api.Message {
+	t.Helper()
+	msgs := make([]api.Message, n)
+	for i := range msgs {
+		msg, err := db.InsertMessage(ctx(t), api.Message{
+			Text:   fmt.Sprintf("Message %d", i+1),
+			UserID: "testuser",
+		})
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		msgs[i] = msg
+	}
+	// Messages inserted in quick succession may share a timestamp, so sort
+	// them the way a DB does rather than relying on the insertion order.
+	slices.SortFunc(msgs, func(a, b api.Message) int {
+		switch ca, cb := a.Cursor(), b.Cursor(); {
+		case cb.Before(ca):
+			return -1
+		case ca.Before(cb):
+			return 1
+		}
+		return 0
+	})
+	return msgs
+}
+
+// insertReplies inserts n replies to parent one second apart and returns
+// them newest first. The newest reply is shown in the channel.
+func insertReplies(t *testing.T, db api.DB, parent api.Message, n int) []api.Message {
+	t.Helper()
+	replies := make([]api.Message, n)
+	for i := range replies {
+		reply, err := db.InsertMessage(ctx(t), api.Message{
+			ID:            fmt.Sprintf("00000000-0000-4000-a000-%012d", i+1),
+			ChannelID:     parent.ChannelID,
+			ParentID:      parent.ID,
+			Text:          fmt.Sprintf("Reply %d", i+1),
+			UserID:        "testuser",
+			CreatedAt:     parent.CreatedAt.Add(time.Duration(i+1) * time.Second),
+			Version:       1,
+			ShowInChannel: i == n-1,
+		})
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		replies[n-1-i] = reply
+	}
+	return replies
+}
+
+// assertSummary checks the reaction counts and scores of a message.
+func assertSummary(t *testing.T, db api.DB, msgID string, want api.ReactionSummary) {
+	t.Helper()
+	sums, err := db.ReactionSummaries(ctx(t), api.DefaultAppID, []string{msgID}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(sums[msgID], want); diff != "" {
+		t.Errorf("Reaction summary differs (-got +want)\n%s", diff)
+	}
+}
+
+// sortReactions sorts reactions newest first.
+func sortReactions(reactions []api.Reaction) []api.Reaction {
+	reactions = slices.Clone(reactions)
+	slices.SortFunc(reactions, func(a, b api.Reaction) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return reactions
+}
+
+// testMessages returns n messages one second apart, newest first.
+func testMessages(n int) []api.Message {
+	start := time.Now().UTC().Truncate(time.Second).Add(-time.Duration(n) * time.Second)
+	msgs := make([]api.Message, n)
+	for i := range msgs {
+		msgs[i] = api.Message{
+			ID:        fmt.Sprintf("00000000-0000-4000-8000-%012d", n-i),
+			AppID:     api.DefaultAppID,
+			ChannelID: api.DefaultChannelID,
+			Text:      fmt.Sprintf("Message %d", n-i),
+			UserID:    "testuser",
+			CreatedAt: start.Add(time.Duration(n-i) * time.Second),
+			Version:   1,
+		}
+	}
+	return msgs
+}